@@ -0,0 +1,101 @@
+package chaos
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// the HTTP control API:
+//
+//	GET    /rules   the rules, in the order they are tried
+//	PUT    /rules   replace them with the JSON list in the body
+//	POST   /rules   add the JSON rule in the body at the end
+//	DELETE /rules   remove every rule
+//	GET    /stats   how many frames each action was applied to
+func (p *Proxy) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rules", p.handleRules)
+	mux.HandleFunc("/stats", p.handleStats)
+	return mux
+}
+
+func (p *Proxy) Rules() []*Rule {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*Rule{}, p.rules...)
+}
+
+func (p *Proxy) SetRules(rules []*Rule) error {
+	for _, r := range rules {
+		if err := r.validate(); err != nil {
+			return err
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules = append([]*Rule{}, rules...)
+	return nil
+}
+
+func (p *Proxy) AddRule(r *Rule) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules = append(p.rules, r)
+	return nil
+}
+
+func (p *Proxy) Stats() map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := map[string]int{}
+	for action, n := range p.stats {
+		stats[action] = n
+	}
+	return stats
+}
+
+func (p *Proxy) handleRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, p.Rules())
+	case http.MethodPut:
+		rules := []*Rule{}
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := p.SetRules(rules); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, p.Rules())
+	case http.MethodPost:
+		rule := &Rule{}
+		if err := json.NewDecoder(r.Body).Decode(rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := p.AddRule(rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, p.Rules())
+	case http.MethodDelete:
+		p.SetRules(nil)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (p *Proxy) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, p.Stats())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
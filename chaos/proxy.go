@@ -0,0 +1,240 @@
+package chaos
+
+//
+// a TCP proxy that sits between ClientEnds and a server.Server and
+// reads the srpc frames going through it, so that it can drop, delay,
+// duplicate, reorder or corrupt single calls by service and method.
+// the rules can be changed while it runs, over an HTTP control API.
+//
+// a dropped call is never answered; the caller waits for as long as
+// it would for a lost packet, until its call timeout.
+//
+
+import (
+	"bufio"
+	"math/rand"
+	"net"
+	"srpc/common/logging"
+	"srpc/common/protocol"
+	"sync"
+	"time"
+)
+
+type Proxy struct {
+	mu       sync.Mutex
+	upstream string // the server, as host:port
+	rules    []*Rule
+	rng      *rand.Rand
+	stats    map[string]int // action -> frames it was applied to, "pass" for the rest
+	listener net.Listener
+	done     chan struct{}
+	closing  sync.Once
+	logger   logging.Logger // nil for logging.Default
+}
+
+func MakeProxy(upstream string, seed int64) *Proxy {
+	p := &Proxy{}
+	p.upstream = upstream
+	p.rng = rand.New(rand.NewSource(seed))
+	p.stats = map[string]int{}
+	p.done = make(chan struct{})
+	return p
+}
+
+// where the proxy logs; nil for logging.Default.
+func (p *Proxy) SetLogger(l logging.Logger) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.logger = l
+}
+
+func (p *Proxy) log() logging.Logger {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return logging.Or(p.logger)
+}
+
+// accept clients on addr until Close.
+func (p *Proxy) Serve(addr string) error {
+	listen, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.listener = listen
+	p.mu.Unlock()
+
+	for {
+		conn, err := listen.Accept()
+		if err != nil {
+			select {
+			case <-p.done:
+				return nil
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go p.proxy(conn)
+	}
+}
+
+func (p *Proxy) Close() {
+	p.closing.Do(func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		close(p.done)
+		if p.listener != nil {
+			p.listener.Close()
+		}
+	})
+}
+
+// one pump per direction of a connection.
+type pump struct {
+	mu        sync.Mutex // guards w and held
+	w         *bufio.Writer
+	direction string
+	held      *protocol.Frame // a frame waiting to be overtaken
+}
+
+func (p *Proxy) proxy(client net.Conn) {
+	defer client.Close()
+	server, err := net.Dial("tcp", p.upstream)
+	if err != nil {
+		p.log().Log(logging.Warn, "chaos: dial upstream", logging.Peer(p.upstream), logging.Err(err))
+		return
+	}
+	defer server.Close()
+
+	// replies carry only the Seq of their request. a dropped request
+	// gets no reply, so it is forgotten when dropped.
+	var smu sync.Mutex
+	methods := map[uint64]string{}
+
+	up := &pump{w: bufio.NewWriter(server), direction: DirectionRequest}
+	down := &pump{w: bufio.NewWriter(client), direction: DirectionReply}
+	go func() {
+		p.run(server, down, func(f *protocol.Frame) string {
+			smu.Lock()
+			defer smu.Unlock()
+			svcMeth := methods[f.Seq]
+			delete(methods, f.Seq)
+			return svcMeth
+		}, func(f *protocol.Frame) {})
+		client.Close()
+	}()
+	p.run(client, up, func(f *protocol.Frame) string {
+		smu.Lock()
+		defer smu.Unlock()
+		methods[f.Seq] = f.SvcMeth
+		return f.SvcMeth
+	}, func(f *protocol.Frame) {
+		smu.Lock()
+		defer smu.Unlock()
+		delete(methods, f.Seq)
+	})
+}
+
+// read frames from r and pass them on through pu, as the rules say.
+// dropped is told of every frame that is not passed on.
+func (p *Proxy) run(r net.Conn, pu *pump, svcMeth func(*protocol.Frame) string, dropped func(*protocol.Frame)) {
+	br := bufio.NewReader(r)
+	for {
+		f, err := protocol.ReadFrame(br)
+		if err != nil {
+			return
+		}
+		rule := p.match(svcMeth(f), pu.direction)
+		if rule == nil {
+			pu.write(f)
+			continue
+		}
+		switch rule.Action {
+		case ActionDrop:
+			dropped(f)
+		case ActionDelay:
+			time.AfterFunc(rule.delay(), func() { pu.write(f) })
+		case ActionDuplicate:
+			pu.write(f)
+			pu.write(f)
+		case ActionReorder:
+			pu.hold(f, rule)
+		case ActionCorrupt:
+			p.corrupt(f)
+			pu.write(f)
+		}
+	}
+}
+
+// the first rule for svcMeth that fires, nil to pass the frame on.
+func (p *Proxy) match(svcMeth string, direction string) *Rule {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, r := range p.rules {
+		if r.matches(svcMeth, direction) && r.fires(p.rng) {
+			p.stats[r.Action] += 1
+			return r
+		}
+	}
+	p.stats["pass"] += 1
+	return nil
+}
+
+func (p *Proxy) corrupt(f *protocol.Frame) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(f.Body) == 0 {
+		return
+	}
+	body := append([]byte{}, f.Body...)
+	body[p.rng.Intn(len(body))] ^= byte(1 + p.rng.Intn(255))
+	f.Body = body
+}
+
+// write f, and then whatever frame was waiting to be overtaken.
+func (pu *pump) write(f *protocol.Frame) {
+	pu.mu.Lock()
+	defer pu.mu.Unlock()
+	pu.writeLocked(f)
+	if pu.held != nil {
+		pu.writeLocked(pu.held)
+		pu.held = nil
+	}
+}
+
+func (pu *pump) writeLocked(f *protocol.Frame) {
+	if err := protocol.WriteFrame(pu.w, f); err == nil {
+		pu.w.Flush()
+	}
+}
+
+// keep f back until the next frame has gone, or until the rule's
+// wait is over if no frame comes.
+func (pu *pump) hold(f *protocol.Frame, rule *Rule) {
+	pu.mu.Lock()
+	defer pu.mu.Unlock()
+	if pu.held != nil {
+		// one frame held at a time; f overtakes the held one.
+		pu.writeLocked(f)
+		pu.writeLocked(pu.held)
+		pu.held = nil
+		return
+	}
+	pu.held = f
+	wait := rule.delay()
+	if wait <= 0 {
+		wait = defaultReorderWait
+	}
+	time.AfterFunc(wait, func() {
+		pu.mu.Lock()
+		defer pu.mu.Unlock()
+		if pu.held == f {
+			pu.writeLocked(f)
+			pu.held = nil
+		}
+	})
+}
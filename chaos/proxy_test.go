@@ -0,0 +1,266 @@
+package chaos
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"srpc/common/protocol"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a server that answers every request with its own body, and tells
+// received of each request's Seq.
+func echoServer(t *testing.T) (addr string, received chan uint64) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	received = make(chan uint64, 100)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					f, err := protocol.ReadFrame(r)
+					if err != nil {
+						return
+					}
+					received <- f.Seq
+					protocol.WriteFrame(conn, &protocol.Frame{Seq: f.Seq, Body: f.Body, Ok: true})
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), received
+}
+
+// a proxy in front of an echo server, and a connection to it.
+type fixture struct {
+	p        *Proxy
+	conn     net.Conn
+	r        *bufio.Reader
+	received chan uint64
+}
+
+func startProxy(t *testing.T, rules ...*Rule) *fixture {
+	upstream, received := echoServer(t)
+	p := MakeProxy(upstream, 1)
+	if err := p.SetRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve("127.0.0.1:0")
+	t.Cleanup(p.Close)
+	var addr string
+	for addr == "" {
+		p.mu.Lock()
+		if p.listener != nil {
+			addr = p.listener.Addr().String()
+		}
+		p.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &fixture{p, conn, bufio.NewReader(conn), received}
+}
+
+func (fx *fixture) send(t *testing.T, seq uint64, svcMeth string) {
+	f := &protocol.Frame{Seq: seq, SvcMeth: svcMeth, Body: []byte("body of a call")}
+	if err := protocol.WriteFrame(fx.conn, f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// the next reply, nil if none comes within wait.
+func (fx *fixture) reply(wait time.Duration) *protocol.Frame {
+	fx.conn.SetReadDeadline(time.Now().Add(wait))
+	f, err := protocol.ReadFrame(fx.r)
+	if err != nil {
+		return nil
+	}
+	return f
+}
+
+func TestProxyPassesCalls(t *testing.T) {
+	fx := startProxy(t)
+	fx.send(t, 1, "KV.Get")
+	if f := fx.reply(time.Second); f == nil || f.Seq != 1 || string(f.Body) != "body of a call" {
+		t.Fatalf("reply %+v", f)
+	}
+	if n := fx.p.Stats()["pass"]; n != 2 {
+		t.Fatalf("%d frames passed, want the request and its reply", n)
+	}
+}
+
+func TestProxyDropsMatchingCalls(t *testing.T) {
+	fx := startProxy(t, &Rule{Service: "KV", Method: "Put", Direction: DirectionRequest, Action: ActionDrop})
+	fx.send(t, 1, "KV.Put")
+	fx.send(t, 2, "KV.Get")
+	if f := fx.reply(time.Second); f == nil || f.Seq != 2 {
+		t.Fatalf("reply %+v, want KV.Get's", f)
+	}
+	if f := fx.reply(100 * time.Millisecond); f != nil {
+		t.Fatalf("dropped call answered: %+v", f)
+	}
+	if n := len(fx.received); n != 1 {
+		t.Fatalf("server got %d calls, want 1", n)
+	}
+	if n := fx.p.Stats()[ActionDrop]; n != 1 {
+		t.Fatalf("%d frames dropped", n)
+	}
+}
+
+func TestProxyDropsReplies(t *testing.T) {
+	fx := startProxy(t, &Rule{Service: "KV", Direction: DirectionReply, Action: ActionDrop})
+	fx.send(t, 1, "KV.Get")
+	fx.send(t, 2, "Other.Get")
+	if f := fx.reply(time.Second); f == nil || f.Seq != 2 {
+		t.Fatalf("reply %+v, want Other.Get's", f)
+	}
+	if f := fx.reply(100 * time.Millisecond); f != nil {
+		t.Fatalf("dropped reply arrived: %+v", f)
+	}
+	if n := len(fx.received); n != 2 {
+		t.Fatalf("server got %d calls, want both", n)
+	}
+}
+
+func TestProxyDelaysCalls(t *testing.T) {
+	fx := startProxy(t, &Rule{Action: ActionDelay, Direction: DirectionReply, Delay_ms: 100})
+	start := time.Now()
+	fx.send(t, 1, "KV.Get")
+	if f := fx.reply(time.Second); f == nil {
+		t.Fatal("delayed call not answered")
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("answered after %v, want at least 100ms", d)
+	}
+}
+
+func TestProxyDuplicatesCalls(t *testing.T) {
+	fx := startProxy(t, &Rule{Action: ActionDuplicate, Direction: DirectionRequest})
+	fx.send(t, 1, "KV.Get")
+	for i := 0; i < 2; i++ {
+		select {
+		case seq := <-fx.received:
+			if seq != 1 {
+				t.Fatalf("server got seq %d", seq)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("server got %d copies, want 2", i)
+		}
+	}
+}
+
+func TestProxyReordersCalls(t *testing.T) {
+	fx := startProxy(t, &Rule{Action: ActionReorder, Direction: DirectionRequest, Delay_ms: 1000})
+	fx.send(t, 1, "KV.Get")
+	fx.send(t, 2, "KV.Get")
+	if a, b := <-fx.received, <-fx.received; a != 2 || b != 1 {
+		t.Fatalf("server got %d then %d, want 2 then 1", a, b)
+	}
+
+	// a held frame with nothing to overtake it goes after the wait.
+	fx.p.SetRules([]*Rule{{Action: ActionReorder, Direction: DirectionRequest, Delay_ms: 50}})
+	fx.send(t, 3, "KV.Get")
+	select {
+	case seq := <-fx.received:
+		if seq != 3 {
+			t.Fatalf("server got seq %d", seq)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("held frame never sent")
+	}
+}
+
+func TestProxyCorruptsCalls(t *testing.T) {
+	fx := startProxy(t, &Rule{Action: ActionCorrupt, Direction: DirectionReply})
+	fx.send(t, 1, "KV.Get")
+	f := fx.reply(time.Second)
+	if f == nil {
+		t.Fatal("corrupted call not answered")
+	}
+	if bytes.Equal(f.Body, []byte("body of a call")) {
+		t.Fatal("reply body unchanged")
+	}
+}
+
+func TestRuleProbability(t *testing.T) {
+	fx := startProxy(t, &Rule{Action: ActionDrop, Direction: DirectionRequest, Probability: 0.5})
+	for i := 0; i < 200; i++ {
+		fx.p.match("KV.Get", DirectionRequest)
+	}
+	stats := fx.p.Stats()
+	if stats[ActionDrop] < 60 || stats[ActionDrop] > 140 {
+		t.Fatalf("dropped %d of 200 at probability 0.5", stats[ActionDrop])
+	}
+}
+
+func TestRulesAreValidated(t *testing.T) {
+	p := MakeProxy("127.0.0.1:1", 1)
+	for _, r := range []*Rule{
+		{Action: "explode"},
+		{Action: ActionDrop, Direction: "sideways"},
+		{Action: ActionDrop, Probability: 1.5},
+	} {
+		if err := p.AddRule(r); err == nil {
+			t.Errorf("accepted %+v", r)
+		}
+		if err := p.SetRules([]*Rule{{Action: ActionDrop}, r}); err == nil {
+			t.Errorf("accepted %+v among others", r)
+		}
+	}
+	if len(p.Rules()) != 0 {
+		t.Fatalf("kept rules %v", p.Rules())
+	}
+}
+
+func TestControlAPI(t *testing.T) {
+	p := MakeProxy("127.0.0.1:1", 1)
+	ts := httptest.NewServer(p.Handler())
+	defer ts.Close()
+	do := func(method, body string) int {
+		req, _ := http.NewRequest(method, ts.URL+"/rules", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := do(http.MethodPut, `[{"service": "KV", "action": "drop"}]`); code != http.StatusOK {
+		t.Fatalf("PUT: %d", code)
+	}
+	if code := do(http.MethodPost, `{"action": "delay", "delay_ms": 5}`); code != http.StatusOK {
+		t.Fatalf("POST: %d", code)
+	}
+	if rules := p.Rules(); len(rules) != 2 || rules[0].Service != "KV" || rules[1].Delay_ms != 5 {
+		t.Fatalf("rules %+v", rules)
+	}
+	if code := do(http.MethodPost, `{"action": "explode"}`); code != http.StatusBadRequest {
+		t.Fatalf("POST of a bad rule: %d", code)
+	}
+	if code := do(http.MethodDelete, ""); code != http.StatusNoContent || len(p.Rules()) != 0 {
+		t.Fatalf("DELETE: %d, rules %v", code, p.Rules())
+	}
+}
+
+func TestProxyCloseTwice(t *testing.T) {
+	p := MakeProxy("127.0.0.1:1", 1)
+	p.Close()
+	p.Close()
+}
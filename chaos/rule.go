@@ -0,0 +1,77 @@
+package chaos
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	ActionDrop      = "drop"      // never pass the frame on
+	ActionDelay     = "delay"     // pass it on after Delay_ms
+	ActionDuplicate = "duplicate" // pass it on twice
+	ActionReorder   = "reorder"   // let the next frame overtake it
+	ActionCorrupt   = "corrupt"   // flip a byte of its body
+)
+
+const (
+	DirectionRequest = "request"
+	DirectionReply   = "reply"
+)
+
+// what the proxy does to the frames of matching calls. empty
+// Service, Method or Direction match anything; Probability 0
+// counts as 1.
+type Rule struct {
+	Service     string  `json:"service"`
+	Method      string  `json:"method"`
+	Direction   string  `json:"direction"`
+	Action      string  `json:"action"`
+	Probability float64 `json:"probability"`
+	Delay_ms    int     `json:"delay_ms"` // for delay, and how long reorder waits at most
+}
+
+const defaultReorderWait = 100 * time.Millisecond
+
+func (r *Rule) validate() error {
+	switch r.Action {
+	case ActionDrop, ActionDelay, ActionDuplicate, ActionReorder, ActionCorrupt:
+	default:
+		return fmt.Errorf("chaos: unknown action %q", r.Action)
+	}
+	switch r.Direction {
+	case "", DirectionRequest, DirectionReply:
+	default:
+		return fmt.Errorf("chaos: unknown direction %q", r.Direction)
+	}
+	if r.Probability < 0 || r.Probability > 1 {
+		return fmt.Errorf("chaos: probability %v out of [0, 1]", r.Probability)
+	}
+	return nil
+}
+
+func (r *Rule) matches(svcMeth string, direction string) bool {
+	if r.Direction != "" && r.Direction != direction {
+		return false
+	}
+	dot := strings.LastIndex(svcMeth, ".")
+	if dot < 0 {
+		return r.Service == "" && r.Method == ""
+	}
+	if r.Service != "" && r.Service != svcMeth[:dot] {
+		return false
+	}
+	if r.Method != "" && r.Method != svcMeth[dot+1:] {
+		return false
+	}
+	return true
+}
+
+func (r *Rule) fires(rng *rand.Rand) bool {
+	return r.Probability == 0 || rng.Float64() < r.Probability
+}
+
+func (r *Rule) delay() time.Duration {
+	return time.Duration(r.Delay_ms) * time.Millisecond
+}
@@ -0,0 +1,247 @@
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"srpc/common/logging"
+	"sync"
+)
+
+// a Balancer picks which endpoint serves a call. Pick is handed
+// the enabled endpoints of svcMeth that pass the service's selector,
+// never an empty list. Done reports how the call on the picked
+// endpoint went, err being nil on success. a ClientEnd keeps one
+// Balancer per service, and may call it from many goroutines.
+type Balancer interface {
+	Pick(svcMeth string, services []*Service) *Service
+	Done(service *Service, err error)
+}
+
+// a Balancer that can route by a key the caller supplies, as in
+// ClientEnd.CallWithKey. calls without a key still go to Pick. all
+// is every endpoint of svcMeth that passes the selector, services
+// those of them that may be picked now: a key should keep its
+// endpoint while others are ejected or skipped by a retry.
+type KeyedBalancer interface {
+	Balancer
+	PickKey(svcMeth string, key string, all []*Service, services []*Service) *Service
+}
+
+const (
+	RoundRobin         = "round_robin"
+	Random             = "random"
+	WeightedRoundRobin = "weighted_round_robin"
+	LeastOutstanding   = "least_outstanding"
+	PowerOfTwoChoices  = "p2c"
+	ConsistentHash     = "consistent_hash"
+
+	DefaultBalancer = RoundRobin
+)
+
+var balancersMu sync.Mutex
+var balancerFactories = map[string]func() Balancer{
+	RoundRobin:         func() Balancer { return &roundRobinBalancer{next: map[string]int{}} },
+	Random:             func() Balancer { return &randomBalancer{} },
+	WeightedRoundRobin: func() Balancer { return &weightedBalancer{current: map[string]int{}} },
+	LeastOutstanding:   func() Balancer { return &leastOutstandingBalancer{outstanding: map[string]int{}} },
+	PowerOfTwoChoices:  func() Balancer { return &p2cBalancer{leastOutstandingBalancer{outstanding: map[string]int{}}} },
+	ConsistentHash:     func() Balancer { return newHashBalancer() },
+}
+
+// make a custom balancer available by name to client configs and
+// ClientEnd.UseBalancer. registering an existing name replaces it.
+func RegisterBalancer(name string, factory func() Balancer) {
+	balancersMu.Lock()
+	defer balancersMu.Unlock()
+	balancerFactories[name] = factory
+}
+
+func NewBalancer(name string) (Balancer, error) {
+	balancersMu.Lock()
+	defer balancersMu.Unlock()
+	factory, ok := balancerFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("srpc: unknown balancer %q", name)
+	}
+	return factory(), nil
+}
+
+// balance calls to serviceName with the balancer registered as name.
+func (e *ClientEnd) UseBalancer(serviceName, name string) error {
+	b, err := NewBalancer(name)
+	if err != nil {
+		return err
+	}
+	e.SetBalancer(serviceName, b)
+	return nil
+}
+
+// balance calls to serviceName with b.
+func (e *ClientEnd) SetBalancer(serviceName string, b Balancer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.balancers == nil {
+		e.balancers = map[string]Balancer{}
+	}
+	e.balancers[serviceName] = b
+}
+
+func (e *ClientEnd) balancer(serviceName string) Balancer {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.balancerLocked(serviceName)
+}
+
+// the balancer of a service, made from the configuration on first
+// use. the caller holds e.mu.
+func (e *ClientEnd) balancerLocked(serviceName string) Balancer {
+	if b, ok := e.balancers[serviceName]; ok {
+		return b
+	}
+	name := DefaultBalancer
+	if e.network != nil && e.network.Balancers[serviceName] != "" {
+		name = e.network.Balancers[serviceName]
+	}
+	b, err := NewBalancer(name)
+	if err != nil {
+		logging.Or(e.logger).Log(logging.Warn, "ClientEnd: unknown balancer", logging.Service(serviceName),
+			logging.Err(err), logging.F("using", DefaultBalancer))
+		b, _ = NewBalancer(DefaultBalancer)
+	}
+	if e.balancers == nil {
+		e.balancers = map[string]Balancer{}
+	}
+	e.balancers[serviceName] = b
+	return b
+}
+
+func (service *Service) address() string {
+	return net.JoinHostPort(service.Server_ip, service.Server_port)
+}
+
+// the most weight an endpoint counts for. weights come from the
+// registry, which any server may register with.
+const maxWeight = 100
+
+func (service *Service) weight() int {
+	if service.Metadata.Weight <= 0 {
+		return 1
+	}
+	if service.Metadata.Weight > maxWeight {
+		return maxWeight
+	}
+	return service.Metadata.Weight
+}
+
+// take turns, per method.
+type roundRobinBalancer struct {
+	mu   sync.Mutex
+	next map[string]int
+}
+
+func (b *roundRobinBalancer) Pick(svcMeth string, services []*Service) *Service {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := b.next[svcMeth] % len(services)
+	b.next[svcMeth] = i + 1
+	return services[i]
+}
+
+func (b *roundRobinBalancer) Done(service *Service, err error) {}
+
+type randomBalancer struct {
+	mu sync.Mutex
+}
+
+func (b *randomBalancer) Pick(svcMeth string, services []*Service) *Service {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return services[rand.Intn(len(services))]
+}
+
+func (b *randomBalancer) Done(service *Service, err error) {}
+
+// smooth weighted round robin, as in nginx: every pick raises each
+// endpoint's credit by its weight and lowers the winner's by the
+// total, which spreads heavy endpoints out instead of bunching them.
+type weightedBalancer struct {
+	mu      sync.Mutex
+	current map[string]int // svcMeth/address -> credit
+}
+
+func (b *weightedBalancer) Pick(svcMeth string, services []*Service) *Service {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	total := 0
+	var best *Service
+	bestKey := ""
+	for _, service := range services {
+		key := svcMeth + "/" + service.address()
+		b.current[key] += service.weight()
+		total += service.weight()
+		if best == nil || b.current[key] > b.current[bestKey] {
+			best, bestKey = service, key
+		}
+	}
+	b.current[bestKey] -= total
+	return best
+}
+
+func (b *weightedBalancer) Done(service *Service, err error) {}
+
+// send each call to the endpoint with the fewest calls in flight,
+// taking turns among ties.
+type leastOutstandingBalancer struct {
+	mu          sync.Mutex
+	outstanding map[string]int // address -> calls in flight
+	turn        int
+}
+
+func (b *leastOutstandingBalancer) Pick(svcMeth string, services []*Service) *Service {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.turn += 1
+	var best *Service
+	for i := range services {
+		service := services[(b.turn+i)%len(services)]
+		if best == nil || b.outstanding[service.address()] < b.outstanding[best.address()] {
+			best = service
+		}
+	}
+	b.outstanding[best.address()] += 1
+	return best
+}
+
+func (b *leastOutstandingBalancer) Done(service *Service, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.outstanding[service.address()] > 0 {
+		b.outstanding[service.address()] -= 1
+	}
+}
+
+// pick two endpoints at random and use the less loaded one, which
+// gets most of the benefit of least-outstanding without having
+// every client pile onto the same idle endpoint.
+type p2cBalancer struct {
+	leastOutstandingBalancer
+}
+
+func (b *p2cBalancer) Pick(svcMeth string, services []*Service) *Service {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := rand.Intn(len(services))
+	best := services[i]
+	if len(services) > 1 {
+		j := rand.Intn(len(services) - 1)
+		if j >= i {
+			j += 1
+		}
+		if b.outstanding[services[j].address()] < b.outstanding[best.address()] {
+			best = services[j]
+		}
+	}
+	b.outstanding[best.address()] += 1
+	return best
+}
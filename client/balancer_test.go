@@ -0,0 +1,125 @@
+package client
+
+import (
+	"strconv"
+	"testing"
+)
+
+func testServices(n int) []*Service {
+	services := []*Service{}
+	for i := 0; i < n; i++ {
+		services = append(services, &Service{
+			Service_name:    "KV",
+			Method_name:     "Get",
+			Server_ip:       "10.0.0." + strconv.Itoa(i+1),
+			Server_port:     "1",
+			Service_enabled: true,
+		})
+	}
+	return services
+}
+
+func pickCounts(b Balancer, services []*Service, n int) map[string]int {
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		service := b.Pick("KV.Get", services)
+		counts[service.address()] += 1
+		b.Done(service, nil)
+	}
+	return counts
+}
+
+func TestRoundRobinTakesTurns(t *testing.T) {
+	b, _ := NewBalancer(RoundRobin)
+	services := testServices(3)
+	for i := 0; i < 6; i++ {
+		if got := b.Pick("KV.Get", services); got != services[i%3] {
+			t.Fatalf("pick %d went to %v, want %v", i, got.address(), services[i%3].address())
+		}
+	}
+}
+
+func TestWeightedSpreadsByWeight(t *testing.T) {
+	b, _ := NewBalancer(WeightedRoundRobin)
+	services := testServices(3)
+	services[0].Metadata.Weight = 5
+	run, longest := 0, 0
+	counts := map[string]int{}
+	for i := 0; i < 70; i++ {
+		service := b.Pick("KV.Get", services)
+		counts[service.address()] += 1
+		if service == services[0] {
+			run += 1
+		} else {
+			run = 0
+		}
+		if run > longest {
+			longest = run
+		}
+	}
+	if counts[services[0].address()] != 50 || counts[services[1].address()] != 10 ||
+		counts[services[2].address()] != 10 {
+		t.Fatalf("counts %v, want 50, 10 and 10", counts)
+	}
+	// smooth: the heavy endpoint's picks are spread out, rather than
+	// all five of a round in a row.
+	if longest >= 5 {
+		t.Fatalf("%d picks of the heavy endpoint in a row", longest)
+	}
+}
+
+func TestLeastOutstandingAvoidsBusy(t *testing.T) {
+	for _, name := range []string{LeastOutstanding, PowerOfTwoChoices} {
+		b, _ := NewBalancer(name)
+		services := testServices(2)
+		busy := b.Pick("KV.Get", services)
+		for i := 0; i < 10; i++ {
+			service := b.Pick("KV.Get", services)
+			if service == busy {
+				t.Fatalf("%v: picked the endpoint with a call in flight", name)
+			}
+			b.Done(service, nil)
+		}
+		b.Done(busy, nil)
+		if counts := pickCounts(b, services, 10); len(counts) != 2 {
+			t.Fatalf("%v: idle endpoints not both used: %v", name, counts)
+		}
+	}
+}
+
+type firstBalancer struct{}
+
+func (firstBalancer) Pick(svcMeth string, services []*Service) *Service { return services[0] }
+func (firstBalancer) Done(service *Service, err error)                  {}
+
+func TestRegisterBalancer(t *testing.T) {
+	if _, err := NewBalancer("nonesuch"); err == nil {
+		t.Fatal("made a balancer never registered")
+	}
+	RegisterBalancer("first", func() Balancer { return firstBalancer{} })
+	e, _ := MakeClientEnd()
+	if err := e.UseBalancer("KV", "first"); err != nil {
+		t.Fatal(err)
+	}
+	for _, service := range testServices(3) {
+		e.AddService(service)
+	}
+	for i := 0; i < 3; i++ {
+		if got := e.chooseService("KV.Get", "", nil); got.Server_ip != "10.0.0.1" {
+			t.Fatalf("custom balancer not used: %v", got.address())
+		}
+	}
+}
+
+func TestBalancerFromConfig(t *testing.T) {
+	e, err := MakeClientEndFromConfigText(`{"balancers":{"KV":"weighted_round_robin"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e.balancer("KV").(*weightedBalancer); !ok {
+		t.Fatalf("balancer %T, want the weighted one", e.balancer("KV"))
+	}
+	if _, ok := e.balancer("Other").(*roundRobinBalancer); !ok {
+		t.Fatalf("default balancer %T, want round robin", e.balancer("Other"))
+	}
+}
@@ -0,0 +1,323 @@
+package client
+
+import (
+	"srpc/common/protocol"
+	"sync"
+	"time"
+)
+
+// when a ClientEnd stops sending calls to an endpoint of a service.
+//
+// the circuit breaker of an endpoint opens after Consecutive_failures
+// failures in a row, or when at least Min_requests calls were made
+// within Window and more than Error_rate of them failed. an open
+// endpoint gets no calls for Open_timeout; it is then half-open and
+// gets up to Half_open_requests trial calls, closing again if they
+// succeed and opening again if one fails.
+//
+// every Interval the endpoints of the service are also compared with
+// each other, and one whose success rate within Window is below
+// Outlier_ratio of the service's average is ejected as if its breaker
+// had opened, for Ejection_time times the number of times it has been
+// ejected. no more than Max_ejection_percent of the endpoints are
+// ejected this way at once. Half_open_requests and
+// Max_ejection_percent of 0 or less stand for 1 and 50.
+type BreakerPolicy struct {
+	Consecutive_failures int
+	Error_rate           float64
+	Min_requests         int
+	Window               time.Duration
+	Open_timeout         time.Duration
+	Half_open_requests   int
+
+	Interval             time.Duration
+	Outlier_ratio        float64
+	Ejection_time        time.Duration
+	Max_ejection_percent int
+}
+
+func DefaultBreakerPolicy() *BreakerPolicy {
+	return &BreakerPolicy{
+		Consecutive_failures: 5,
+		Error_rate:           0.5,
+		Min_requests:         20,
+		Window:               10 * time.Second,
+		Open_timeout:         5 * time.Second,
+		Half_open_requests:   1,
+		Interval:             10 * time.Second,
+		Outlier_ratio:        0.7,
+		Ejection_time:        30 * time.Second,
+		Max_ejection_percent: 50,
+	}
+}
+
+func (p BreakerPolicy) withDefaults() *BreakerPolicy {
+	if p.Half_open_requests <= 0 {
+		p.Half_open_requests = 1
+	}
+	if p.Max_ejection_percent <= 0 {
+		p.Max_ejection_percent = 50
+	}
+	return &p
+}
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// what a ClientEnd knows about the health of one endpoint.
+type EndpointStat struct {
+	Service_name         string
+	Address              string
+	State                string
+	Requests             int // within the current window
+	Failures             int // within the current window
+	Consecutive_failures int
+	Ejections            int // times opened, by the breaker or as an outlier
+	Open_until           time.Time
+}
+
+type breaker struct {
+	stat        EndpointStat
+	windowStart time.Time
+	trials      int // trial calls in flight while half-open
+}
+
+type breakerSet struct {
+	mu        sync.Mutex
+	endpoints map[string]*breaker  // service/address -> breaker
+	checked   map[string]time.Time // service -> last outlier check
+}
+
+func makeBreakerSet() *breakerSet {
+	return &breakerSet{endpoints: map[string]*breaker{}, checked: map[string]time.Time{}}
+}
+
+// open the breakers of a service under p, which is copied; a nil p
+// turns them off.
+func (e *ClientEnd) SetBreakerPolicy(serviceName string, p *BreakerPolicy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.network == nil {
+		e.network = &Network{}
+	}
+	if e.network.Breakers == nil {
+		e.network.Breakers = map[string]*BreakerPolicy{}
+	}
+	if p == nil {
+		delete(e.network.Breakers, serviceName)
+	} else {
+		e.network.Breakers[serviceName] = p.withDefaults()
+	}
+}
+
+// the health of every endpoint this client has called under a
+// breaker policy.
+func (e *ClientEnd) EndpointStats() []EndpointStat {
+	e.mu.Lock()
+	bs := e.breakers
+	e.mu.Unlock()
+	if bs == nil {
+		return nil
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	now := time.Now()
+	stats := []EndpointStat{}
+	for _, b := range bs.endpoints {
+		b.advance(now)
+		stats = append(stats, b.stat)
+	}
+	return stats
+}
+
+// the breaker policy of a service, nil if it has none.
+// the caller holds e.mu.
+func (e *ClientEnd) breakerPolicyLocked(serviceName string) *BreakerPolicy {
+	if e.network == nil {
+		return nil
+	}
+	return e.network.Breakers[serviceName]
+}
+
+// the caller holds e.mu.
+func (e *ClientEnd) breakerSetLocked() *breakerSet {
+	if e.breakers == nil {
+		e.breakers = makeBreakerSet()
+	}
+	return e.breakers
+}
+
+// the caller holds bs.mu.
+func (bs *breakerSet) get(service *Service) *breaker {
+	key := service.Service_name + "/" + service.address()
+	b, ok := bs.endpoints[key]
+	if !ok {
+		b = &breaker{windowStart: time.Now()}
+		b.stat.Service_name = service.Service_name
+		b.stat.Address = service.address()
+		b.stat.State = BreakerClosed
+		bs.endpoints[key] = b
+	}
+	return b
+}
+
+// move an open breaker whose time is up to half-open.
+func (b *breaker) advance(now time.Time) {
+	if b.stat.State == BreakerOpen && !now.Before(b.stat.Open_until) {
+		b.stat.State = BreakerHalfOpen
+		b.trials = 0
+	}
+}
+
+func (b *breaker) resetWindow(now time.Time) {
+	b.windowStart = now
+	b.stat.Requests = 0
+	b.stat.Failures = 0
+}
+
+func (b *breaker) open(now time.Time, d time.Duration) {
+	b.stat.State = BreakerOpen
+	b.stat.Open_until = now.Add(d)
+	b.stat.Ejections += 1
+	b.trials = 0
+}
+
+// leave out the endpoints whose breakers are open, or half-open
+// with all their trial calls in flight. the caller holds e.mu.
+func (e *ClientEnd) healthyServices(services []*Service) []*Service {
+	p := e.breakerPolicyLocked(services[0].Service_name)
+	if p == nil {
+		return services
+	}
+	bs := e.breakerSetLocked()
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	now := time.Now()
+	healthy := []*Service{}
+	for _, service := range services {
+		b := bs.get(service)
+		b.advance(now)
+		switch b.stat.State {
+		case BreakerOpen:
+			continue
+		case BreakerHalfOpen:
+			if b.trials >= p.Half_open_requests {
+				continue
+			}
+		}
+		healthy = append(healthy, service)
+	}
+	return healthy
+}
+
+// note that a call is about to go to service, which may be a
+// trial call of a half-open breaker. the caller holds e.mu.
+func (e *ClientEnd) acquireBreaker(service *Service) {
+	if e.breakerPolicyLocked(service.Service_name) == nil {
+		return
+	}
+	bs := e.breakerSetLocked()
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if b := bs.get(service); b.stat.State == BreakerHalfOpen {
+		b.trials += 1
+	}
+}
+
+// count how a call to service went against its breaker.
+func (e *ClientEnd) recordBreaker(service *Service, err error) {
+	e.mu.Lock()
+	p := e.breakerPolicyLocked(service.Service_name)
+	if p == nil {
+		e.mu.Unlock()
+		return
+	}
+	bs := e.breakerSetLocked()
+	e.mu.Unlock()
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	now := time.Now()
+	b := bs.get(service)
+	if b.stat.State == BreakerHalfOpen && b.trials > 0 {
+		b.trials -= 1
+	}
+	// a call the caller gave up on says nothing about the endpoint.
+	if protocol.CodeOf(err) == protocol.Canceled {
+		return
+	}
+	if p.Window > 0 && now.Sub(b.windowStart) > p.Window {
+		b.resetWindow(now)
+	}
+	b.stat.Requests += 1
+	if err == nil {
+		b.stat.Consecutive_failures = 0
+		if b.stat.State == BreakerHalfOpen {
+			b.stat.State = BreakerClosed
+			b.resetWindow(now)
+		}
+	} else {
+		b.stat.Failures += 1
+		b.stat.Consecutive_failures += 1
+		switch {
+		case b.stat.State == BreakerHalfOpen:
+			b.open(now, p.Open_timeout)
+		case b.stat.State == BreakerOpen:
+		case p.Consecutive_failures > 0 && b.stat.Consecutive_failures >= p.Consecutive_failures:
+			b.open(now, p.Open_timeout)
+		case p.Error_rate > 0 && b.stat.Requests >= p.Min_requests &&
+			float64(b.stat.Failures) > p.Error_rate*float64(b.stat.Requests):
+			b.open(now, p.Open_timeout)
+		}
+	}
+	bs.ejectOutliers(service.Service_name, p, now)
+}
+
+// eject the endpoints of serviceName that do much worse than the
+// rest. the caller holds bs.mu.
+func (bs *breakerSet) ejectOutliers(serviceName string, p *BreakerPolicy, now time.Time) {
+	if p.Outlier_ratio <= 0 || now.Sub(bs.checked[serviceName]) < p.Interval {
+		return
+	}
+	bs.checked[serviceName] = now
+
+	all := 0
+	ejected := 0
+	candidates := []*breaker{}
+	total := 0.0
+	for _, b := range bs.endpoints {
+		if b.stat.Service_name != serviceName {
+			continue
+		}
+		all += 1
+		if b.stat.State != BreakerClosed {
+			ejected += 1
+			continue
+		}
+		if b.stat.Requests < p.Min_requests || b.stat.Requests == 0 {
+			continue
+		}
+		candidates = append(candidates, b)
+		total += b.successRate()
+	}
+	if len(candidates) < 2 {
+		return
+	}
+	mean := total / float64(len(candidates))
+	for _, b := range candidates {
+		if (ejected+1)*100 > all*p.Max_ejection_percent {
+			return
+		}
+		if b.successRate() < p.Outlier_ratio*mean {
+			b.open(now, p.Ejection_time*time.Duration(b.stat.Ejections+1))
+			ejected += 1
+		}
+	}
+}
+
+func (b *breaker) successRate() float64 {
+	return float64(b.stat.Requests-b.stat.Failures) / float64(b.stat.Requests)
+}
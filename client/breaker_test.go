@@ -0,0 +1,214 @@
+package client
+
+import (
+	"srpc/common/protocol"
+	"testing"
+	"time"
+)
+
+var errTestUnavailable = protocol.NewError(protocol.Unavailable, "test: unavailable")
+
+func makeBreakerEnd(t *testing.T, p *BreakerPolicy, n int) (*ClientEnd, []*Service) {
+	e, _ := MakeClientEnd()
+	e.SetBreakerPolicy("KV", p)
+	services := testServices(n)
+	for _, service := range services {
+		e.AddService(service)
+	}
+	return e, services
+}
+
+// call service as chooseService would have it, with err for result.
+func record(e *ClientEnd, service *Service, err error) {
+	e.mu.Lock()
+	e.healthyServices([]*Service{service})
+	e.acquireBreaker(service)
+	e.mu.Unlock()
+	e.recordBreaker(service, err)
+}
+
+func breakerState(e *ClientEnd, service *Service) string {
+	for _, st := range e.EndpointStats() {
+		if st.Address == service.address() {
+			return st.State
+		}
+	}
+	return BreakerClosed
+}
+
+func healthy(e *ClientEnd, services []*Service) []*Service {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.healthyServices(services)
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	p := DefaultBreakerPolicy()
+	p.Consecutive_failures = 3
+	e, services := makeBreakerEnd(t, p, 2)
+	bad := services[0]
+	record(e, bad, errTestUnavailable)
+	record(e, bad, errTestUnavailable)
+	record(e, bad, nil)
+	record(e, bad, errTestUnavailable)
+	record(e, bad, errTestUnavailable)
+	if st := breakerState(e, bad); st != BreakerClosed {
+		t.Fatalf("%v after failures broken by a success", st)
+	}
+	record(e, bad, errTestUnavailable)
+	if st := breakerState(e, bad); st != BreakerOpen {
+		t.Fatalf("%v after 3 failures in a row", st)
+	}
+	if h := healthy(e, services); len(h) != 1 || h[0] != services[1] {
+		t.Fatal("open endpoint still chosen")
+	}
+}
+
+func TestBreakerOpensOnErrorRate(t *testing.T) {
+	p := DefaultBreakerPolicy()
+	p.Consecutive_failures = 0
+	p.Min_requests = 10
+	p.Error_rate = 0.5
+	e, services := makeBreakerEnd(t, p, 1)
+	for i := 0; i < 10; i++ {
+		err := error(nil)
+		if i%3 != 2 {
+			err = errTestUnavailable
+		}
+		record(e, services[0], err)
+		if i < 9 && breakerState(e, services[0]) != BreakerClosed {
+			t.Fatalf("opened after %d calls, before Min_requests", i+1)
+		}
+	}
+	if st := breakerState(e, services[0]); st != BreakerOpen {
+		t.Fatalf("%v with 7 of 10 calls failed", st)
+	}
+}
+
+func TestBreakerHalfOpens(t *testing.T) {
+	p := DefaultBreakerPolicy()
+	p.Consecutive_failures = 1
+	p.Open_timeout = 30 * time.Millisecond
+	p.Half_open_requests = 1
+	e, services := makeBreakerEnd(t, p, 1)
+	service := services[0]
+
+	record(e, service, errTestUnavailable)
+	if len(healthy(e, services)) != 0 {
+		t.Fatal("open endpoint chosen")
+	}
+	time.Sleep(2 * p.Open_timeout)
+	if st := breakerState(e, service); st != BreakerHalfOpen {
+		t.Fatalf("%v once the open timeout is over", st)
+	}
+
+	// one trial at a time.
+	e.mu.Lock()
+	e.acquireBreaker(service)
+	e.mu.Unlock()
+	if len(healthy(e, services)) != 0 {
+		t.Fatal("second trial let through while one is in flight")
+	}
+	// a failed trial opens the breaker again.
+	e.recordBreaker(service, errTestUnavailable)
+	if st := breakerState(e, service); st != BreakerOpen {
+		t.Fatalf("%v after a failed trial", st)
+	}
+
+	time.Sleep(2 * p.Open_timeout)
+	record(e, service, nil)
+	if st := breakerState(e, service); st != BreakerClosed {
+		t.Fatalf("%v after a good trial", st)
+	}
+}
+
+func TestBreakerIgnoresCanceledCalls(t *testing.T) {
+	p := DefaultBreakerPolicy()
+	p.Consecutive_failures = 2
+	e, services := makeBreakerEnd(t, p, 1)
+	for i := 0; i < 5; i++ {
+		record(e, services[0], errHedgeCanceled)
+	}
+	if st := breakerState(e, services[0]); st != BreakerClosed {
+		t.Fatalf("%v after canceled calls only", st)
+	}
+}
+
+func TestBreakerEjectsOutliers(t *testing.T) {
+	p := DefaultBreakerPolicy()
+	p.Consecutive_failures = 0
+	p.Error_rate = 0
+	p.Min_requests = 10
+	p.Interval = 0
+	p.Outlier_ratio = 0.7
+	p.Max_ejection_percent = 50
+	e, services := makeBreakerEnd(t, p, 4)
+	for i := 0; i < 10; i++ {
+		for j, service := range services {
+			err := error(nil)
+			// the first endpoint fails half its calls.
+			if j == 0 && i%2 == 0 {
+				err = errTestUnavailable
+			}
+			record(e, service, err)
+		}
+	}
+	if st := breakerState(e, services[0]); st != BreakerOpen {
+		t.Fatalf("outlier %v", st)
+	}
+	for _, service := range services[1:] {
+		if st := breakerState(e, service); st != BreakerClosed {
+			t.Fatalf("healthy endpoint %v", st)
+		}
+	}
+}
+
+func TestBreakerPolicyZeroesMeanDefaults(t *testing.T) {
+	p := DefaultBreakerPolicy()
+	p.Consecutive_failures = 1
+	p.Open_timeout = 20 * time.Millisecond
+	p.Half_open_requests = 0
+	e, services := makeBreakerEnd(t, p, 1)
+	record(e, services[0], errTestUnavailable)
+	time.Sleep(2 * p.Open_timeout)
+	if len(healthy(e, services)) != 1 {
+		t.Fatal("half-open endpoint let no trial call through")
+	}
+	record(e, services[0], nil)
+	if st := breakerState(e, services[0]); st != BreakerClosed {
+		t.Fatalf("%v after a good trial", st)
+	}
+	if p.Half_open_requests != 0 {
+		t.Fatal("the caller's policy was changed")
+	}
+}
+
+func TestBreakerEjectsOutliersWithDefaultPercent(t *testing.T) {
+	p := DefaultBreakerPolicy()
+	p.Consecutive_failures = 0
+	p.Error_rate = 0
+	p.Min_requests = 10
+	p.Interval = 0
+	p.Max_ejection_percent = 0
+	e, services := makeBreakerEnd(t, p, 4)
+	for i := 0; i < 10; i++ {
+		for j, service := range services {
+			err := error(nil)
+			// three of the endpoints fail most of their calls, but
+			// only half the endpoints may be ejected.
+			if j < 3 && i%4 != 0 {
+				err = errTestUnavailable
+			}
+			record(e, service, err)
+		}
+	}
+	open := 0
+	for _, service := range services {
+		if breakerState(e, service) == BreakerOpen {
+			open += 1
+		}
+	}
+	if open == 0 || open > 2 {
+		t.Fatalf("%d of 4 endpoints ejected, want 1 or 2", open)
+	}
+}
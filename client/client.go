@@ -1,31 +1,76 @@
 package client
 
 import (
-	"log"
 	"net"
-	"fmt"
 	"sync"
 	"bytes"
 	"strings"
+	"strconv"
 	"reflect"
+	"time"
 	"srpc/common/protocol"
 	"srpc/common/sgob"
+	"srpc/common/connect"
+	"srpc/common/stats"
+	"srpc/common/auth"
+	"srpc/common/tlsconf"
+	"srpc/common/trace"
+	"srpc/common/logging"
 )
 
 type ClientEnd struct {
-	mu      sync.Mutex
-	endname interface{}
-	ch      chan protocol.ReqMsg
-	done    chan struct{}
-	network *Network
-	config  *Config
+	mu        sync.Mutex
+	endname   interface{}
+	done      chan struct{} // closed by Close
+	transport protocol.Transport
+	network   *Network
+	config    *Config
+	balancers map[string]Balancer // service name -> balancer in use
+	budget    *retryBudget
+	breakers  *breakerSet
+	latency   map[string]*latencies // svcMeth -> recent latencies
+	stats     *stats.Recorder
+	tracer    *trace.Tracer
+	logger    logging.Logger // nil for logging.Default
+	pulled    map[string]time.Time // svcMeth -> when it was last looked up in the registry
 }
 
+// what a client has called, per method, and how its endpoints are.
+type Stats struct {
+	stats.Snapshot
+	Endpoints []EndpointStat
+}
+
+var ErrClosed error = protocol.NewError(protocol.Canceled, "srpc: client end is closed")
+var ErrCallFailed error = protocol.NewError(protocol.Unknown, "srpc: call failed")
+
+// how long an attempt waits for a reply that may never come, e.g.
+// when the request or the reply was lost on the way.
+const defaultCallTimeout = 10 * time.Second
+
+// how long endpoints looked up in the registry are used before they
+// are looked up again, to find servers that joined since.
+const defaultRefreshInterval = 30 * time.Second
+
 type Network struct {
 	Registry_enabled bool
 	Registry_ip      string
 	Registry_port    string
+	Registry_addrs   []string                     // every registry in the cluster, as host:port
+	Registry_key     *connect.Key                 // signs lookups, for registries with closed reads; nil signs nothing
 	Services         []*Service
+	Selectors        map[string]*connect.Selector // "Service" or "Service.Method" -> selector
+	Balancers        map[string]string            // service name -> balancer name
+	Retries          map[string]*RetryPolicy      // "Service" or "Service.Method" -> retry policy
+	Idempotent       map[string]bool              // "Service" or "Service.Method" -> safe to repeat
+	Retry_budget     *RetryBudget
+	Breakers         map[string]*BreakerPolicy    // service name -> circuit breaker policy
+	Hedging          map[string]*HedgePolicy      // "Service" or "Service.Method" -> hedging policy
+	Tls              *tlsconf.Config              // nil for plain TCP
+	Credentials      auth.Credentials             // what to prove who we are with; nil for nothing
+	Priority         int                          // how much our calls matter to a server shedding load; higher goes first
+	Call_timeout     time.Duration                // how long an attempt waits for its reply; 0 for defaultCallTimeout, <0 for ever
+	Refresh_interval time.Duration                // how often endpoints are looked up again; 0 for defaultRefreshInterval
 }
 
 type Service struct {
@@ -34,103 +79,323 @@ type Service struct {
 	Server_ip       string
 	Server_port     string
 	Service_enabled bool
+	Service_key     string
+	Metadata        connect.Metadata
 }
 
 func MakeClientEnd() (*ClientEnd, error) {
-	e := &ClientEnd{}
+	e := &ClientEnd{done: make(chan struct{}), stats: stats.MakeRecorder()}
 	return e, nil
 }
 
 func MakeClientEndFromConfig(fName string) (*ClientEnd, error) {
 	var err error
-	e := &ClientEnd{}
+	e := &ClientEnd{done: make(chan struct{}), stats: stats.MakeRecorder()}
 	e.config, err = NewConfig(fName, &JSONConfigFormat{})
 	if err != nil {
 		return nil, err
 	}
 	e.network = e.config.Format.TransferToNetWork()
+	if err := e.useTLS(); err != nil {
+		return nil, err
+	}
 	return e, nil
 }
 
 func MakeClientEndFromConfigText(text string) (*ClientEnd, error) {
 	var err error
-	e := &ClientEnd{}
+	e := &ClientEnd{done: make(chan struct{}), stats: stats.MakeRecorder()}
 	e.config, err = NewConfigFromText(text, &JSONConfigFormat{})
 	if err != nil {
 		return nil, err
 	}
 	e.network = e.config.Format.TransferToNetWork()
+	if err := e.useTLS(); err != nil {
+		return nil, err
+	}
 	return e, nil
 } 
 
 func (e *ClientEnd) RefrshConfig(fName string) error {
-	var err error
-	e.config, err = NewConfig(fName, &JSONConfigFormat{})
+	config, err := NewConfig(fName, &JSONConfigFormat{})
 	if err != nil {
 		return err
 	}
-	e.network = e.config.Format.TransferToNetWork()
-	return nil
+	return e.useConfig(config)
 }
 
 func (e *ClientEnd) RefreshConfigFromText(text string) error {
-	var err error
-	e.config, err = NewConfigFromText(text, &JSONConfigFormat{})
+	config, err := NewConfigFromText(text, &JSONConfigFormat{})
 	if err != nil {
 		return err
 	}
-	e.network = e.config.Format.TransferToNetWork()
-	return nil
+	return e.useConfig(config)
+}
+
+// go on under config, read anew while calls may be in flight. what
+// was built on the old one, balancers, breakers, the retry budget
+// and when endpoints were looked up, starts over.
+func (e *ClientEnd) useConfig(config *Config) error {
+	e.mu.Lock()
+	e.config = config
+	e.network = config.Network
+	e.balancers = nil
+	e.breakers = nil
+	e.budget = nil
+	e.pulled = nil
+	e.mu.Unlock()
+	return e.useTLS()
 }
 
 func (e *ClientEnd) SetRegistry(ip, port string) error {
-	e.network.Registry_ip = ip
-	e.network.Registry_port = port
+	return e.SetRegistries([]string{net.JoinHostPort(ip, port)})
+}
+
+// only use endpoints of a service, or of one "Service.Method",
+// whose metadata matches selector, e.g. "version>=2,zone=east".
+// an empty selector removes the restriction.
+func (e *ClientEnd) SetSelector(name string, selector string) error {
+	sel, err := connect.ParseSelector(selector)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.network == nil {
+		e.network = &Network{}
+	}
+	if e.network.Selectors == nil {
+		e.network.Selectors = map[string]*connect.Selector{}
+	}
+	if selector == "" {
+		delete(e.network.Selectors, name)
+	} else {
+		e.network.Selectors[name] = sel
+	}
+	return nil
+}
+
+// the selector for svcMeth; the caller holds e.mu.
+func (e *ClientEnd) selector(serviceName, methodName string) *connect.Selector {
+	if sel, ok := e.network.Selectors[serviceName+"."+methodName]; ok {
+		return sel
+	}
+	return e.network.Selectors[serviceName]
+}
+
+// make an endpoint known to the end, in place of any it knew for
+// the same method at the same address.
+func (e *ClientEnd) AddService(service *Service) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.network == nil {
+		e.network = &Network{}
+	}
+	for i, s := range e.network.Services {
+		if s.Service_name == service.Service_name && s.Method_name == service.Method_name &&
+			s.address() == service.address() {
+			e.network.Services[i] = service
+			return
+		}
+	}
+	e.network.Services = append(e.network.Services, service)
+}
+
+// look services up in a registry cluster; any member will do.
+func (e *ClientEnd) SetRegistries(addrs []string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.network == nil {
+		e.network = &Network{}
+	}
+	if len(addrs) > 0 {
+		e.network.Registry_ip, e.network.Registry_port, _ = net.SplitHostPort(addrs[0])
+	}
+	e.network.Registry_addrs = addrs
 	e.network.Registry_enabled = true
 	return nil
 }
 
 func (e *ClientEnd) Call(svcMeth string, args interface{}, reply interface{}) bool {
-	service := e.chooseService(svcMeth)
-	if service == nil {
-		e.pullService(svcMeth)
-		return false
-	}
+	return e.call(svcMeth, "", trace.SpanContext{}, args, reply)
+}
 
-	e.send([]byte{}, service.Server_ip, service.Server_port)
+// like Call, but calls with the same key go to the same endpoint
+// as long as it is around, when the service uses a balancer that
+// routes by key such as consistent_hash.
+func (e *ClientEnd) CallWithKey(svcMeth string, key string, args interface{}, reply interface{}) bool {
+	return e.call(svcMeth, key, trace.SpanContext{}, args, reply)
+}
 
-	req := protocol.ReqMsg{}
-	req.Endname = e.endname
-	req.SvcMeth = svcMeth
-	req.ArgsType = reflect.TypeOf(args)
-	req.ReplyCh = make(chan protocol.ReplyMsg)
+// like Call, but the call's span is a child of parent, e.g. the
+// span of the request being handled when the call was made.
+func (e *ClientEnd) CallWithTrace(parent trace.SpanContext, svcMeth string, args interface{}, reply interface{}) bool {
+	return e.call(svcMeth, "", parent, args, reply)
+}
+
+// call svcMeth, hedging and retrying on other endpoints where
+// the policies and the retry budget allow.
+func (e *ClientEnd) call(svcMeth string, key string, parent trace.SpanContext, args interface{}, reply interface{}) bool {
+	span := e.getTracer().Start(svcMeth, trace.KindClient, parent)
+	err := e.retry(svcMeth, key, span, args, reply)
+	span.End(protocol.CodeOf(err).String())
+	return err == nil
+}
+
+// the attempts of call; the error is the one that ended them.
+func (e *ClientEnd) retry(svcMeth string, key string, span *trace.Span, args interface{}, reply interface{}) error {
+	policy := e.retryPolicy(svcMeth)
+	hedge := e.hedgePolicy(svcMeth)
+	budget := e.retryBudget()
+	budget.deposit()
+
+	e.refresh(svcMeth)
+	tried := map[string]bool{}
+	for attempt := 1; ; attempt++ {
+		service := e.chooseService(svcMeth, key, tried)
+		if service == nil {
+			e.pullService(svcMeth)
+			service = e.chooseService(svcMeth, key, tried)
+		}
+		if service == nil {
+			return protocol.NewError(protocol.Unavailable, "srpc: no endpoint for "+svcMeth)
+		}
+
+		var err error
+		if hedge != nil {
+			service, err = e.hedgeService(service, svcMeth, key, tried, hedge, budget, span, args, reply)
+		} else {
+			err = e.attemptService(service, svcMeth, span, args, reply, nil)
+		}
+		if err == nil {
+			return nil
+		}
+		if policy == nil || attempt >= policy.Max_attempts || !policy.retryable(protocol.CodeOf(err)) {
+			return err
+		}
+		if !budget.withdraw() {
+			e.log().Log(logging.Warn, "ClientEnd.Call(): retry budget exhausted",
+				append(logging.SvcMeth(svcMeth), logging.Err(err))...)
+			return err
+		}
+		tried[service.address()] = true
 
-	sendData := e.encode(req)
-	e.send(sendData, service.Server_ip, service.Server_port)
+		select {
+		case <-time.After(policy.backoff(attempt)):
+		case <-e.done:
+			return ErrClosed
+		}
+	}
+}
+
+// make one attempt at svcMeth on service, which chooseService
+// picked, and tell the balancer and the breaker how it went.
+func (e *ClientEnd) attemptService(service *Service, svcMeth string, span *trace.Span, args interface{},
+	reply interface{}, cancel <-chan struct{}) error {
+	start := time.Now()
+	err := e.callService(service, svcMeth, span, args, reply, cancel)
+	if err == nil {
+		e.latencies(svcMeth).add(time.Since(start))
+	}
+	e.release(service, err)
+	return err
+}
+
+// hand back an endpoint that chooseService picked.
+func (e *ClientEnd) release(service *Service, err error) {
+	e.balancer(service.Service_name).Done(service, err)
+	e.recordBreaker(service, err)
+}
 
+// make one attempt at svcMeth on one endpoint, giving up
+// when cancel is closed.
+func (e *ClientEnd) callService(service *Service, svcMeth string, span *trace.Span, args interface{},
+	reply interface{}, cancel <-chan struct{}) error {
 	qb := new(bytes.Buffer)
 	qe := sgob.NewEncoder(qb)
 	if err := qe.Encode(args); err != nil {
 		panic(err)
 	}
+	span.AddEvent("encode", "bytes", strconv.Itoa(qb.Len()))
+
+	req := protocol.ReqMsg{}
+	req.Endname = e.endname
+	req.SvcMeth = svcMeth
+	req.ArgsType = reflect.TypeOf(args)
 	req.Args = qb.Bytes()
+	req.ReplyCh = make(chan protocol.ReplyMsg, 1)
+	meta := map[string]string{}
+	if sc := span.Context(); sc.IsValid() {
+		meta[trace.Header] = sc.Traceparent()
+	}
+	if p := e.priority(); p != 0 {
+		meta[protocol.PriorityKey] = strconv.Itoa(p)
+	}
+	if creds := e.credentials(); creds != nil {
+		creds.Apply(svcMeth, req.Args, meta)
+	}
+	if len(meta) > 0 {
+		req.Meta = meta
+	}
+	start := time.Now()
+	span.AddEvent("send", "peer", service.address())
+	transport := e.getTransport()
+	transport.Send(service.address(), req)
 
+	var rep protocol.ReplyMsg
+	var err error
+	defer func() {
+		e.stats.Record(svcMeth, protocol.CodeOf(err), len(rep.Reply), len(req.Args), time.Since(start))
+	}()
+	var timeout <-chan time.Time
+	d := e.callTimeout()
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	// a reply that is no longer waited for need not be kept for.
+	forget := func() {
+		if f, ok := transport.(protocol.Forgetter); ok {
+			f.Forget(service.address(), req)
+		}
+	}
 	select {
-	case e.ch <- req:
+	case rep = <-req.ReplyCh:
 	case <-e.done:
-		return false
+		forget()
+		err = ErrClosed
+		return err
+	case <-cancel:
+		forget()
+		err = errHedgeCanceled
+		return err
+	case <-timeout:
+		forget()
+		err = protocol.Errorf(protocol.DeadlineExceeded, "srpc: %v on %v: no reply in %v", svcMeth,
+			service.address(), d)
+		span.AddEvent("timeout", "peer", service.address())
+		return err
 	}
+	err = decodeReply(service, svcMeth, rep, reply)
+	span.AddEvent("decode", "peer", service.address(), "bytes", strconv.Itoa(len(rep.Reply)),
+		"code", protocol.CodeOf(err).String())
+	return err
+}
 
-	rep := <-req.ReplyCh
+// fill in reply from rep, or say why the call failed.
+func decodeReply(service *Service, svcMeth string, rep protocol.ReplyMsg, reply interface{}) error {
 	if rep.Ok {
 		rb := bytes.NewBuffer(rep.Reply)
 		rd := sgob.NewDecoder(rb)
 		if err := rd.Decode(reply); err != nil {
-			log.Fatalf("ClientEnd.Call(): decode reply: %v\n", err)
+			return protocol.Errorf(protocol.Internal, "srpc: %v on %v: decode reply: %v", svcMeth, service.address(), err)
 		}
-		return true
+		return nil
+	} else if rep.Code != protocol.OK {
+		return protocol.Errorf(rep.Code, "srpc: %v on %v: %v", svcMeth, service.address(), rep.Code)
 	} else {
-		return false
+		return ErrCallFailed
 	}
 }
 
@@ -142,62 +407,283 @@ func (e *ClientEnd) CallWithCompletion() {
 	
 }
 
+func (e *ClientEnd) Stats() *Stats {
+	return &Stats{e.stats.Snapshot(), e.EndpointStats()}
+}
+
+// start the counters of Stats from zero. breaker state is kept.
+func (e *ClientEnd) ResetStats() {
+	e.stats.Reset()
+}
+
+// fail calls in flight and any made later.
 func (e *ClientEnd) Close() {
-	e.done <- struct{}{}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	select {
+	case <-e.done:
+	default:
+		close(e.done)
+	}
 }
 
-func (e *ClientEnd) encode(req protocol.ReqMsg) []byte {
-	return []byte{}
+// carry calls over t instead of DefaultTransport.
+func (e *ClientEnd) SetTransport(t protocol.Transport) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.transport = t
 }
 
-func (e *ClientEnd) send(data []byte, ip, port string) ([]byte, error) {
-	address := fmt.Sprintf("%s:%s", ip, port)
-	conn, err := net.Dial("tcp", address)
-	if err != nil {
-		log.Fatalf("dial failed, err: %v", err)
-		return nil, err
+// carry calls over TLS when the config asks for it.
+func (e *ClientEnd) useTLS() error {
+	e.mu.Lock()
+	var conf *tlsconf.Config
+	if e.network != nil {
+		conf = e.network.Tls
 	}
-	defer conn.Close()
-	_, err = conn.Write(data)
-	if err != nil {
-		return nil, err
+	e.mu.Unlock()
+	if conf == nil {
+		return nil
 	}
-	buf := [512]byte{}
-	n, err := conn.Read(buf[:])
+	l, err := tlsconf.MakeLoader(conf)
 	if err != nil {
-		log.Fatalf("recv failed, err: %v", err)
-		return nil, err
+		return err
 	}
-	return buf[:n], nil
+	e.SetTransport(MakeTLSTransport(l))
+	return nil
+}
+
+func (e *ClientEnd) getTransport() protocol.Transport {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.transport == nil {
+		return DefaultTransport
+	}
+	return e.transport
+}
+
+// make spans of calls with t; nil, the default, makes none.
+func (e *ClientEnd) SetTracer(t *trace.Tracer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.tracer = t
+}
+
+func (e *ClientEnd) getTracer() *trace.Tracer {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.tracer
+}
+
+// say who we are to servers with c; nil sends no credentials.
+func (e *ClientEnd) SetCredentials(c auth.Credentials) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.network == nil {
+		e.network = &Network{}
+	}
+	e.network.Credentials = c
+}
+
+func (e *ClientEnd) credentials() auth.Credentials {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.network == nil {
+		return nil
+	}
+	return e.network.Credentials
 }
 
-func (e *ClientEnd) chooseService(svcMeth string) *Service {
+// ask servers that shed load to favour our calls over those of lower
+// priority, and to shed them before those of higher. the default is 0.
+func (e *ClientEnd) SetPriority(p int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.network == nil {
+		e.network = &Network{}
+	}
+	e.network.Priority = p
+}
+
+// give up on an attempt that has had no reply for d, failing it
+// with protocol.DeadlineExceeded; d < 0 waits for ever.
+func (e *ClientEnd) SetCallTimeout(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.network == nil {
+		e.network = &Network{}
+	}
+	e.network.Call_timeout = d
+}
+
+func (e *ClientEnd) callTimeout() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.network == nil || e.network.Call_timeout == 0 {
+		return defaultCallTimeout
+	}
+	return e.network.Call_timeout
+}
+
+func (e *ClientEnd) priority() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.network == nil {
+		return 0
+	}
+	return e.network.Priority
+}
+
+// where the end logs; nil for logging.Default.
+func (e *ClientEnd) SetLogger(l logging.Logger) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.logger = l
+}
+
+func (e *ClientEnd) log() logging.Logger {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return logging.Or(e.logger)
+}
+
+// the name the end gives itself in every request it sends.
+func (e *ClientEnd) SetEndname(endname interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.endname = endname
+}
+
+// pick an endpoint for svcMeth, avoiding the ones in tried
+// unless nothing else is left.
+func (e *ClientEnd) chooseService(svcMeth string, key string, tried map[string]bool) *Service {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.network == nil {
+		return nil
+	}
 	params := strings.Split(svcMeth, ".")
 	if len(params) != 2 {
 		return nil
 	}
 	serviceName := params[0]
 	methodName := params[1]
+	sel := e.selector(serviceName, methodName)
 	services := []*Service{}
 	for _, service := range e.network.Services {
-		if service.Service_name == serviceName && service.Method_name == methodName {
+		if service.Service_name == serviceName && service.Method_name == methodName && service.Service_enabled &&
+			sel.Matches(&service.Metadata) {
 			services = append(services, service)
 		}
 	}
 	if len(services) == 0 {
 		return nil
 	}
-	return e.scheduleService(services)
+	all := services
+	services = e.healthyServices(services)
+	if len(services) == 0 {
+		return nil
+	}
+	if len(tried) > 0 {
+		untried := []*Service{}
+		for _, service := range services {
+			if !tried[service.address()] {
+				untried = append(untried, service)
+			}
+		}
+		if len(untried) > 0 {
+			services = untried
+		}
+	}
+	service := e.scheduleService(svcMeth, key, all, services)
+	e.acquireBreaker(service)
+	return service
 }
 
-func (e *ClientEnd) scheduleService(servics []*Service) *Service {
-	return servics[0]
+// pick one of the endpoints that offer svcMeth, using the
+// balancer configured for its service.
+func (e *ClientEnd) scheduleService(svcMeth string, key string, all []*Service, servics []*Service) *Service {
+	b := e.balancerLocked(servics[0].Service_name)
+	if kb, ok := b.(KeyedBalancer); ok && key != "" {
+		return kb.PickKey(svcMeth, key, all, servics)
+	}
+	return b.Pick(svcMeth, servics)
 }
 
-func (e *ClientEnd) pullServices() {
-
+// look svcMeth up again in the background once the endpoints we
+// know of are older than the refresh interval. calls meanwhile go to
+// those we know.
+func (e *ClientEnd) refresh(svcMeth string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.network == nil || !e.network.Registry_enabled {
+		return
+	}
+	interval := e.network.Refresh_interval
+	if interval <= 0 {
+		interval = defaultRefreshInterval
+	}
+	last, ok := e.pulled[svcMeth]
+	if !ok || time.Since(last) < interval {
+		return
+	}
+	// so that calls until it is back do not look it up too.
+	e.pulled[svcMeth] = time.Now()
+	go e.pullService(svcMeth)
 }
 
+// fetch every endpoint of svcMeth from the registry, replacing
+// what we knew about it.
 func (e *ClientEnd) pullService(svcMeth string) {
+	e.mu.Lock()
+	if e.network == nil || !e.network.Registry_enabled {
+		e.mu.Unlock()
+		return
+	}
+	if e.pulled == nil {
+		e.pulled = map[string]time.Time{}
+	}
+	e.pulled[svcMeth] = time.Now()
+	addrs := e.network.Registry_addrs
+	key := e.network.Registry_key
+	dot := strings.LastIndex(svcMeth, ".")
+	if dot < 0 {
+		e.mu.Unlock()
+		return
+	}
+	serviceName := svcMeth[:dot]
+	methodName := svcMeth[dot+1:]
+	selector := ""
+	if sel := e.selector(serviceName, methodName); sel != nil {
+		selector = sel.String()
+	}
+	e.mu.Unlock()
 
+	endpoints, err := connect.Lookup(addrs, serviceName, methodName, selector, key)
+	if err != nil {
+		e.log().Log(logging.Warn, "ClientEnd.pullService(): lookup",
+			append(logging.SvcMeth(svcMeth), logging.Err(err))...)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	services := []*Service{}
+	for _, service := range e.network.Services {
+		if service.Service_name != serviceName || service.Method_name != methodName {
+			services = append(services, service)
+		}
+	}
+	for _, ep := range endpoints {
+		services = append(services, &Service{
+			Service_name:    ep.Service_name,
+			Method_name:     ep.Method_name,
+			Server_ip:       ep.Server_ip,
+			Server_port:     ep.Server_port,
+			Service_enabled: ep.Enabled,
+			Service_key:     ep.Service_key,
+			Metadata:        ep.Metadata,
+		})
+	}
+	e.network.Services = services
 }
\ No newline at end of file
@@ -2,14 +2,66 @@ package client
 
 import (
 	"os"
+	"net"
 	"strings"
 	"encoding/json"
+	"time"
+	"srpc/common/connect"
+	"srpc/common/protocol"
+	"srpc/common/auth"
+	"srpc/common/logging"
+	"srpc/common/tlsconf"
 )
 
 type JSONConfigFormat struct {
-	Configuation_name    string `json:"configuation_name"`
-	Configuration_key    string `json:"configuation_key"`
-	Configuation_version string `json:"configuation_version"`
+	Configuation_name    string            `json:"configuation_name"`
+	Configuration_key    string            `json:"configuation_key"`
+	Configuation_version string            `json:"configuation_version"`
+	Registry_ip          string            `json:"registry_ip"`
+	Registry_port        string            `json:"registry_port"`
+	Registry_addrs       []string          `json:"registry_addrs"`
+	Selectors            map[string]string `json:"selectors"` // "Service" or "Service.Method" -> selector
+	Balancers            map[string]string `json:"balancers"` // service name -> balancer name
+	Retries              map[string]struct {
+		Max_attempts       int      `json:"max_attempts"`
+		Initial_backoff_ms int      `json:"initial_backoff_ms"`
+		Max_backoff_ms     int      `json:"max_backoff_ms"`
+		Multiplier         float64  `json:"multiplier"`
+		Jitter             float64  `json:"jitter"`
+		Retryable_codes    []string `json:"retryable_codes"` // e.g. "unavailable"
+	} `json:"retries"` // "Service" or "Service.Method" -> retry policy
+	Idempotent           []string `json:"idempotent"` // "Service" or "Service.Method"
+	Breakers             map[string]struct {
+		Consecutive_failures int     `json:"consecutive_failures"`
+		Error_rate           float64 `json:"error_rate"`
+		Min_requests         int     `json:"min_requests"`
+		Window_ms            int     `json:"window_ms"`
+		Open_timeout_ms      int     `json:"open_timeout_ms"`
+		Half_open_requests   int     `json:"half_open_requests"`
+		Interval_ms          int     `json:"interval_ms"`
+		Outlier_ratio        float64 `json:"outlier_ratio"`
+		Ejection_time_ms     int     `json:"ejection_time_ms"`
+		Max_ejection_percent int     `json:"max_ejection_percent"`
+	} `json:"breakers"` // service name -> circuit breaker policy
+	Hedging              map[string]struct {
+		Delay_ms     int     `json:"delay_ms"`
+		Percentile   float64 `json:"percentile"`
+		Max_attempts int     `json:"max_attempts"`
+	} `json:"hedging"` // "Service" or "Service.Method" -> hedging policy
+	Retry_budget         *struct {
+		Ratio          float64 `json:"ratio"`
+		Min_per_second float64 `json:"min_per_second"`
+		Max_tokens     float64 `json:"max_tokens"`
+	} `json:"retry_budget"`
+	Tls                  *tlsconf.Config `json:"tls"` // cert_file, key_file, ca_file, server_name, min_version
+	Credentials          *struct {
+		Token  string `json:"token"`
+		Key_id string `json:"key_id"` // with secret, to sign requests
+		Secret string `json:"secret"`
+	} `json:"credentials"`
+	Priority             int `json:"priority"` // for servers that shed load; higher goes first
+	Call_timeout_ms      int `json:"call_timeout_ms"` // how long an attempt waits for its reply; <0 for ever
+	Refresh_interval_ms  int `json:"refresh_interval_ms"` // how often endpoints are looked up again in the registry
 	Server 				 []struct {
 		Server_name string `json:"server_name"`
 		Server_key  string `json:"server_key"`
@@ -21,6 +73,10 @@ type JSONConfigFormat struct {
 			Method_name  string `json:"method_name"`
 			Describtion  string `json:"describtion"`
 			Enabled      bool   `json:"enabled"`
+			Version      string            `json:"version"`
+			Weight       int               `json:"weight"`
+			Zone         string            `json:"zone"`
+			Tags         map[string]string `json:"tags"`
 		} `json:"services"`
 	} `json:"server"`
 }
@@ -35,12 +91,148 @@ func (c *JSONConfigFormat) TransferToNetWork() *Network {
 				Server_ip: server.Server_ip,
 				Server_port: server.Server_port,
 				Service_enabled: true,
+				Service_key: service.Service_key,
+				Metadata: connect.Metadata{
+					Version: service.Version,
+					Weight: service.Weight,
+					Zone: service.Zone,
+					Tags: service.Tags,
+					Describtion: service.Describtion,
+				},
 			}
 			services = append(services, s)
 		}		
 	}
 	rn := &Network {
+		Registry_ip: c.Registry_ip,
+		Registry_port: c.Registry_port,
+		Registry_addrs: c.Registry_addrs,
 		Services: services,
+		Balancers: c.Balancers,
+		Tls: c.Tls,
+		Priority: c.Priority,
+		Call_timeout: time.Duration(c.Call_timeout_ms) * time.Millisecond,
+		Refresh_interval: time.Duration(c.Refresh_interval_ms) * time.Millisecond,
+	}
+	if c.Configuration_key != "" {
+		rn.Registry_key = &connect.Key{Id: c.Configuation_name, Secret: c.Configuration_key}
+	}
+	if c.Credentials != nil {
+		if c.Credentials.Key_id != "" {
+			rn.Credentials = &auth.HMACCredentials{Key_id: c.Credentials.Key_id, Secret: []byte(c.Credentials.Secret)}
+		} else if c.Credentials.Token != "" {
+			rn.Credentials = &auth.TokenCredentials{Token: c.Credentials.Token}
+		}
+	}
+	if c.Registry_ip != "" {
+		rn.Registry_addrs = append([]string{net.JoinHostPort(c.Registry_ip, c.Registry_port)}, rn.Registry_addrs...)
+	}
+	rn.Registry_enabled = len(rn.Registry_addrs) > 0
+	rn.Selectors = map[string]*connect.Selector{}
+	for name, text := range c.Selectors {
+		sel, err := connect.ParseSelector(text)
+		if err != nil {
+			logging.Default.Log(logging.Warn, "client config: bad selector", logging.F("name", name), logging.Err(err))
+			continue
+		}
+		rn.Selectors[name] = sel
+	}
+	rn.Retries = map[string]*RetryPolicy{}
+	for name, conf := range c.Retries {
+		p := DefaultRetryPolicy()
+		if conf.Max_attempts > 0 {
+			p.Max_attempts = conf.Max_attempts
+		}
+		if conf.Initial_backoff_ms > 0 {
+			p.Initial_backoff = time.Duration(conf.Initial_backoff_ms) * time.Millisecond
+		}
+		if conf.Max_backoff_ms > 0 {
+			p.Max_backoff = time.Duration(conf.Max_backoff_ms) * time.Millisecond
+		}
+		if conf.Multiplier > 0 {
+			p.Multiplier = conf.Multiplier
+		}
+		if conf.Jitter > 0 {
+			p.Jitter = conf.Jitter
+		}
+		if len(conf.Retryable_codes) > 0 {
+			p.Retryable_codes = nil
+			for _, text := range conf.Retryable_codes {
+				code, err := protocol.ParseCode(text)
+				if err != nil {
+					logging.Default.Log(logging.Warn, "client config: bad retry code",
+						logging.F("name", name), logging.Err(err))
+					continue
+				}
+				p.Retryable_codes = append(p.Retryable_codes, code)
+			}
+		}
+		rn.Retries[name] = p
+	}
+	rn.Idempotent = map[string]bool{}
+	for _, name := range c.Idempotent {
+		rn.Idempotent[name] = true
+	}
+	rn.Hedging = map[string]*HedgePolicy{}
+	for name, conf := range c.Hedging {
+		p := DefaultHedgePolicy()
+		if conf.Delay_ms > 0 {
+			p.Delay = time.Duration(conf.Delay_ms) * time.Millisecond
+		}
+		if conf.Percentile > 0 {
+			p.Percentile = conf.Percentile
+		}
+		if conf.Max_attempts > 0 {
+			p.Max_attempts = conf.Max_attempts
+		}
+		rn.Hedging[name] = p
+	}
+	rn.Breakers = map[string]*BreakerPolicy{}
+	for name, conf := range c.Breakers {
+		p := DefaultBreakerPolicy()
+		if conf.Consecutive_failures > 0 {
+			p.Consecutive_failures = conf.Consecutive_failures
+		}
+		if conf.Error_rate > 0 {
+			p.Error_rate = conf.Error_rate
+		}
+		if conf.Min_requests > 0 {
+			p.Min_requests = conf.Min_requests
+		}
+		if conf.Window_ms > 0 {
+			p.Window = time.Duration(conf.Window_ms) * time.Millisecond
+		}
+		if conf.Open_timeout_ms > 0 {
+			p.Open_timeout = time.Duration(conf.Open_timeout_ms) * time.Millisecond
+		}
+		if conf.Half_open_requests > 0 {
+			p.Half_open_requests = conf.Half_open_requests
+		}
+		if conf.Interval_ms > 0 {
+			p.Interval = time.Duration(conf.Interval_ms) * time.Millisecond
+		}
+		if conf.Outlier_ratio > 0 {
+			p.Outlier_ratio = conf.Outlier_ratio
+		}
+		if conf.Ejection_time_ms > 0 {
+			p.Ejection_time = time.Duration(conf.Ejection_time_ms) * time.Millisecond
+		}
+		if conf.Max_ejection_percent > 0 {
+			p.Max_ejection_percent = conf.Max_ejection_percent
+		}
+		rn.Breakers[name] = p
+	}
+	if c.Retry_budget != nil {
+		rn.Retry_budget = DefaultRetryBudget()
+		if c.Retry_budget.Ratio > 0 {
+			rn.Retry_budget.Ratio = c.Retry_budget.Ratio
+		}
+		if c.Retry_budget.Min_per_second > 0 {
+			rn.Retry_budget.Min_per_second = c.Retry_budget.Min_per_second
+		}
+		if c.Retry_budget.Max_tokens > 0 {
+			rn.Retry_budget.Max_tokens = c.Retry_budget.Max_tokens
+		}
 	}
 	return rn
 }
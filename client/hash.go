@@ -0,0 +1,143 @@
+package client
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// points each endpoint gets on the ring per unit of weight. more
+// points spread keys more evenly at the cost of a bigger ring.
+const virtualNodes = 100
+
+// about the most points a ring has; past it, every endpoint gets
+// fewer points per unit of weight, but at least one.
+const maxRingPoints = 100000
+
+// consistent hashing on a ring of virtual nodes. a key goes to the
+// first point at or after its hash, so when an endpoint joins or
+// leaves only the keys next to its points move. calls without a key
+// are spread round robin.
+type hashBalancer struct {
+	mu    sync.Mutex
+	rings map[string]*hashRing // svcMeth -> ring over its current endpoints
+	rr    roundRobinBalancer
+}
+
+type hashRing struct {
+	members string   // sorted endpoint addresses the ring was built from
+	hashes  []uint64 // sorted points
+	owners  []string // address owning each point
+}
+
+func newHashBalancer() *hashBalancer {
+	b := &hashBalancer{rings: map[string]*hashRing{}}
+	b.rr.next = map[string]int{}
+	return b
+}
+
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	// fnv alone clusters similar strings such as "addr#1", "addr#2";
+	// a final mix spreads them around the ring.
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+func makeHashRing(members string, services []*Service) *hashRing {
+	r := &hashRing{members: members}
+	type point struct {
+		hash  uint64
+		owner string
+	}
+	total := 0
+	for _, service := range services {
+		total += service.weight()
+	}
+	points := []point{}
+	for _, service := range services {
+		addr := service.address()
+		n := virtualNodes * service.weight()
+		if total*virtualNodes > maxRingPoints {
+			n = maxRingPoints * service.weight() / total
+			if n < 1 {
+				n = 1
+			}
+		}
+		for i := 0; i < n; i++ {
+			points = append(points, point{hashKey(addr + "#" + strconv.Itoa(i)), addr})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].owner < points[j].owner
+	})
+	for _, p := range points {
+		r.hashes = append(r.hashes, p.hash)
+		r.owners = append(r.owners, p.owner)
+	}
+	return r
+}
+
+// the first owner at or after key's hash for which ok holds, "" if
+// there is none.
+func (r *hashRing) owner(key string, ok func(addr string) bool) string {
+	h := hashKey(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	for n := 0; n < len(r.hashes); n++ {
+		if owner := r.owners[(i+n)%len(r.hashes)]; ok(owner) {
+			return owner
+		}
+	}
+	return ""
+}
+
+// the ring for the endpoints of svcMeth, rebuilt only when they change.
+// the caller holds b.mu.
+func (b *hashBalancer) ring(svcMeth string, services []*Service) *hashRing {
+	addrs := make([]string, len(services))
+	for i, service := range services {
+		addrs[i] = service.address() + "*" + strconv.Itoa(service.weight())
+	}
+	sort.Strings(addrs)
+	members := strings.Join(addrs, ",")
+	r, ok := b.rings[svcMeth]
+	if !ok || r.members != members {
+		r = makeHashRing(members, services)
+		b.rings[svcMeth] = r
+	}
+	return r
+}
+
+// the ring spans all endpoints, so that one being ejected or tried
+// already only moves its own keys, to the next endpoint on the ring
+// that may be picked.
+func (b *hashBalancer) PickKey(svcMeth string, key string, all []*Service, services []*Service) *Service {
+	byAddr := map[string]*Service{}
+	for _, service := range services {
+		byAddr[service.address()] = service
+	}
+	b.mu.Lock()
+	owner := b.ring(svcMeth, all).owner(key, func(addr string) bool { return byAddr[addr] != nil })
+	b.mu.Unlock()
+	if service, ok := byAddr[owner]; ok {
+		return service
+	}
+	return services[0]
+}
+
+func (b *hashBalancer) Pick(svcMeth string, services []*Service) *Service {
+	return b.rr.Pick(svcMeth, services)
+}
+
+func (b *hashBalancer) Done(service *Service, err error) {}
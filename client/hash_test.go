@@ -0,0 +1,123 @@
+package client
+
+import (
+	"strconv"
+	"testing"
+)
+
+func keyOwners(b *hashBalancer, all []*Service, services []*Service, keys int) map[string]string {
+	owners := map[string]string{}
+	for i := 0; i < keys; i++ {
+		key := "key" + strconv.Itoa(i)
+		owners[key] = b.PickKey("KV.Get", key, all, services).address()
+	}
+	return owners
+}
+
+func TestHashKeepsKeys(t *testing.T) {
+	b := newHashBalancer()
+	services := testServices(4)
+	first := keyOwners(b, services, services, 1000)
+	again := keyOwners(b, services, services, 1000)
+	for key, owner := range first {
+		if again[key] != owner {
+			t.Fatalf("%v moved from %v to %v", key, owner, again[key])
+		}
+	}
+}
+
+func TestHashMovesFewKeysOnJoin(t *testing.T) {
+	b := newHashBalancer()
+	services := testServices(5)
+	before := keyOwners(b, services[:4], services[:4], 1000)
+	after := keyOwners(b, services, services, 1000)
+	joined := services[4].address()
+	moved := 0
+	for key, owner := range before {
+		if after[key] == owner {
+			continue
+		}
+		if after[key] != joined {
+			t.Fatalf("%v moved between old endpoints, %v to %v", key, owner, after[key])
+		}
+		moved += 1
+	}
+	// about a fifth of the keys should go to the new endpoint.
+	if moved < 100 || moved > 300 {
+		t.Fatalf("%d of 1000 keys moved", moved)
+	}
+}
+
+func TestHashKeepsKeysWhenOneIsSkipped(t *testing.T) {
+	b := newHashBalancer()
+	all := testServices(5)
+	before := keyOwners(b, all, all, 1000)
+	// the first endpoint ejected, or tried already.
+	after := keyOwners(b, all, all[1:], 1000)
+	skipped := all[0].address()
+	for key, owner := range before {
+		if owner != skipped && after[key] != owner {
+			t.Fatalf("%v moved off a live endpoint, %v to %v", key, owner, after[key])
+		}
+		if after[key] == skipped {
+			t.Fatalf("%v still goes to the skipped endpoint", key)
+		}
+	}
+}
+
+func TestHashFollowsWeight(t *testing.T) {
+	b := newHashBalancer()
+	services := testServices(2)
+	services[0].Metadata.Weight = 3
+	counts := map[string]int{}
+	for _, owner := range keyOwners(b, services, services, 4000) {
+		counts[owner] += 1
+	}
+	heavy, light := counts[services[0].address()], counts[services[1].address()]
+	if heavy < 2*light || heavy > 4*light {
+		t.Fatalf("weights 3 and 1 got %d and %d keys", heavy, light)
+	}
+}
+
+func TestCallWithKeyRoutesThroughRing(t *testing.T) {
+	e, _ := MakeClientEnd()
+	if err := e.UseBalancer("KV", ConsistentHash); err != nil {
+		t.Fatal(err)
+	}
+	services := testServices(4)
+	for _, service := range services {
+		e.AddService(service)
+	}
+	owner := e.chooseService("KV.Get", "user-7", nil)
+	for i := 0; i < 5; i++ {
+		if got := e.chooseService("KV.Get", "user-7", nil); got.address() != owner.address() {
+			t.Fatalf("key went to %v, then %v", owner.address(), got.address())
+		}
+	}
+	// a retry goes elsewhere, and always to the same next endpoint.
+	tried := map[string]bool{owner.address(): true}
+	next := e.chooseService("KV.Get", "user-7", tried)
+	if next.address() == owner.address() {
+		t.Fatal("retry went to the endpoint already tried")
+	}
+	if got := e.chooseService("KV.Get", "user-7", tried); got.address() != next.address() {
+		t.Fatalf("retries went to %v, then %v", next.address(), got.address())
+	}
+}
+
+func TestHashRingIsBounded(t *testing.T) {
+	services := testServices(2)
+	services[0].Metadata.Weight = 1 << 30
+	r := makeHashRing("", services)
+	if n := len(r.hashes); n != virtualNodes*(maxWeight+1) {
+		t.Fatalf("ring of %d points, want %d", n, virtualNodes*(maxWeight+1))
+	}
+
+	services = testServices(2000)
+	for _, service := range services {
+		service.Metadata.Weight = maxWeight
+	}
+	if n := len(makeHashRing("", services).hashes); n > maxRingPoints+len(services) {
+		t.Fatalf("ring of %d points over %d endpoints", n, len(services))
+	}
+}
@@ -0,0 +1,195 @@
+package client
+
+import (
+	"reflect"
+	"sort"
+	"srpc/common/protocol"
+	"srpc/common/trace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how a ClientEnd hedges an idempotent call: when the endpoint it
+// went to has not replied within the hedging delay, the same call is
+// sent to another endpoint as well, up to Max_attempts in all. the
+// first reply wins and the other calls are canceled. every hedge
+// counts against the retry budget.
+//
+// the delay is the Percentile of the method's recent latencies, once
+// enough calls have been seen, and Delay otherwise.
+type HedgePolicy struct {
+	Delay        time.Duration
+	Percentile   float64 // e.g. 95; 0 to always wait Delay
+	Max_attempts int     // first try included
+}
+
+func DefaultHedgePolicy() *HedgePolicy {
+	return &HedgePolicy{Delay: 50 * time.Millisecond, Max_attempts: 2}
+}
+
+const (
+	latencyWindow  = 128 // recent latencies kept per method
+	latencyMinimum = 16  // latencies needed before trusting a percentile
+)
+
+// the hedge that lost the race, or was picked and then not sent.
+var errHedgeCanceled error = protocol.NewError(protocol.Canceled, "srpc: hedged call canceled")
+
+// the latencies of the last successful calls of one method.
+type latencies struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) < latencyWindow {
+		l.samples = append(l.samples, d)
+	} else {
+		l.samples[l.next] = d
+		l.next = (l.next + 1) % latencyWindow
+	}
+}
+
+// the p-th percentile, false when too few calls have been seen.
+func (l *latencies) percentile(p float64) (time.Duration, bool) {
+	l.mu.Lock()
+	sorted := append([]time.Duration{}, l.samples...)
+	l.mu.Unlock()
+	if len(sorted) < latencyMinimum {
+		return 0, false
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(p / 100 * float64(len(sorted)))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i], true
+}
+
+// hedge calls to a service, or to one "Service.Method", under p.
+// a nil p turns hedging off again.
+func (e *ClientEnd) SetHedgePolicy(name string, p *HedgePolicy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.network == nil {
+		e.network = &Network{}
+	}
+	if e.network.Hedging == nil {
+		e.network.Hedging = map[string]*HedgePolicy{}
+	}
+	if p == nil {
+		delete(e.network.Hedging, name)
+	} else {
+		e.network.Hedging[name] = p
+	}
+}
+
+// the hedging policy for svcMeth, nil if it is not to be hedged.
+func (e *ClientEnd) hedgePolicy(svcMeth string) *HedgePolicy {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.network == nil || !e.idempotentLocked(svcMeth) {
+		return nil
+	}
+	if p, ok := e.network.Hedging[svcMeth]; ok {
+		return p
+	}
+	if dot := strings.LastIndex(svcMeth, "."); dot >= 0 {
+		return e.network.Hedging[svcMeth[:dot]]
+	}
+	return nil
+}
+
+func (e *ClientEnd) latencies(svcMeth string) *latencies {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.latency == nil {
+		e.latency = map[string]*latencies{}
+	}
+	l, ok := e.latency[svcMeth]
+	if !ok {
+		l = &latencies{}
+		e.latency[svcMeth] = l
+	}
+	return l
+}
+
+func (e *ClientEnd) hedgeDelay(svcMeth string, p *HedgePolicy) time.Duration {
+	if p.Percentile > 0 {
+		if d, ok := e.latencies(svcMeth).percentile(p.Percentile); ok {
+			return d
+		}
+	}
+	return p.Delay
+}
+
+// call svcMeth on first, and on other endpoints too while it keeps
+// us waiting. returns the endpoint that answered, or the last one
+// that failed.
+func (e *ClientEnd) hedgeService(first *Service, svcMeth string, key string, tried map[string]bool,
+	p *HedgePolicy, budget *retryBudget, span *trace.Span, args interface{}, reply interface{}) (*Service, error) {
+	type result struct {
+		service *Service
+		reply   reflect.Value
+		err     error
+	}
+	results := make(chan result, p.Max_attempts)
+	cancel := make(chan struct{})
+	defer close(cancel)
+
+	// every call decodes into a reply of its own, so that a loser
+	// still running cannot scribble over the winner's.
+	launch := func(service *Service) {
+		tried[service.address()] = true
+		rv := reflect.New(reflect.TypeOf(reply).Elem())
+		go func() {
+			err := e.attemptService(service, svcMeth, span, args, rv.Interface(), cancel)
+			results <- result{service, rv, err}
+		}()
+	}
+
+	launch(first)
+	sent, pending := 1, 1
+	delay := e.hedgeDelay(svcMeth, p)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var last result
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending -= 1
+			if r.err == nil {
+				reflect.ValueOf(reply).Elem().Set(r.reply.Elem())
+				return r.service, nil
+			}
+			last = r
+		case <-timer.C:
+			if sent >= p.Max_attempts {
+				continue
+			}
+			service := e.chooseService(svcMeth, key, tried)
+			if service == nil {
+				continue
+			}
+			if tried[service.address()] {
+				// nowhere new to send it.
+				e.release(service, errHedgeCanceled)
+				continue
+			}
+			// only a hedge that is sent costs a token.
+			if !budget.withdraw() {
+				e.release(service, errHedgeCanceled)
+				continue
+			}
+			launch(service)
+			sent += 1
+			pending += 1
+			timer.Reset(delay)
+		}
+	}
+	return last.service, last.err
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"srpc/common/protocol"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a Transport that answers every call with reply, the first of
+// them only after slow.
+type slowTransport struct {
+	mu    sync.Mutex
+	calls []string
+	slow  time.Duration
+	reply protocol.ReplyMsg
+}
+
+func (st *slowTransport) Send(addr string, req protocol.ReqMsg) {
+	st.mu.Lock()
+	st.calls = append(st.calls, addr)
+	var d time.Duration
+	if len(st.calls) == 1 {
+		d = st.slow
+	}
+	st.mu.Unlock()
+	go func() {
+		time.Sleep(d)
+		req.ReplyCh <- st.reply
+	}()
+}
+
+func (st *slowTransport) sent() []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return append([]string{}, st.calls...)
+}
+
+func makeHedgeEnd(st *slowTransport, n int, p *HedgePolicy) *ClientEnd {
+	e, _ := MakeClientEnd()
+	e.SetTransport(st)
+	for _, service := range testServices(n) {
+		e.AddService(service)
+	}
+	e.SetIdempotent("KV", true)
+	e.SetHedgePolicy("KV", p)
+	return e
+}
+
+func TestHedgeBeatsSlowEndpoint(t *testing.T) {
+	st := &slowTransport{reply: okReply("v"), slow: time.Second}
+	e := makeHedgeEnd(st, 2, &HedgePolicy{Delay: 10 * time.Millisecond, Max_attempts: 2})
+
+	start := time.Now()
+	var reply string
+	if !e.Call("KV.Get", "k", &reply) || reply != "v" {
+		t.Fatalf("hedged call failed, reply %q", reply)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("hedged call waited %v on the slow endpoint", d)
+	}
+	if calls := st.sent(); len(calls) != 2 || calls[0] == calls[1] {
+		t.Fatalf("calls went to %v, want both endpoints", calls)
+	}
+}
+
+func TestNoHedgeForFastOrNonIdempotentCalls(t *testing.T) {
+	st := &slowTransport{reply: okReply("v")}
+	e := makeHedgeEnd(st, 2, &HedgePolicy{Delay: 100 * time.Millisecond, Max_attempts: 2})
+	var reply string
+	if !e.Call("KV.Get", "k", &reply) {
+		t.Fatal("call failed")
+	}
+	if n := len(st.sent()); n != 1 {
+		t.Fatalf("fast call sent %d times", n)
+	}
+
+	st = &slowTransport{reply: okReply("v"), slow: 50 * time.Millisecond}
+	e = makeHedgeEnd(st, 2, &HedgePolicy{Delay: time.Millisecond, Max_attempts: 2})
+	e.SetIdempotent("KV", false)
+	if !e.Call("KV.Get", "k", &reply) {
+		t.Fatal("call failed")
+	}
+	if n := len(st.sent()); n != 1 {
+		t.Fatalf("non-idempotent call sent %d times", n)
+	}
+}
+
+func TestHedgeDelayFollowsPercentile(t *testing.T) {
+	e, _ := MakeClientEnd()
+	p := &HedgePolicy{Delay: time.Second, Percentile: 90}
+	if d := e.hedgeDelay("KV.Get", p); d != time.Second {
+		t.Fatalf("delay with no latencies seen: %v", d)
+	}
+	for i := 1; i <= 100; i++ {
+		e.latencies("KV.Get").add(time.Duration(i) * time.Millisecond)
+	}
+	if d := e.hedgeDelay("KV.Get", p); d < 85*time.Millisecond || d > 95*time.Millisecond {
+		t.Fatalf("p90 of 1..100ms is %v", d)
+	}
+}
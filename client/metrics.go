@@ -0,0 +1,30 @@
+package client
+
+import (
+	"net/http"
+	"srpc/common/stats"
+)
+
+// serve the client end's stats to Prometheus, for mounting on a mux
+// of the program's own; a client has no port to serve them on.
+func (e *ClientEnd) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := e.Stats()
+		w.Header().Set("Content-Type", stats.PromContentType)
+		p := stats.MakePromWriter(w)
+		s.WritePrometheus(p, "srpc_client")
+		if len(s.Endpoints) == 0 {
+			return
+		}
+		p.Header("srpc_client_breaker_state", "gauge", "The state of an endpoint's circuit breaker, 1 for the one it is in.")
+		for _, ep := range s.Endpoints {
+			p.Sample("srpc_client_breaker_state", 1, "service", ep.Service_name, "address", ep.Address,
+				"state", ep.State)
+		}
+		p.Header("srpc_client_breaker_ejections_total", "counter", "Times an endpoint was taken out of rotation.")
+		for _, ep := range s.Endpoints {
+			p.Sample("srpc_client_breaker_ejections_total", float64(ep.Ejections), "service", ep.Service_name,
+				"address", ep.Address)
+		}
+	})
+}
@@ -0,0 +1,33 @@
+package client
+
+import (
+	"net/http/httptest"
+	"srpc/common/protocol"
+	"srpc/common/stats"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	e, services := makeBreakerEnd(t, DefaultBreakerPolicy(), 1)
+	e.stats.Record("KV.Get", protocol.OK, 10, 20, time.Millisecond)
+	record(e, services[0], errTestUnavailable)
+
+	w := httptest.NewRecorder()
+	e.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != stats.PromContentType {
+		t.Fatalf("content type %q", ct)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`srpc_client_requests_total{service="KV",method="Get"} 1`,
+		`srpc_client_sent_bytes_total{service="KV",method="Get"} 20`,
+		`srpc_client_breaker_state{service="KV",address="10.0.0.1:1",state="closed"} 1`,
+		`srpc_client_breaker_ejections_total{service="KV",address="10.0.0.1:1"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("no %s in\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package client
+
+import (
+	"math"
+	"math/rand"
+	"srpc/common/protocol"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how a ClientEnd retries a failed call. retries only happen for
+// methods marked idempotent, since a call that failed may still
+// have run on the server.
+type RetryPolicy struct {
+	Max_attempts    int             // first try included
+	Initial_backoff time.Duration   // wait before the first retry
+	Max_backoff     time.Duration   // cap on the wait
+	Multiplier      float64         // backoff growth per retry
+	Jitter          float64         // waits are randomized by +/- this fraction
+	Retryable_codes []protocol.Code // failures worth another try
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		Max_attempts:    3,
+		Initial_backoff: 50 * time.Millisecond,
+		Max_backoff:     1 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.2,
+		Retryable_codes: []protocol.Code{protocol.Unavailable, protocol.DeadlineExceeded},
+	}
+}
+
+func (p *RetryPolicy) retryable(code protocol.Code) bool {
+	for _, c := range p.Retryable_codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// the wait after the attempt-th try failed, attempt counting from 1.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.Initial_backoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.Max_backoff > 0 && d > float64(p.Max_backoff) {
+		d = float64(p.Max_backoff)
+	}
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d)
+}
+
+// limits retries to a fraction of the calls made, so a failing
+// service sees a bounded amount of extra load instead of every
+// client multiplying its traffic. every call deposits Ratio tokens,
+// every retry takes one; Min_per_second tokens trickle in on their
+// own so that a lightly used client can still retry.
+type RetryBudget struct {
+	Ratio          float64
+	Min_per_second float64
+	Max_tokens     float64 // most that can be saved up
+}
+
+func DefaultRetryBudget() *RetryBudget {
+	return &RetryBudget{Ratio: 0.1, Min_per_second: 10, Max_tokens: 100}
+}
+
+type retryBudget struct {
+	mu     sync.Mutex
+	conf   RetryBudget
+	tokens float64
+	last   time.Time
+}
+
+func makeRetryBudget(conf *RetryBudget) *retryBudget {
+	if conf == nil {
+		conf = DefaultRetryBudget()
+	}
+	return &retryBudget{conf: *conf, tokens: conf.Max_tokens, last: time.Now()}
+}
+
+// the caller holds b.mu.
+func (b *retryBudget) add(tokens float64) {
+	b.tokens += tokens
+	if b.tokens > b.conf.Max_tokens {
+		b.tokens = b.conf.Max_tokens
+	}
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.add(b.conf.Ratio)
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.add(now.Sub(b.last).Seconds() * b.conf.Min_per_second)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens -= 1
+	return true
+}
+
+// retry calls to a service, or to one "Service.Method", under p.
+// a nil p turns retries off again.
+func (e *ClientEnd) SetRetryPolicy(name string, p *RetryPolicy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.network == nil {
+		e.network = &Network{}
+	}
+	if e.network.Retries == nil {
+		e.network.Retries = map[string]*RetryPolicy{}
+	}
+	if p == nil {
+		delete(e.network.Retries, name)
+	} else {
+		e.network.Retries[name] = p
+	}
+}
+
+// mark a service, or one "Service.Method", as safe to call more
+// than once. only idempotent calls are retried or hedged.
+func (e *ClientEnd) SetIdempotent(name string, idempotent bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.network == nil {
+		e.network = &Network{}
+	}
+	if e.network.Idempotent == nil {
+		e.network.Idempotent = map[string]bool{}
+	}
+	e.network.Idempotent[name] = idempotent
+}
+
+// bound retries across all calls of this client by conf.
+func (e *ClientEnd) SetRetryBudget(conf *RetryBudget) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.budget = makeRetryBudget(conf)
+}
+
+// the retry policy for svcMeth, nil if it is not to be retried.
+func (e *ClientEnd) retryPolicy(svcMeth string) *RetryPolicy {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.network == nil || !e.idempotentLocked(svcMeth) {
+		return nil
+	}
+	if p, ok := e.network.Retries[svcMeth]; ok {
+		return p
+	}
+	if dot := strings.LastIndex(svcMeth, "."); dot >= 0 {
+		return e.network.Retries[svcMeth[:dot]]
+	}
+	return nil
+}
+
+// the caller holds e.mu.
+func (e *ClientEnd) idempotentLocked(svcMeth string) bool {
+	if idempotent, ok := e.network.Idempotent[svcMeth]; ok {
+		return idempotent
+	}
+	if dot := strings.LastIndex(svcMeth, "."); dot >= 0 {
+		return e.network.Idempotent[svcMeth[:dot]]
+	}
+	return false
+}
+
+func (e *ClientEnd) retryBudget() *retryBudget {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.budget == nil {
+		var conf *RetryBudget
+		if e.network != nil {
+			conf = e.network.Retry_budget
+		}
+		e.budget = makeRetryBudget(conf)
+	}
+	return e.budget
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"bytes"
+	"srpc/common/protocol"
+	"srpc/common/sgob"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a Transport that answers each call as reply says, without a
+// network in between; from a goroutine of its own if async.
+type fakeTransport struct {
+	mu    sync.Mutex
+	calls []string // address of each call, in order
+	reply func(addr string, n int) protocol.ReplyMsg
+	async bool
+}
+
+func (ft *fakeTransport) Send(addr string, req protocol.ReqMsg) {
+	ft.mu.Lock()
+	ft.calls = append(ft.calls, addr)
+	n := len(ft.calls)
+	async := ft.async
+	ft.mu.Unlock()
+	if async {
+		go func() { req.ReplyCh <- ft.reply(addr, n) }()
+	} else {
+		req.ReplyCh <- ft.reply(addr, n)
+	}
+}
+
+func (ft *fakeTransport) sent() []string {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return append([]string{}, ft.calls...)
+}
+
+func okReply(v interface{}) protocol.ReplyMsg {
+	b := new(bytes.Buffer)
+	if err := sgob.NewEncoder(b).Encode(v); err != nil {
+		panic(err)
+	}
+	return protocol.ReplyMsg{Ok: true, Reply: b.Bytes()}
+}
+
+func failReply(code protocol.Code) protocol.ReplyMsg {
+	return protocol.ReplyMsg{Ok: false, Code: code}
+}
+
+// a client end over ft with n endpoints of KV.Get, retrying KV.
+func makeRetryEnd(ft *fakeTransport, n int, idempotent bool) *ClientEnd {
+	e, _ := MakeClientEnd()
+	e.SetTransport(ft)
+	for _, service := range testServices(n) {
+		e.AddService(service)
+	}
+	p := DefaultRetryPolicy()
+	p.Initial_backoff = time.Millisecond
+	e.SetRetryPolicy("KV", p)
+	e.SetIdempotent("KV", idempotent)
+	return e
+}
+
+func TestRetryOnOtherEndpoint(t *testing.T) {
+	ft := &fakeTransport{reply: func(addr string, n int) protocol.ReplyMsg {
+		if n < 3 {
+			return failReply(protocol.Unavailable)
+		}
+		return okReply("v")
+	}}
+	e := makeRetryEnd(ft, 3, true)
+	var reply string
+	if !e.Call("KV.Get", "k", &reply) || reply != "v" {
+		t.Fatalf("call failed, reply %q", reply)
+	}
+	calls := ft.sent()
+	if len(calls) != 3 || calls[0] == calls[1] || calls[1] == calls[2] || calls[0] == calls[2] {
+		t.Fatalf("attempts went to %v, want three different endpoints", calls)
+	}
+}
+
+func TestRetryOnlyWhatMayBeRetried(t *testing.T) {
+	cases := []struct {
+		name       string
+		idempotent bool
+		code       protocol.Code
+		want       int
+	}{
+		{"idempotent, unavailable", true, protocol.Unavailable, 3},
+		{"idempotent, timed out", true, protocol.DeadlineExceeded, 3},
+		{"idempotent, not found", true, protocol.NotFound, 1},
+		{"not idempotent", false, protocol.Unavailable, 1},
+	}
+	for _, c := range cases {
+		ft := &fakeTransport{reply: func(string, int) protocol.ReplyMsg { return failReply(c.code) }}
+		e := makeRetryEnd(ft, 3, c.idempotent)
+		var reply string
+		if e.Call("KV.Get", "k", &reply) {
+			t.Fatalf("%v: call succeeded", c.name)
+		}
+		if n := len(ft.sent()); n != c.want {
+			t.Errorf("%v: %d attempts, want %d", c.name, n, c.want)
+		}
+	}
+}
+
+func TestRetryBudgetBoundsRetries(t *testing.T) {
+	ft := &fakeTransport{reply: func(string, int) protocol.ReplyMsg { return failReply(protocol.Unavailable) }}
+	e := makeRetryEnd(ft, 3, true)
+	e.SetRetryBudget(&RetryBudget{Ratio: 0.5, Max_tokens: 2})
+	var reply string
+	for i := 0; i < 10; i++ {
+		e.Call("KV.Get", "k", &reply)
+	}
+	// 2 saved up, and half a token for each of 10 calls.
+	if n := len(ft.sent()); n > 10+2+5 {
+		t.Fatalf("%d attempts for 10 calls", n)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := &RetryPolicy{Initial_backoff: 10 * time.Millisecond, Max_backoff: 50 * time.Millisecond, Multiplier: 2}
+	want := []time.Duration{10, 20, 40, 50, 50}
+	for i, w := range want {
+		if d := p.backoff(i + 1); d != w*time.Millisecond {
+			t.Errorf("backoff after try %d: %v, want %v", i+1, d, w*time.Millisecond)
+		}
+	}
+	p.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(1); d < 8*time.Millisecond || d > 12*time.Millisecond {
+			t.Fatalf("jittered backoff %v, outside 10ms +/- 20%%", d)
+		}
+	}
+}
+
+func TestRetriesFromConfig(t *testing.T) {
+	e, err := MakeClientEndFromConfigText(`{"retries": {"KV": {"max_attempts": 5}}, "idempotent": ["KV.Get"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := e.retryPolicy("KV.Get"); p == nil || p.Max_attempts != 5 {
+		t.Fatalf("KV.Get policy %+v", p)
+	}
+	if p := e.retryPolicy("KV.Put"); p != nil {
+		t.Fatal("KV.Put, not idempotent, is retried")
+	}
+}
@@ -0,0 +1,190 @@
+package client
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"srpc/common/protocol"
+	"srpc/common/tlsconf"
+	"sync"
+	"time"
+)
+
+const dialTimeout = 3 * time.Second
+
+// the Transport ClientEnds use unless told otherwise: one TCP
+// connection per server, shared by all calls to it.
+var DefaultTransport protocol.Transport = MakeTCPTransport()
+
+type TCPTransport struct {
+	mu    sync.Mutex
+	conns map[string]*tcpConn // address -> connection
+	tls   *tlsconf.Loader     // nil for plain TCP
+}
+
+type tcpConn struct {
+	mu      sync.Mutex // guards writes, pending and seqs
+	conn    net.Conn
+	w       *bufio.Writer
+	seq     uint64
+	pending map[uint64]protocol.ReqMsg
+	seqs    map[chan protocol.ReplyMsg]uint64 // a pending call's ReplyCh -> its seq
+	broken  bool
+}
+
+func MakeTCPTransport() *TCPTransport {
+	return &TCPTransport{conns: map[string]*tcpConn{}}
+}
+
+// like MakeTCPTransport, but every connection is TLS, with the
+// certificates l has at the time of dialing.
+func MakeTLSTransport(l *tlsconf.Loader) *TCPTransport {
+	t := MakeTCPTransport()
+	t.tls = l
+	return t
+}
+
+func (t *TCPTransport) Send(addr string, req protocol.ReqMsg) {
+	c, err := t.conn(addr)
+	if err != nil {
+		req.ReplyCh <- protocol.ReplyMsg{Ok: false, Code: protocol.Unavailable}
+		return
+	}
+	c.send(req)
+}
+
+// the connection to addr, dialing it if there is none.
+func (t *TCPTransport) conn(addr string) (*tcpConn, error) {
+	t.mu.Lock()
+	c, ok := t.conns[addr]
+	t.mu.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	conn, err := t.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	c = &tcpConn{conn: conn, w: bufio.NewWriter(conn), pending: map[uint64]protocol.ReqMsg{},
+		seqs: map[chan protocol.ReplyMsg]uint64{}}
+
+	t.mu.Lock()
+	if old, ok := t.conns[addr]; ok {
+		// lost a race with another dial.
+		t.mu.Unlock()
+		conn.Close()
+		return old, nil
+	}
+	t.conns[addr] = c
+	t.mu.Unlock()
+
+	go t.read(addr, c)
+	return c, nil
+}
+
+func (t *TCPTransport) dial(addr string) (net.Conn, error) {
+	if t.tls == nil {
+		return net.DialTimeout("tcp", addr, dialTimeout)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	return tls.DialWithDialer(dialer, "tcp", addr, t.tls.ClientConfig(host))
+}
+
+// stop waiting for the reply to req, which a caller has given up on.
+func (t *TCPTransport) Forget(addr string, req protocol.ReqMsg) {
+	t.mu.Lock()
+	c, ok := t.conns[addr]
+	t.mu.Unlock()
+	if ok {
+		c.forget(req)
+	}
+}
+
+// close every connection; calls in flight fail.
+func (t *TCPTransport) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for addr, c := range t.conns {
+		c.conn.Close()
+		delete(t.conns, addr)
+	}
+}
+
+func (c *tcpConn) send(req protocol.ReqMsg) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.broken {
+		req.ReplyCh <- protocol.ReplyMsg{Ok: false, Code: protocol.Unavailable}
+		return
+	}
+	c.seq += 1
+	f := &protocol.Frame{Seq: c.seq, SvcMeth: req.SvcMeth, Meta: req.Meta, Body: req.Args}
+	if req.Endname != nil {
+		f.Endname = fmt.Sprint(req.Endname)
+	}
+	err := protocol.WriteFrame(c.w, f)
+	if err == nil {
+		err = c.w.Flush()
+	}
+	if err != nil {
+		// the reader notices too, and fails the other calls.
+		c.conn.Close()
+		req.ReplyCh <- protocol.ReplyMsg{Ok: false, Code: protocol.Unavailable}
+		return
+	}
+	c.pending[c.seq] = req
+	c.seqs[req.ReplyCh] = c.seq
+}
+
+func (c *tcpConn) forget(req protocol.ReqMsg) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if seq, ok := c.seqs[req.ReplyCh]; ok {
+		delete(c.pending, seq)
+		delete(c.seqs, req.ReplyCh)
+	}
+}
+
+// hand replies to their calls until the connection breaks, then
+// fail whatever is still waiting.
+func (t *TCPTransport) read(addr string, c *tcpConn) {
+	r := bufio.NewReader(c.conn)
+	for {
+		f, err := protocol.ReadFrame(r)
+		if err != nil {
+			break
+		}
+		c.mu.Lock()
+		req, ok := c.pending[f.Seq]
+		delete(c.pending, f.Seq)
+		if ok {
+			delete(c.seqs, req.ReplyCh)
+		}
+		c.mu.Unlock()
+		if ok {
+			req.ReplyCh <- protocol.ReplyMsg{Ok: f.Ok, Reply: f.Body, Code: f.Code}
+		}
+	}
+	c.conn.Close()
+
+	t.mu.Lock()
+	if t.conns[addr] == c {
+		delete(t.conns, addr)
+	}
+	t.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.broken = true
+	for seq, req := range c.pending {
+		req.ReplyCh <- protocol.ReplyMsg{Ok: false, Code: protocol.Unavailable}
+		delete(c.pending, seq)
+	}
+	c.seqs = map[chan protocol.ReplyMsg]uint64{}
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"srpc/common/protocol"
+	"srpc/common/trace"
+	"testing"
+	"time"
+)
+
+// a server that reads requests and never replies to any.
+func silentServer(t *testing.T) (addr string, received chan uint64) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	received = make(chan uint64, 100)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			go func() {
+				r := bufio.NewReader(conn)
+				for {
+					f, err := protocol.ReadFrame(r)
+					if err != nil {
+						return
+					}
+					received <- f.Seq
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), received
+}
+
+func pendingCalls(tr *TCPTransport, addr string) int {
+	tr.mu.Lock()
+	c := tr.conns[addr]
+	tr.mu.Unlock()
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending) + len(c.seqs)
+}
+
+func TestTimedOutCallsAreForgotten(t *testing.T) {
+	addr, received := silentServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+	tr := MakeTCPTransport()
+	defer tr.Close()
+	e, _ := MakeClientEnd()
+	e.SetTransport(tr)
+	e.SetCallTimeout(20 * time.Millisecond)
+	e.AddService(&Service{Service_name: "KV", Method_name: "Get", Server_ip: host, Server_port: port,
+		Service_enabled: true})
+
+	for i := 0; i < 10; i++ {
+		var reply int
+		if e.Call("KV.Get", i, &reply) {
+			t.Fatal("call with no reply succeeded")
+		}
+	}
+	if n := len(received); n != 10 {
+		t.Fatalf("server got %d calls, want 10", n)
+	}
+	if n := pendingCalls(tr, addr); n != 0 {
+		t.Fatalf("%d calls still pending after their timeouts", n)
+	}
+
+	// a call cut short by Close is forgotten too.
+	e.SetCallTimeout(-1)
+	done := make(chan bool)
+	go func() {
+		var reply int
+		done <- e.Call("KV.Get", 0, &reply)
+	}()
+	<-received
+	e.Close()
+	if <-done {
+		t.Fatal("call succeeded after Close")
+	}
+	if n := pendingCalls(tr, addr); n != 0 {
+		t.Fatalf("%d calls still pending after Close", n)
+	}
+}
+
+func TestCallTimesOutAndRetries(t *testing.T) {
+	silent := testServices(1)[0].address()
+	ft := &fakeTransport{async: true, reply: func(addr string, n int) protocol.ReplyMsg {
+		if addr == silent {
+			time.Sleep(time.Second)
+			return failReply(protocol.Unavailable)
+		}
+		return okReply("v")
+	}}
+	e := makeRetryEnd(ft, 2, false)
+	e.SetCallTimeout(20 * time.Millisecond)
+
+	// the first call goes to the silent endpoint.
+	start := time.Now()
+	var reply string
+	span := e.getTracer().Start("KV.Get", trace.KindClient, trace.SpanContext{})
+	err := e.retry("KV.Get", "", span, "k", &reply)
+	if protocol.CodeOf(err) != protocol.DeadlineExceeded {
+		t.Fatalf("unanswered call failed with %v, want %v", err, protocol.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("timed out after %v", d)
+	}
+
+	// an idempotent call that times out is tried on the other
+	// endpoint. with round robin, one of the next two calls starts
+	// with the silent one.
+	e.SetIdempotent("KV", true)
+	for i := 0; i < 2; i++ {
+		if !e.Call("KV.Get", "k", &reply) || reply != "v" {
+			t.Fatalf("retried call failed, reply %q", reply)
+		}
+	}
+	if calls := ft.sent(); len(calls) != 4 {
+		t.Fatalf("calls went to %v, want one timeout, one retry", calls)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+//
+// chaosproxy -listen :30000 -upstream 127.0.0.1:20000 -control :30001
+//
+// point ClientEnds at the listen address instead of the server's,
+// then change the rules with e.g.
+//
+// curl -X POST localhost:30001/rules -d '{"service": "KV", "method": "Get", "action": "delay", "delay_ms": 200}'
+//
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"srpc/chaos"
+	"srpc/common/logging"
+	"time"
+)
+
+// log at error level and exit, as log.Fatalf would.
+func fatal(msg string, fields ...logging.Field) {
+	logging.Default.Log(logging.Error, msg, fields...)
+	os.Exit(1)
+}
+
+func main() {
+	listen := flag.String("listen", ":30000", "address to accept clients on")
+	upstream := flag.String("upstream", "127.0.0.1:20000", "address of the server")
+	control := flag.String("control", ":30001", "address of the HTTP control API")
+	rules := flag.String("rules", "", "JSON file with the rules to start with")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "seed for the random choices")
+	flag.Parse()
+
+	p := chaos.MakeProxy(*upstream, *seed)
+	if *rules != "" {
+		f, err := os.Open(*rules)
+		if err != nil {
+			fatal("chaosproxy: open rules", logging.F("path", *rules), logging.Err(err))
+		}
+		list := []*chaos.Rule{}
+		err = json.NewDecoder(f).Decode(&list)
+		f.Close()
+		if err == nil {
+			err = p.SetRules(list)
+		}
+		if err != nil {
+			fatal("chaosproxy: read rules", logging.F("path", *rules), logging.Err(err))
+		}
+	}
+
+	go func() {
+		if err := http.ListenAndServe(*control, p.Handler()); err != nil {
+			fatal("chaosproxy: serve control API", logging.F("addr", *control), logging.Err(err))
+		}
+	}()
+	logging.Default.Log(logging.Info, "chaosproxy: started", logging.F("listen", *listen),
+		logging.F("upstream", *upstream), logging.F("control", *control), logging.F("seed", *seed))
+	if err := p.Serve(*listen); err != nil {
+		fatal("chaosproxy: serve", logging.F("listen", *listen), logging.Err(err))
+	}
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"srpc/common/protocol"
+	"srpc/common/tlsconf"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// who is calling. clients put credentials in the request metadata,
+// and a server's Authenticator turns them into a principal, the name
+// its Policy grants methods to.
+
+// request metadata keys.
+const (
+	AuthorizationKey = "authorization" // "Bearer <token>"
+	KeyIDKey         = "srpc-key-id"
+	TimestampKey     = "srpc-timestamp" // unix nanoseconds
+	NonceKey         = "srpc-nonce"     // hex, new for every request
+	SignatureKey     = "srpc-signature" // hex HMAC-SHA256, see Sign
+)
+
+// how far the clocks of a signing client and its server may differ.
+const MaxSkew = 5 * time.Minute
+
+// the request carries no credentials an Authenticator knows.
+var ErrNoCredentials = protocol.NewError(protocol.Unauthenticated, "srpc: no credentials")
+
+var errBadCredentials = protocol.NewError(protocol.Unauthenticated, "srpc: bad credentials")
+
+type Authenticator interface {
+	// the principal req comes from. ErrNoCredentials if it carries
+	// nothing this Authenticator looks at, another Unauthenticated
+	// error if it does but they do not check out.
+	Authenticate(req *protocol.ReqMsg) (string, error)
+}
+
+// shared tokens, each standing for a principal.
+type TokenAuth struct {
+	Tokens map[string]string // token -> principal
+}
+
+func (a *TokenAuth) Authenticate(req *protocol.ReqMsg) (string, error) {
+	h, ok := req.Meta[AuthorizationKey]
+	if !ok || !strings.HasPrefix(h, "Bearer ") {
+		return "", ErrNoCredentials
+	}
+	token := strings.TrimPrefix(h, "Bearer ")
+	for t, principal := range a.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return principal, nil
+		}
+	}
+	return "", errBadCredentials
+}
+
+// requests signed with a secret shared per key id; the principal
+// is the key id. a signed request is accepted only once.
+type HMACAuth struct {
+	Keys map[string][]byte // key id -> secret
+
+	once    sync.Once
+	replays *ReplayCache
+}
+
+func (a *HMACAuth) Authenticate(req *protocol.ReqMsg) (string, error) {
+	id, ok := req.Meta[KeyIDKey]
+	if !ok {
+		return "", ErrNoCredentials
+	}
+	secret, ok := a.Keys[id]
+	if !ok {
+		return "", errBadCredentials
+	}
+	ns, err := strconv.ParseInt(req.Meta[TimestampKey], 10, 64)
+	if err != nil {
+		return "", errBadCredentials
+	}
+	skew := time.Since(time.Unix(0, ns))
+	if skew > MaxSkew || skew < -MaxSkew {
+		return "", protocol.NewError(protocol.Unauthenticated, "srpc: signature too old or from the future")
+	}
+	if req.Meta[NonceKey] == "" {
+		return "", errBadCredentials
+	}
+	sig, err := hex.DecodeString(req.Meta[SignatureKey])
+	if err != nil || !hmac.Equal(sig, Sign(secret, id, req.Meta[TimestampKey], SignedMethod(req.SvcMeth, req.Meta),
+		req.Args)) {
+		return "", errBadCredentials
+	}
+	a.once.Do(func() { a.replays = MakeReplayCache() })
+	if !a.replays.First(id, req.Meta[NonceKey]) {
+		return "", protocol.NewError(protocol.Unauthenticated, "srpc: signed request replayed")
+	}
+	return id, nil
+}
+
+// what a signature covers of a call besides its args: the method,
+// the nonce and the priority asked for, e.g. "KV.Get\n<nonce>\n5".
+func SignedMethod(svcMeth string, meta map[string]string) string {
+	return svcMeth + "\n" + meta[NonceKey] + "\n" + meta[protocol.PriorityKey]
+}
+
+// what a signing client sends in SignatureKey, hex encoded: the
+// HMAC-SHA256 under secret of the key id, the timestamp, the target
+// (see SignedMethod and connect.SignedTarget) and the SHA-256 of the
+// body, one per line.
+func Sign(secret []byte, id string, timestamp string, target string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id + "\n" + timestamp + "\n" + target + "\n" + hex.EncodeToString(sum[:])))
+	return mac.Sum(nil)
+}
+
+// the nonces of signed requests of the last MaxSkew, so that none is
+// accepted twice. older ones need not be remembered, since their
+// timestamps are no longer fresh.
+type ReplayCache struct {
+	mu    sync.Mutex
+	seen  map[string]time.Time // id and nonce -> when first seen
+	swept time.Time
+}
+
+func MakeReplayCache() *ReplayCache {
+	return &ReplayCache{seen: map[string]time.Time{}, swept: time.Now()}
+}
+
+// note nonce, of a request signed by id. false if it was seen before.
+func (rc *ReplayCache) First(id string, nonce string) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	now := time.Now()
+	if now.Sub(rc.swept) > MaxSkew {
+		for k, t := range rc.seen {
+			if now.Sub(t) > 2*MaxSkew {
+				delete(rc.seen, k)
+			}
+		}
+		rc.swept = now
+	}
+	k := id + "\n" + nonce
+	if _, ok := rc.seen[k]; ok {
+		return false
+	}
+	rc.seen[k] = now
+	return true
+}
+
+// the name in the client certificate of a mutual TLS connection.
+type TLSAuth struct{}
+
+func (TLSAuth) Authenticate(req *protocol.ReqMsg) (string, error) {
+	if name := tlsconf.PeerName(req.TLS); name != "" {
+		return name, nil
+	}
+	return "", ErrNoCredentials
+}
+
+// the first of several authenticators that finds credentials.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(req *protocol.ReqMsg) (string, error) {
+	for _, a := range c {
+		principal, err := a.Authenticate(req)
+		if !errors.Is(err, ErrNoCredentials) {
+			return principal, err
+		}
+	}
+	return "", ErrNoCredentials
+}
+
+// what a client adds to each request to say who it is.
+type Credentials interface {
+	Apply(svcMeth string, args []byte, meta map[string]string)
+}
+
+type TokenCredentials struct {
+	Token string
+}
+
+func (c *TokenCredentials) Apply(svcMeth string, args []byte, meta map[string]string) {
+	meta[AuthorizationKey] = "Bearer " + c.Token
+}
+
+type HMACCredentials struct {
+	Key_id string
+	Secret []byte
+}
+
+// Apply goes last, once meta holds the priority.
+func (c *HMACCredentials) Apply(svcMeth string, args []byte, meta map[string]string) {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	ts := strconv.FormatInt(time.Now().UnixNano(), 10)
+	meta[KeyIDKey] = c.Key_id
+	meta[TimestampKey] = ts
+	meta[NonceKey] = hex.EncodeToString(nonce)
+	meta[SignatureKey] = hex.EncodeToString(Sign(c.Secret, c.Key_id, ts, SignedMethod(svcMeth, meta), args))
+}
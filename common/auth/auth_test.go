@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"errors"
+	"srpc/common/protocol"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func signedReq(c Credentials, svcMeth string, args string, priority int) *protocol.ReqMsg {
+	meta := map[string]string{}
+	if priority != 0 {
+		meta[protocol.PriorityKey] = strconv.Itoa(priority)
+	}
+	c.Apply(svcMeth, []byte(args), meta)
+	return &protocol.ReqMsg{SvcMeth: svcMeth, Args: []byte(args), Meta: meta}
+}
+
+// a copy of req, as a replay of it would be.
+func copyReq(req *protocol.ReqMsg) *protocol.ReqMsg {
+	meta := map[string]string{}
+	for k, v := range req.Meta {
+		meta[k] = v
+	}
+	return &protocol.ReqMsg{SvcMeth: req.SvcMeth, Args: req.Args, Meta: meta}
+}
+
+func TestHMACAuthAcceptsSigned(t *testing.T) {
+	a := &HMACAuth{Keys: map[string][]byte{"k1": []byte("secret")}}
+	c := &HMACCredentials{Key_id: "k1", Secret: []byte("secret")}
+	for i := 0; i < 3; i++ {
+		principal, err := a.Authenticate(signedReq(c, "KV.Get", "x", 5))
+		if err != nil || principal != "k1" {
+			t.Fatalf("signed request: %q, %v", principal, err)
+		}
+	}
+	if _, err := a.Authenticate(&protocol.ReqMsg{SvcMeth: "KV.Get"}); !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("unsigned request: %v", err)
+	}
+}
+
+func TestHMACAuthRejects(t *testing.T) {
+	a := &HMACAuth{Keys: map[string][]byte{"k1": []byte("secret")}}
+	c := &HMACCredentials{Key_id: "k1", Secret: []byte("secret")}
+	cases := []struct {
+		name   string
+		tamper func(req *protocol.ReqMsg)
+	}{
+		{"other args", func(req *protocol.ReqMsg) { req.Args = []byte("y") }},
+		{"other method", func(req *protocol.ReqMsg) { req.SvcMeth = "KV.Put" }},
+		{"raised priority", func(req *protocol.ReqMsg) { req.Meta[protocol.PriorityKey] = "10" }},
+		{"priority dropped", func(req *protocol.ReqMsg) { delete(req.Meta, protocol.PriorityKey) }},
+		{"other nonce", func(req *protocol.ReqMsg) { req.Meta[NonceKey] = "00" }},
+		{"no nonce", func(req *protocol.ReqMsg) { delete(req.Meta, NonceKey) }},
+		{"unknown key", func(req *protocol.ReqMsg) { req.Meta[KeyIDKey] = "k2" }},
+		{"stale", func(req *protocol.ReqMsg) {
+			req.Meta[TimestampKey] = strconv.FormatInt(time.Now().Add(-2*MaxSkew).UnixNano(), 10)
+		}},
+	}
+	for _, tc := range cases {
+		req := signedReq(c, "KV.Get", "x", 5)
+		tc.tamper(req)
+		if _, err := a.Authenticate(req); protocol.CodeOf(err) != protocol.Unauthenticated ||
+			errors.Is(err, ErrNoCredentials) {
+			t.Errorf("%v: %v", tc.name, err)
+		}
+	}
+	wrong := &HMACCredentials{Key_id: "k1", Secret: []byte("guess")}
+	if _, err := a.Authenticate(signedReq(wrong, "KV.Get", "x", 0)); err == nil {
+		t.Error("wrong secret accepted")
+	}
+}
+
+func TestHMACAuthRejectsReplay(t *testing.T) {
+	a := &HMACAuth{Keys: map[string][]byte{"k1": []byte("secret")}}
+	c := &HMACCredentials{Key_id: "k1", Secret: []byte("secret")}
+	req := signedReq(c, "KV.Put", "x", 0)
+	replay := copyReq(req)
+	if _, err := a.Authenticate(req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate(replay); protocol.CodeOf(err) != protocol.Unauthenticated {
+		t.Fatalf("replayed request: %v", err)
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	a := &TokenAuth{Tokens: map[string]string{"t0k3n": "alice"}}
+	req := &protocol.ReqMsg{Meta: map[string]string{}}
+	(&TokenCredentials{Token: "t0k3n"}).Apply("KV.Get", nil, req.Meta)
+	if principal, err := a.Authenticate(req); err != nil || principal != "alice" {
+		t.Fatalf("good token: %q, %v", principal, err)
+	}
+	req.Meta[AuthorizationKey] = "Bearer guess"
+	if _, err := a.Authenticate(req); protocol.CodeOf(err) != protocol.Unauthenticated {
+		t.Fatalf("bad token: %v", err)
+	}
+	// the first authenticator with credentials to look at decides.
+	chain := Chain{&HMACAuth{}, a}
+	if _, err := chain.Authenticate(req); protocol.CodeOf(err) != protocol.Unauthenticated {
+		t.Fatalf("chain with a bad token: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// which principals may call which methods. a call is allowed when
+// some rule matches both its principal and its method; everything
+// else is denied. in patterns "*" stands for any run of characters,
+// so "KV.*" is every method of KV and "*" is anything. a caller no
+// Authenticator recognised has the principal "", which only the
+// pattern "" matches.
+type Policy struct {
+	Rules []*Rule `json:"rules"`
+}
+
+// where a server gets the policy in force; a *Policy is its own.
+type PolicySource interface {
+	Policy() *Policy
+}
+
+func (p *Policy) Policy() *Policy {
+	return p
+}
+
+type Rule struct {
+	Principals []string `json:"principals"`
+	Methods    []string `json:"methods"` // "Service.Method" patterns
+}
+
+func (p *Policy) Allowed(principal string, svcMeth string) bool {
+	for _, r := range p.Rules {
+		if r.matchPrincipal(principal) && matchAny(r.Methods, svcMeth) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchPrincipal(principal string) bool {
+	if principal == "" {
+		for _, pat := range r.Principals {
+			if pat == "" {
+				return true
+			}
+		}
+		return false
+	}
+	return matchAny(r.Principals, principal)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pat := range patterns {
+		if match(pat, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func match(pattern string, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+	return strings.HasSuffix(name, parts[len(parts)-1])
+}
+
+func ParsePolicy(text []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := json.Unmarshal(text, p); err != nil {
+		return nil, fmt.Errorf("auth: policy: %v", err)
+	}
+	return p, nil
+}
+
+func LoadPolicy(fname string) (*Policy, error) {
+	text, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicy(text)
+}
+
+const reloadInterval = 1 * time.Second
+
+// a Policy kept in a file, read again when the file changes.
+type PolicyFile struct {
+	mu      sync.Mutex
+	fname   string
+	policy  *Policy
+	mtime   time.Time
+	checked time.Time
+	onError func(error)
+}
+
+// onError hears about versions of the file that would not load;
+// the policy before them stays in force.
+func OpenPolicyFile(fname string, onError func(error)) (*PolicyFile, error) {
+	pf := &PolicyFile{fname: fname, onError: onError}
+	st, err := os.Stat(fname)
+	if err != nil {
+		return nil, err
+	}
+	if pf.policy, err = LoadPolicy(fname); err != nil {
+		return nil, err
+	}
+	pf.mtime = st.ModTime()
+	pf.checked = time.Now()
+	return pf, nil
+}
+
+// the policy in the file, as of at most reloadInterval ago.
+func (pf *PolicyFile) Policy() *Policy {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	if time.Since(pf.checked) < reloadInterval {
+		return pf.policy
+	}
+	pf.checked = time.Now()
+	st, err := os.Stat(pf.fname)
+	if err == nil && st.ModTime().Equal(pf.mtime) {
+		return pf.policy
+	}
+	var p *Policy
+	if err == nil {
+		p, err = LoadPolicy(pf.fname)
+	}
+	if err != nil {
+		if pf.onError != nil {
+			pf.onError(err)
+		}
+		return pf.policy
+	}
+	pf.policy, pf.mtime = p, st.ModTime()
+	return pf.policy
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPolicyAllowed(t *testing.T) {
+	p, err := ParsePolicy([]byte(`{"rules": [
+		{"principals": ["billing"], "methods": ["KV.*", "Ledger.Post"]},
+		{"principals": ["ops-*"], "methods": ["*"]},
+		{"principals": [""], "methods": ["Health.Check"]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		principal, svcMeth string
+		want               bool
+	}{
+		{"billing", "KV.Get", true},
+		{"billing", "Ledger.Post", true},
+		{"billing", "Ledger.Void", false},
+		{"billing-eu", "KV.Get", false},
+		{"ops-alice", "Ledger.Void", true},
+		{"ops", "KV.Get", false},
+		{"", "Health.Check", true},
+		{"", "KV.Get", false},
+		{"billing", "Health.Check", false},
+	}
+	for _, c := range cases {
+		if got := p.Allowed(c.principal, c.svcMeth); got != c.want {
+			t.Errorf("%q calling %v: %v, want %v", c.principal, c.svcMeth, got, c.want)
+		}
+	}
+	if (&Policy{}).Allowed("billing", "KV.Get") {
+		t.Fatal("empty policy allowed a call")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*", "", true},
+		{"KV.Get", "KV.Get", true},
+		{"KV.Get", "KV.Gets", false},
+		{"KV.*", "KV.", true},
+		{"*.Get", "KV.Get", true},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "a-b-b-c", true},
+		{"a*b*c", "acb", false},
+		{"ab*ba", "aba", false},
+	}
+	for _, c := range cases {
+		if got := match(c.pattern, c.name); got != c.want {
+			t.Errorf("match(%q, %q) = %v", c.pattern, c.name, got)
+		}
+	}
+}
+
+func TestPolicyFileReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	write := func(text string, mtime time.Time) {
+		if err := os.WriteFile(path, []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mtime, mtime)
+	}
+	write(`{"rules": [{"principals": ["billing"], "methods": ["KV.Get"]}]}`, time.Now())
+	errs := 0
+	pf, err := OpenPolicyFile(path, func(error) { errs++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as if reloadInterval had passed since the last look.
+	expire := func() {
+		pf.mu.Lock()
+		pf.checked = time.Time{}
+		pf.mu.Unlock()
+	}
+
+	write(`{"rules": [{"principals": ["billing"], "methods": ["KV.Put"]}]}`, time.Now().Add(time.Minute))
+	if !pf.Policy().Allowed("billing", "KV.Get") {
+		t.Fatal("policy reloaded within the interval")
+	}
+	expire()
+	if p := pf.Policy(); p.Allowed("billing", "KV.Get") || !p.Allowed("billing", "KV.Put") {
+		t.Fatal("changed policy file not reloaded")
+	}
+
+	write(`{"rules": [`, time.Now().Add(2*time.Minute))
+	expire()
+	if !pf.Policy().Allowed("billing", "KV.Put") || errs != 1 {
+		t.Fatalf("broken policy file: %d errors reported, old policy kept %v", errs,
+			pf.Policy().Allowed("billing", "KV.Put"))
+	}
+
+	if _, err := OpenPolicyFile(filepath.Join(t.TempDir(), "nonesuch.json"), nil); err == nil {
+		t.Fatal("opened a missing policy file")
+	}
+}
@@ -1,2 +1,232 @@
 package connect
 
+// helpers for servers and clients to talk to a registry cluster.
+// every call takes the full list of registry addresses: writes are
+// redirected by followers to the current leader, reads are served
+// by whichever registry answers first.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"srpc/common/auth"
+	"strconv"
+	"time"
+)
+
+var ErrNoRegistry = errors.New("connect: no registry address configured")
+var ErrUnknownServer = errors.New("connect: server is not registered")
+var ErrConflict = errors.New("connect: registration conflicts with another server")
+var ErrUnauthenticated = errors.New("connect: registry did not accept our credentials")
+var ErrPermissionDenied = errors.New("connect: registry refused the request")
+
+// what requests to the registry are signed with: Secret is the key
+// the registry knows Id by, a server's name or a client's. a nil
+// *Key signs nothing.
+type Key struct {
+	Id     string
+	Secret string
+}
+
+// request headers of a signed request. the signature is
+// auth.Sign under the key, over SignedTarget and the body. the
+// nonce is new for every request, so that the registry can turn
+// away one that is sent again.
+const (
+	KeyIDHeader     = "Srpc-Key-Id"
+	TimestampHeader = "Srpc-Timestamp"
+	NonceHeader     = "Srpc-Nonce"
+	SignatureHeader = "Srpc-Signature"
+)
+
+// sign req, whose body is body, with k. a nil k signs nothing.
+func (k *Key) Sign(req *http.Request, body []byte) {
+	if k == nil {
+		return
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	ts := strconv.FormatInt(time.Now().UnixNano(), 10)
+	req.Header.Set(KeyIDHeader, k.Id)
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(NonceHeader, hex.EncodeToString(nonce))
+	sig := auth.Sign([]byte(k.Secret), k.Id, ts, SignedTarget(req), body)
+	req.Header.Set(SignatureHeader, hex.EncodeToString(sig))
+}
+
+// what a signature covers of req besides its body: the method, the
+// URI and the nonce, e.g. "POST /api/register\n<nonce>".
+func SignedTarget(req *http.Request) string {
+	return req.Method + " " + req.URL.RequestURI() + "\n" + req.Header.Get(NonceHeader)
+}
+
+var httpClient = &http.Client{Timeout: 2 * time.Second}
+
+// what a server announces about itself when it registers.
+type Registration struct {
+	Server_name string         `json:"server_name"`
+	Server_ip   string         `json:"server_ip"`
+	Server_port string         `json:"server_port"`
+	Epoch       int64          `json:"epoch"` // new on every start, newer registrations win conflicts
+	Services    []*ServiceInfo `json:"services"`
+}
+
+// a method offered by a server. an empty Method_name stands for
+// every method of the service, e.g. in a server's configuration.
+type ServiceInfo struct {
+	Service_name string `json:"service_name"`
+	Method_name  string `json:"method_name"`
+	Service_key  string `json:"service_key,omitempty"`
+	Metadata
+}
+
+// what a server says about a service, for clients to choose by.
+type Metadata struct {
+	Version     string            `json:"version,omitempty"`
+	Weight      int               `json:"weight,omitempty"`
+	Zone        string            `json:"zone,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Describtion string            `json:"describtion,omitempty"`
+}
+
+type ServerArgs struct {
+	Server_name string `json:"server_name"`
+}
+
+// one server offering one method, as returned by a lookup.
+type Endpoint struct {
+	Server_name  string `json:"server_name"`
+	Server_ip    string `json:"server_ip"`
+	Server_port  string `json:"server_port"`
+	Service_name string `json:"service_name"`
+	Method_name  string `json:"method_name"`
+	Service_key  string `json:"service_key,omitempty"`
+	Enabled      bool   `json:"enabled"`
+	Metadata
+}
+
+func Register(addrs []string, reg *Registration, key *Key) error {
+	return postAny(addrs, "/api/register", reg, key)
+}
+
+func Unregister(addrs []string, serverName string, key *Key) error {
+	return postAny(addrs, "/api/unregister", &ServerArgs{Server_name: serverName}, key)
+}
+
+// heartbeats go to every registry, since each of them keeps
+// its own leases. it is enough for one of them to answer.
+func Heartbeat(addrs []string, serverName string, key *Key) error {
+	if len(addrs) == 0 {
+		return ErrNoRegistry
+	}
+	var lastErr error
+	ok := false
+	for _, addr := range addrs {
+		err := post(addr, "/api/heartbeat", &ServerArgs{Server_name: serverName}, key)
+		if err == nil {
+			ok = true
+		} else {
+			lastErr = err
+		}
+	}
+	if ok {
+		return nil
+	}
+	return lastErr
+}
+
+// look up every endpoint of a method. a non-empty selector, such
+// as "version>=2,zone=east", is applied by the registry.
+func Lookup(addrs []string, serviceName, methodName, selector string, key *Key) ([]*Endpoint, error) {
+	if len(addrs) == 0 {
+		return nil, ErrNoRegistry
+	}
+	query := url.Values{}
+	query.Set("service_name", serviceName)
+	query.Set("method_name", methodName)
+	if selector != "" {
+		query.Set("selector", selector)
+	}
+	var lastErr error
+	for _, addr := range addrs {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/api/services?%s", addr, query.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+		key.Sign(req, nil)
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		endpoints := []*Endpoint{}
+		err = checkResponse(resp)
+		if err == nil {
+			err = json.NewDecoder(resp.Body).Decode(&endpoints)
+		}
+		resp.Body.Close()
+		if err == nil {
+			return endpoints, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func postAny(addrs []string, path string, body interface{}, key *Key) error {
+	if len(addrs) == 0 {
+		return ErrNoRegistry
+	}
+	var lastErr error
+	for _, addr := range addrs {
+		if lastErr = post(addr, path, body, key); lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+func post(addr, path string, body interface{}, key *Key) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://"+addr+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	key.Sign(req, data)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrUnknownServer
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return ErrUnauthenticated
+	}
+	if resp.StatusCode == http.StatusForbidden {
+		return ErrPermissionDenied
+	}
+	if resp.StatusCode == http.StatusConflict {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%w: %s", ErrConflict, bytes.TrimSpace(msg))
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("connect: registry %v replied %v", resp.Request.URL.Host, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package connect
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// a conjunction of conditions on endpoint metadata, written as
+// comma-separated terms like "version>=2,zone=east,tier!=canary".
+// the keys version, weight, zone and describtion name metadata
+// fields; any other key names a tag. versions compare part by
+// part ("1.10" > "1.9"), other values numerically when both sides
+// are numbers and as strings otherwise. a term on a missing tag
+// only matches with !=.
+type Selector struct {
+	terms []*term
+}
+
+type term struct {
+	key   string
+	op    string
+	value string
+}
+
+// longest operators first, so that ">=" is not read as ">".
+var operators = []string{">=", "<=", "!=", "=", ">", "<"}
+
+func ParseSelector(s string) (*Selector, error) {
+	sel := &Selector{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		t := parseTerm(part)
+		if t == nil {
+			return nil, fmt.Errorf("connect: bad selector term %q", part)
+		}
+		sel.terms = append(sel.terms, t)
+	}
+	return sel, nil
+}
+
+func parseTerm(part string) *term {
+	best := -1
+	op := ""
+	for _, candidate := range operators {
+		i := strings.Index(part, candidate)
+		if i > 0 && (best < 0 || i < best || (i == best && len(candidate) > len(op))) {
+			best, op = i, candidate
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	key := strings.TrimSpace(part[:best])
+	value := strings.TrimSpace(part[best+len(op):])
+	if key == "" {
+		return nil
+	}
+	return &term{key: key, op: op, value: value}
+}
+
+func (sel *Selector) String() string {
+	parts := []string{}
+	for _, t := range sel.terms {
+		parts = append(parts, t.key+t.op+t.value)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (sel *Selector) Matches(meta *Metadata) bool {
+	if sel == nil {
+		return true
+	}
+	for _, t := range sel.terms {
+		if !t.matches(meta) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *term) matches(meta *Metadata) bool {
+	var have string
+	switch t.key {
+	case "version":
+		return t.holds(compareVersions(meta.Version, t.value))
+	case "weight":
+		have = strconv.Itoa(meta.Weight)
+	case "zone":
+		have = meta.Zone
+	case "describtion":
+		have = meta.Describtion
+	default:
+		v, ok := meta.Tags[t.key]
+		if !ok {
+			return t.op == "!="
+		}
+		have = v
+	}
+	return t.holds(compareValues(have, t.value))
+}
+
+func (t *term) holds(cmp int) bool {
+	switch t.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	}
+	return false
+}
+
+func compareValues(a, b string) int {
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+// compare dotted versions part by part; a missing part counts as 0,
+// and a leading "v" is ignored.
+func compareVersions(a, b string) int {
+	pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		x, y := "0", "0"
+		if i < len(pa) && pa[i] != "" {
+			x = pa[i]
+		}
+		if i < len(pb) && pb[i] != "" {
+			y = pb[i]
+		}
+		if cmp := compareValues(x, y); cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
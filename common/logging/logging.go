@@ -0,0 +1,225 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// leveled, structured logging for servers, clients, codecs and the
+// registry. a Logger takes a message and key, value fields; Text and
+// JSON write them out, and FromSlog (go1.21 and later) hands them to
+// a log/slog logger.
+
+type Level int
+
+const (
+	Debug Level = iota - 1
+	Info
+	Warn
+	Error
+)
+
+var levelNames = map[Level]string{
+	Debug: "debug",
+	Info:  "info",
+	Warn:  "warn",
+	Error: "error",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+func ParseLevel(s string) (Level, error) {
+	for l, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return l, nil
+		}
+	}
+	return Info, fmt.Errorf("logging: unknown level %q", s)
+}
+
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{key, value}
+}
+
+// the fields every component uses the same way.
+func Service(name string) Field     { return Field{"service", name} }
+func Method(name string) Field      { return Field{"method", name} }
+func Peer(addr string) Field        { return Field{"peer", addr} }
+func Seq(seq uint64) Field          { return Field{"seq", seq} }
+func Latency(d time.Duration) Field { return Field{"latency", d} }
+func Err(err error) Field           { return Field{"error", err} }
+func Code(code fmt.Stringer) Field  { return Field{"code", code} }
+func Component(name string) Field   { return Field{"component", name} }
+
+// "Service.Method" as a service field and a method field.
+func SvcMeth(svcMeth string) []Field {
+	dot := strings.LastIndex(svcMeth, ".")
+	if dot < 0 {
+		return []Field{Method(svcMeth)}
+	}
+	return []Field{Service(svcMeth[:dot]), Method(svcMeth[dot+1:])}
+}
+
+type Logger interface {
+	// whether records at level are written at all, so that callers
+	// can skip building fields for them.
+	Enabled(level Level) bool
+	Log(level Level, msg string, fields ...Field)
+	// a logger that adds fields to every record.
+	With(fields ...Field) Logger
+}
+
+// where records go when nothing else was set: text on stderr,
+// info and up.
+var Default Logger = Text(os.Stderr, Info)
+
+// a logger that writes nothing.
+var Discard Logger = discard{}
+
+type discard struct{}
+
+func (discard) Enabled(Level) bool          { return false }
+func (discard) Log(Level, string, ...Field) {}
+func (d discard) With(...Field) Logger      { return d }
+
+// writes one record per call, shared by a logger and the ones
+// made from it with With.
+type sink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	min Level
+}
+
+type textLogger struct {
+	sink   *sink
+	fields []Field
+}
+
+// log records of min and up to w as lines of text:
+//
+//	2006-01-02T15:04:05.000Z07:00 warn register failed service=KV peer=10.0.0.1:2000
+func Text(w io.Writer, min Level) Logger {
+	return &textLogger{sink: &sink{w: w, min: min}}
+}
+
+func (l *textLogger) Enabled(level Level) bool {
+	return level >= l.sink.min
+}
+
+func (l *textLogger) Log(level Level, msg string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	b := &strings.Builder{}
+	b.WriteString(time.Now().Format("2006-01-02T15:04:05.000Z07:00"))
+	b.WriteString(" ")
+	b.WriteString(level.String())
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for _, fs := range [][]Field{l.fields, fields} {
+		for _, f := range fs {
+			b.WriteString(" ")
+			b.WriteString(f.Key)
+			b.WriteString("=")
+			b.WriteString(quote(fmt.Sprint(f.Value)))
+		}
+	}
+	b.WriteString("\n")
+
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+	io.WriteString(l.sink.w, b.String())
+}
+
+func (l *textLogger) With(fields ...Field) Logger {
+	return &textLogger{sink: l.sink, fields: append(append([]Field{}, l.fields...), fields...)}
+}
+
+// values with spaces, quotes or nothing at all are quoted, so that
+// a line splits back into its fields.
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+type jsonLogger struct {
+	sink   *sink
+	fields []Field
+}
+
+// log records of min and up to w as JSON objects, one per line, with
+// "time", "level" and "msg" keys besides the fields.
+func JSON(w io.Writer, min Level) Logger {
+	return &jsonLogger{sink: &sink{w: w, min: min}}
+}
+
+func (l *jsonLogger) Enabled(level Level) bool {
+	return level >= l.sink.min
+}
+
+func (l *jsonLogger) Log(level Level, msg string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	rec := map[string]interface{}{}
+	for _, fs := range [][]Field{l.fields, fields} {
+		for _, f := range fs {
+			rec[f.Key] = jsonValue(f.Value)
+		}
+	}
+	rec["time"] = time.Now().Format(time.RFC3339Nano)
+	rec["level"] = level.String()
+	rec["msg"] = msg
+	line, err := json.Marshal(rec)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{"level": level.String(), "msg": msg, "error": err.Error()})
+	}
+
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+	l.sink.w.Write(append(line, '\n'))
+}
+
+func (l *jsonLogger) With(fields ...Field) Logger {
+	return &jsonLogger{sink: l.sink, fields: append(append([]Field{}, l.fields...), fields...)}
+}
+
+// durations as seconds, errors and other Stringers as text; the
+// rest as encoding/json sees them.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Duration:
+		return v.Seconds()
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+// l, or Default if l is nil.
+func Or(l Logger) Logger {
+	if l == nil {
+		return Default
+	}
+	return l
+}
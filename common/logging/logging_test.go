@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLevels(t *testing.T) {
+	b := &bytes.Buffer{}
+	l := Text(b, Warn)
+	l.Log(Debug, "debug")
+	l.Log(Info, "info")
+	l.Log(Warn, "warn")
+	l.Log(Error, "error")
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], " warn warn") || !strings.Contains(lines[1], " error error") {
+		t.Fatalf("logged %q", b.String())
+	}
+	if l.Enabled(Info) || !l.Enabled(Error) {
+		t.Fatal("Enabled disagrees with the minimum level")
+	}
+
+	for _, name := range []string{"debug", "INFO", "Warn", "error"} {
+		level, err := ParseLevel(name)
+		if err != nil || !strings.EqualFold(level.String(), name) {
+			t.Errorf("ParseLevel(%q) = %v, %v", name, level, err)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Fatal("parsed an unknown level")
+	}
+}
+
+func TestTextFields(t *testing.T) {
+	b := &bytes.Buffer{}
+	l := Text(b, Info).With(Component("registry"))
+	l.Log(Warn, "register failed", Service("KV"), Peer("10.0.0.1:2000"), Err(errors.New("no route")), F("empty", ""))
+	line := strings.TrimSpace(b.String())
+	// the time, then the level and the message.
+	parts := strings.SplitN(line, " ", 2)
+	if _, err := time.Parse("2006-01-02T15:04:05.000Z07:00", parts[0]); err != nil {
+		t.Fatalf("line %q starts with no time: %v", line, err)
+	}
+	want := `warn register failed component=registry service=KV peer=10.0.0.1:2000 error="no route" empty=""`
+	if parts[1] != want {
+		t.Fatalf("logged %q, want %q", parts[1], want)
+	}
+}
+
+func TestJSONFields(t *testing.T) {
+	b := &bytes.Buffer{}
+	l := JSON(b, Debug).With(Service("KV"))
+	l.Log(Info, "served", Method("Get"), Latency(1500*time.Millisecond), Err(errors.New("slow")), Seq(7))
+	rec := map[string]interface{}{}
+	if err := json.Unmarshal(b.Bytes(), &rec); err != nil {
+		t.Fatalf("line %q: %v", b.String(), err)
+	}
+	want := map[string]interface{}{"level": "info", "msg": "served", "service": "KV", "method": "Get",
+		"latency": 1.5, "error": "slow", "seq": 7.0}
+	for k, v := range want {
+		if rec[k] != v {
+			t.Errorf("%v = %v, want %v", k, rec[k], v)
+		}
+	}
+	if _, ok := rec["time"]; !ok {
+		t.Error("no time")
+	}
+}
+
+func TestWithDoesNotChangeTheParent(t *testing.T) {
+	b := &bytes.Buffer{}
+	parent := Text(b, Info)
+	parent.With(F("child", 1))
+	parent.Log(Info, "msg")
+	if strings.Contains(b.String(), "child") {
+		t.Fatalf("parent logged its child's field: %q", b.String())
+	}
+}
+
+func TestOr(t *testing.T) {
+	if Or(nil) != Default {
+		t.Fatal("Or(nil) is not Default")
+	}
+	if Or(Discard) != Discard {
+		t.Fatal("Or replaced a logger")
+	}
+	Discard.With(F("k", "v")).Log(Error, "nothing")
+}
@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// a file that moves aside when it grows past a size: path becomes
+// path.1, path.1 becomes path.2 and so on, keeping at most
+// maxBackups old files.
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64 // 0 for never rotate
+	maxBackups int
+	f          *os.File
+	size       int64
+}
+
+func OpenRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// the caller holds rf.mu, or has rf to itself.
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f = f
+	rf.size = st.Size()
+	return nil
+}
+
+// write p in one piece, rotating first if it would not fit.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.maxBytes > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxBytes {
+		if err := rf.rotateLocked(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// the caller holds rf.mu.
+func (rf *RotatingFile) rotateLocked() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+	rf.f = nil
+	if rf.maxBackups <= 0 {
+		os.Remove(rf.path)
+	} else {
+		os.Remove(rf.backup(rf.maxBackups))
+		for i := rf.maxBackups - 1; i >= 1; i-- {
+			os.Rename(rf.backup(i), rf.backup(i+1))
+		}
+		if err := os.Rename(rf.path, rf.backup(1)); err != nil {
+			return err
+		}
+	}
+	return rf.open()
+}
+
+func (rf *RotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", rf.path, i)
+}
+
+// move the file aside now, as a rotation by size would.
+func (rf *RotatingFile) Rotate() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return os.ErrClosed
+	}
+	return rf.rotateLocked()
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readFile(t *testing.T, path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	rf, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n", "ffff\n", "gggg\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// two lines fit in 10 bytes; the oldest file is gone.
+	want := map[string]string{path: "gggg\n", path + ".1": "eeee\nffff\n", path + ".2": "cccc\ndddd\n"}
+	for p, s := range want {
+		if got := readFile(t, p); got != s {
+			t.Errorf("%v holds %q, want %q", filepath.Base(p), got, s)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("kept more backups than asked")
+	}
+}
+
+func TestRotateKeepsOversizedWritesWhole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	rf, _ := OpenRotatingFile(path, 4, 1)
+	defer rf.Close()
+	long := strings.Repeat("x", 20) + "\n"
+	rf.Write([]byte(long))
+	rf.Write([]byte(long))
+	if readFile(t, path) != long || readFile(t, path+".1") != long {
+		t.Fatal("a write longer than the limit was split or merged")
+	}
+}
+
+func TestRotateWithoutBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	rf, _ := OpenRotatingFile(path, 0, 0)
+	rf.Write([]byte("old\n"))
+	if err := rf.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	rf.Write([]byte("new\n"))
+	rf.Close()
+	if got := readFile(t, path); got != "new\n" {
+		t.Fatalf("file holds %q", got)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Fatal("backup kept with no backups asked for")
+	}
+	if _, err := rf.Write([]byte("late\n")); err == nil {
+		t.Fatal("wrote after Close")
+	}
+}
+
+func TestReopenAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	rf, _ := OpenRotatingFile(path, 10, 1)
+	rf.Write([]byte("aaaa\n"))
+	rf.Close()
+	rf, _ = OpenRotatingFile(path, 10, 1)
+	defer rf.Close()
+	rf.Write([]byte("bbbb\n"))
+	rf.Write([]byte("cccc\n"))
+	if readFile(t, path+".1") != "aaaa\nbbbb\n" {
+		t.Fatal("reopened file did not count what it held")
+	}
+}
@@ -0,0 +1,59 @@
+//go:build go1.21
+// +build go1.21
+
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// a Logger that hands records to l, with levels mapped onto
+// slog's and fields as attributes.
+func FromSlog(l *slog.Logger) Logger {
+	return &slogLogger{l: l}
+}
+
+func slogLevel(level Level) slog.Level {
+	switch level {
+	case Debug:
+		return slog.LevelDebug
+	case Info:
+		return slog.LevelInfo
+	case Warn:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
+
+func slogAttrs(fields []Field) []slog.Attr {
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, f := range fields {
+		if err, ok := f.Value.(error); ok {
+			attrs = append(attrs, slog.String(f.Key, err.Error()))
+		} else {
+			attrs = append(attrs, slog.Any(f.Key, f.Value))
+		}
+	}
+	return attrs
+}
+
+func (s *slogLogger) Enabled(level Level) bool {
+	return s.l.Enabled(context.Background(), slogLevel(level))
+}
+
+func (s *slogLogger) Log(level Level, msg string, fields ...Field) {
+	s.l.LogAttrs(context.Background(), slogLevel(level), msg, slogAttrs(fields)...)
+}
+
+func (s *slogLogger) With(fields ...Field) Logger {
+	args := []interface{}{}
+	for _, a := range slogAttrs(fields) {
+		args = append(args, a)
+	}
+	return &slogLogger{l: s.l.With(args...)}
+}
@@ -0,0 +1,28 @@
+//go:build go1.21
+// +build go1.21
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+)
+
+func TestFromSlog(t *testing.T) {
+	b := &bytes.Buffer{}
+	l := FromSlog(slog.New(slog.NewJSONHandler(b, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	if l.Enabled(Info) || !l.Enabled(Warn) {
+		t.Fatal("Enabled disagrees with the handler's level")
+	}
+	l.With(Service("KV")).Log(Error, "failed", Err(errors.New("no route")))
+	rec := map[string]interface{}{}
+	if err := json.Unmarshal(b.Bytes(), &rec); err != nil {
+		t.Fatalf("line %q: %v", b.String(), err)
+	}
+	if rec["level"] != "ERROR" || rec["msg"] != "failed" || rec["service"] != "KV" || rec["error"] != "no route" {
+		t.Fatalf("logged %v", rec)
+	}
+}
@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+// why a call failed, carried in ReplyMsg.Code. the zero Code is OK.
+type Code int
+
+const (
+	OK                Code = iota
+	Canceled               // the caller gave up, or its end was closed
+	Unknown                // failed for a reason we cannot tell
+	NotFound               // no such service or method, or no endpoint offers it
+	DeadlineExceeded       // no reply in time
+	Unavailable            // the endpoint could not be reached; safe to try elsewhere
+	Internal               // the server broke while handling the call
+	Unauthenticated        // the caller did not say who it is, or could not prove it
+	PermissionDenied       // the caller may not call the method
+	ResourceExhausted      // the server is at a limit; try later or elsewhere
+)
+
+var codeNames = map[Code]string{
+	OK:                "ok",
+	Canceled:          "canceled",
+	Unknown:           "unknown",
+	NotFound:          "not_found",
+	DeadlineExceeded:  "deadline_exceeded",
+	Unavailable:       "unavailable",
+	Internal:          "internal",
+	Unauthenticated:   "unauthenticated",
+	PermissionDenied:  "permission_denied",
+	ResourceExhausted: "resource_exhausted",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("code(%d)", int(c))
+}
+
+// the Code named name, as written by String.
+func ParseCode(name string) (Code, error) {
+	for c, n := range codeNames {
+		if n == name {
+			return c, nil
+		}
+	}
+	return Unknown, fmt.Errorf("srpc: unknown code %q", name)
+}
+
+// an error with the Code it maps to.
+type Error struct {
+	Code Code
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+func NewError(code Code, msg string) *Error {
+	return &Error{Code: code, Msg: msg}
+}
+
+func Errorf(code Code, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Msg: fmt.Sprintf(format, a...)}
+}
+
+// the Code of err: OK for nil, Unknown for errors without one.
+func CodeOf(err error) Code {
+	if err == nil {
+		return OK
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return Unknown
+}
@@ -0,0 +1,97 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+)
+
+// on the wire, requests and replies are frames: a 4-byte big-endian
+// length, the 8-byte big-endian Seq, then that many bytes of gob.
+// every frame is encoded on its own, so a reader can pick single
+// frames out of a stream, and answer one, by its Seq, without
+// reading it.
+//
+// a connection carries many calls at once; a reply has the Seq of
+// its request.
+type Frame struct {
+	Seq     uint64
+	SvcMeth string            // requests only
+	Endname string            // requests only, the client end's name if it has one
+	Meta    map[string]string // requests only
+	Body    []byte            // gob of the args, or of the reply
+	Ok      bool              // replies only
+	Code    Code              // replies only
+}
+
+const MaxFrameSize = 64 << 20
+
+const frameHeader = 12
+
+// returned by ReadFrameLimit for a frame it skipped.
+type FrameTooLargeError struct {
+	Seq  uint64
+	Size int
+	Max  int
+}
+
+func (e *FrameTooLargeError) Error() string {
+	return fmt.Sprintf("srpc: frame of %d bytes, over %d", e.Size, e.Max)
+}
+
+func WriteFrame(w io.Writer, f *Frame) error {
+	var b bytes.Buffer
+	b.Write(make([]byte, frameHeader))
+	if err := gob.NewEncoder(&b).Encode(f); err != nil {
+		return err
+	}
+	data := b.Bytes()
+	binary.BigEndian.PutUint32(data, uint32(len(data)-frameHeader))
+	binary.BigEndian.PutUint64(data[4:], f.Seq)
+	_, err := w.Write(data)
+	return err
+}
+
+func ReadFrame(r io.Reader) (*Frame, error) {
+	return ReadFrameLimit(r, 0)
+}
+
+// like ReadFrame, but a frame of more than max bytes is skipped
+// rather than read, and a *FrameTooLargeError with its Seq returned,
+// so that the caller can fail just that call. 0 for no limit but
+// MaxFrameSize, past which the stream is given up on.
+func ReadFrameLimit(r io.Reader, max int) (*Frame, error) {
+	var hdr [frameHeader]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	seq := binary.BigEndian.Uint64(hdr[4:])
+	if n > MaxFrameSize {
+		return nil, fmt.Errorf("srpc: frame of %d bytes is too large", n)
+	}
+	if max > 0 && int(n) > max {
+		if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return nil, &FrameTooLargeError{Seq: seq, Size: int(n), Max: max}
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	f := &Frame{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(f); err != nil {
+		return nil, err
+	}
+	f.Seq = seq
+	return f, nil
+}
@@ -2,9 +2,9 @@ package gobrpc
 
 import (
 	"io"
-	"log"
 	"bufio"
 	"encoding/gob"
+	"srpc/common/logging"
 	"srpc/common/protocol"
 )
 
@@ -14,6 +14,11 @@ type GobServerCodec struct {
 	enc    *gob.Encoder
 	encBuf *bufio.Writer
 	closed bool
+	logger logging.Logger // nil for logging.Default
+}
+
+func (c *GobServerCodec) SetLogger(l logging.Logger) {
+	c.logger = l
 }
 
 func (c *GobServerCodec) ReadRequestHeader(r *protocol.ReqMsg) error {
@@ -27,14 +32,14 @@ func (c *GobServerCodec) ReadRequestBody(body interface{}) error {
 func (c *GobServerCodec) WriteResponse(r *protocol.ReplyMsg, body interface{}) (err error) {
 	if err = c.enc.Encode(r); err != nil {
 		if c.encBuf.Flush() == nil {
-			log.Fatalf("rpc: gob error encoding response: %v", err)
+			logging.Or(c.logger).Log(logging.Error, "gobrpc: encode response", logging.Err(err))
 			c.Close()
 		}
 		return
 	}
 	if err = c.enc.Encode(body); err != nil {
 		if c.encBuf.Flush() == nil {
-			log.Fatalf("rpc: gob error encoding body: %v", err)
+			logging.Or(c.logger).Log(logging.Error, "gobrpc: encode body", logging.Err(err))
 			c.Close()
 		}
 		return
@@ -1,20 +1,55 @@
 package protocol
 
 import (
+	"crypto/tls"
 	"reflect"
+	"time"
 )
 
+// request metadata saying how much a call matters to a server that
+// must shed load: an integer, higher goes first. calls without it
+// have priority 0.
+const PriorityKey = "srpc-priority"
+
 type ReqMsg struct {
-	Endname  interface{}
-	SvcMeth  string
-	ArgsType reflect.Type
-	Args     []byte
-	ReplyCh  chan ReplyMsg
+	Endname   interface{}
+	SvcMeth   string
+	ArgsType  reflect.Type // nil when the request came off the wire
+	Args      []byte
+	Meta      map[string]string    // travels with the request, e.g. trace context
+	Peer      string               // where the request came from, as the server's transport saw it
+	TLS       *tls.ConnectionState // of the connection the request came on, if it was TLS
+	Principal string               // who the server's Authenticator found the caller to be
+	Received  time.Time            // when the server's transport read the request; zero if it did not say
+	ReplyCh   chan ReplyMsg
 }
 
 type ReplyMsg struct {
 	Ok    bool
 	Reply []byte
+	Code  Code // why the call failed when !Ok
+}
+
+// a Transport carries a request to the server at addr, and its reply
+// back on req.ReplyCh. Send must not block for long, and every request
+// must get exactly one reply, with Ok false if it was lost on the way.
+// req.ReplyCh is buffered, so a reply nobody waits for any more does
+// not block the transport either.
+type Transport interface {
+	Send(addr string, req ReqMsg)
+}
+
+// a Transport that keeps state for each request until its reply
+// comes may also let the caller give up on one, so that state for
+// replies that never come does not pile up. Forget after the reply
+// came, or for a request it never saw, does nothing.
+type Forgetter interface {
+	Forget(addr string, req ReqMsg)
+}
+
+// what a Transport hands requests to on the server side.
+type Handler interface {
+	Dispatch(req ReqMsg) ReplyMsg
 }
 
 type ClientCodec interface {
@@ -29,4 +64,4 @@ type ServerCodec interface {
 	ReadRequestBody(interface{}) error
 	WriteResponse(*ReplyMsg, interface{}) error
 	Close() error
-}
\ No newline at end of file
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"crypto/tls"
+	"reflect"
+	"srpc/common/protocol"
+	"srpc/common/tlsconf"
+)
+
+// what a handler can learn about the call it serves. a handler
+// that wants it takes a *Context before its args:
+//
+//	func (kv *KV) Get(ctx *service.Context, args *GetArgs, reply *GetReply)
+type Context struct {
+	SvcMeth   string
+	Peer      string               // the caller's address, when the transport knows it
+	Endname   interface{}          // the caller's end name, if it sent one
+	Meta      map[string]string    // request metadata, e.g. trace context
+	TLS       *tls.ConnectionState // nil unless the call came over TLS
+	Principal string               // who the server's authenticator found the caller to be; "" if nobody
+}
+
+var contextType = reflect.TypeOf(&Context{})
+
+func makeContext(req protocol.ReqMsg) *Context {
+	return &Context{
+		SvcMeth:   req.SvcMeth,
+		Peer:      req.Peer,
+		Endname:   req.Endname,
+		Meta:      req.Meta,
+		TLS:       req.TLS,
+		Principal: req.Principal,
+	}
+}
+
+// the name in the certificate the caller presented over mutual
+// TLS, or "" if it presented none.
+func (ctx *Context) PeerName() string {
+	return tlsconf.PeerName(ctx.TLS)
+}
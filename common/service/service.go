@@ -1,11 +1,11 @@
 package service
 
 import (
-	"log"
 	"bytes"
 	"reflect"
 	"srpc/common/sgob"
 	"srpc/common/protocol"
+	"srpc/common/logging"
 )
 
 // an object with methods that can be called via RPC.
@@ -15,6 +15,8 @@ type Service struct {
 	Rcvr    reflect.Value
 	Typ     reflect.Type
 	Methods map[string]reflect.Method
+	logger  logging.Logger  // nil for logging.Default
+	context map[string]bool // methods that take a *Context first
 }
 
 func MakeService(rcvr interface{}) *Service {
@@ -23,6 +25,7 @@ func MakeService(rcvr interface{}) *Service {
 	svc.Rcvr = reflect.ValueOf(rcvr)
 	svc.Name = reflect.Indirect(svc.Rcvr).Type().Name()
 	svc.Methods = map[string]reflect.Method{}
+	svc.context = map[string]bool{}
 
 	for m := 0; m < svc.Typ.NumMethod(); m++ {
 		method := svc.Typ.Method(m)
@@ -32,27 +35,50 @@ func MakeService(rcvr interface{}) *Service {
 		//fmt.Printf("%v pp %v ni %v 1k %v 2k %v no %v\n",
 		//	mname, method.PkgPath, mtype.NumIn(), mtype.In(1).Kind(), mtype.In(2).Kind(), mtype.NumOut())
 
+		// a handler may take a *Context before its args.
+		skip := 0
+		if mtype.NumIn() == 4 && mtype.In(1) == contextType {
+			skip = 1
+		}
+
 		if method.PkgPath != "" || // capitalized?
-			mtype.NumIn() != 3 ||
+			mtype.NumIn() != 3+skip ||
 			//mtype.In(1).Kind() != reflect.Ptr ||
-			mtype.In(2).Kind() != reflect.Ptr ||
+			mtype.In(2+skip).Kind() != reflect.Ptr ||
 			mtype.NumOut() != 0 {
 			// the method is not suitable for a handler
 			//fmt.Printf("bad method: %v\n", mname)
 		} else {
 			// the method looks like a handler
 			svc.Methods[mname] = method
+			svc.context[mname] = skip == 1
 		}
 	}
 
 	return svc
 }
 
-func (svc *Service) Dispatch(methname string, req protocol.ReqMsg) protocol.ReplyMsg {
+// where Dispatch reports panics and unknown methods.
+func (svc *Service) SetLogger(l logging.Logger) {
+	svc.logger = l
+}
+
+func (svc *Service) Dispatch(methname string, req protocol.ReqMsg) (reply protocol.ReplyMsg) {
 	if method, ok := svc.Methods[methname]; ok {
+		skip := 0
+		if svc.context[methname] {
+			skip = 1
+		}
+
 		// prepare space into which to read the argument.
-		// the Value's type will be a pointer to req.argsType.
-		args := reflect.New(req.ArgsType)
+		// the Value's type will be a pointer to req.argsType,
+		// or to what the method takes when the request came
+		// off the wire without one.
+		argsType := req.ArgsType
+		if argsType == nil {
+			argsType = method.Type.In(1 + skip)
+		}
+		args := reflect.New(argsType)
 
 		// decode the argument.
 		ab := bytes.NewBuffer(req.Args)
@@ -60,13 +86,25 @@ func (svc *Service) Dispatch(methname string, req protocol.ReqMsg) protocol.Repl
 		ad.Decode(args.Interface())
 
 		// allocate space for the reply.
-		replyType := method.Type.In(2)
+		replyType := method.Type.In(2 + skip)
 		replyType = replyType.Elem()
 		replyv := reflect.New(replyType)
 
-		// call the method.
+		// call the method, turning a panic into a failed call
+		// rather than a dead server.
+		defer func() {
+			if r := recover(); r != nil {
+				logging.Or(svc.logger).Log(logging.Error, "srpc service: handler panicked",
+					append(logging.SvcMeth(req.SvcMeth), logging.F("panic", r))...)
+				reply = protocol.ReplyMsg{Ok: false, Code: protocol.Internal}
+			}
+		}()
 		function := method.Func
-		function.Call([]reflect.Value{svc.Rcvr, args.Elem(), replyv})
+		if skip == 1 {
+			function.Call([]reflect.Value{svc.Rcvr, reflect.ValueOf(makeContext(req)), args.Elem(), replyv})
+		} else {
+			function.Call([]reflect.Value{svc.Rcvr, args.Elem(), replyv})
+		}
 
 		// encode the reply.
 		rb := new(bytes.Buffer)
@@ -79,8 +117,8 @@ func (svc *Service) Dispatch(methname string, req protocol.ReqMsg) protocol.Repl
 		for k := range svc.Methods {
 			choices = append(choices, k)
 		}
-		log.Fatalf("labrpc.Service.dispatch(): unknown method %v in %v; expecting one of %v\n",
-			methname, req.SvcMeth, choices)
-		return protocol.ReplyMsg{Ok: false, Reply: nil}
+		logging.Or(svc.logger).Log(logging.Warn, "srpc service: unknown method",
+			logging.Service(svc.Name), logging.Method(methname), logging.F("choices", choices))
+		return protocol.ReplyMsg{Ok: false, Reply: nil, Code: protocol.NotFound}
 	}
-}
\ No newline at end of file
+}
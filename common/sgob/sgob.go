@@ -3,15 +3,32 @@ package sgob
 import "encoding/gob"
 import "io"
 import "reflect"
-import "fmt"
 import "sync"
 import "unicode"
 import "unicode/utf8"
+import "srpc/common/logging"
 
 var mu sync.Mutex
 var errorCount int // for TestCapital
 var checked map[reflect.Type]bool
 
+var loggerMu sync.Mutex
+var logger logging.Logger
+
+// where the codec reports fields it cannot carry; nil for
+// logging.Default.
+func SetLogger(l logging.Logger) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	logger = l
+}
+
+func getLogger() logging.Logger {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	return logging.Or(logger)
+}
+
 type LabEncoder struct {
 	gob *gob.Encoder
 }
@@ -84,8 +101,8 @@ func checkType(t reflect.Type) {
 			rune, _ := utf8.DecodeRuneInString(f.Name)
 			if unicode.IsUpper(rune) == false {
 				// ta da
-				fmt.Printf("labgob error: lower-case field %v of %v in RPC or persist/snapshot will break your Raft\n",
-					f.Name, t.Name())
+				getLogger().Log(logging.Error, "sgob: lower-case field will not be encoded",
+					logging.F("field", f.Name), logging.F("type", t.Name()))
 				mu.Lock()
 				errorCount += 1
 				mu.Unlock()
@@ -159,8 +176,8 @@ func checkDefault1(value reflect.Value, depth int, name string) {
 				// this warning typically arises if code re-uses the same RPC reply
 				// variable for multiple RPC calls, or if code restores persisted
 				// state into variable that already have non-default values.
-				fmt.Printf("labgob warning: Decoding into a non-default variable/field %v may not work\n",
-					what)
+				getLogger().Log(logging.Warn, "sgob: decoding into a non-default value may not work",
+					logging.F("field", what))
 			}
 			errorCount += 1
 			mu.Unlock()
@@ -0,0 +1,130 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// writes metrics in the Prometheus text exposition format, version
+// 0.0.4, which is all a scraper needs.
+type PromWriter struct {
+	w   io.Writer
+	err error
+}
+
+const PromContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+func MakePromWriter(w io.Writer) *PromWriter {
+	return &PromWriter{w: w}
+}
+
+// the first error met while writing.
+func (p *PromWriter) Err() error {
+	return p.err
+}
+
+func (p *PromWriter) printf(format string, a ...interface{}) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, a...)
+	}
+}
+
+// introduce a metric; typ is "counter", "gauge" or "histogram".
+func (p *PromWriter) Header(name, typ, help string) {
+	p.printf("# HELP %s %s\n", name, strings.NewReplacer("\\", `\\`, "\n", `\n`).Replace(help))
+	p.printf("# TYPE %s %s\n", name, typ)
+}
+
+// write one sample; labels are name, value pairs.
+func (p *PromWriter) Sample(name string, value float64, labels ...string) {
+	p.printf("%s%s %s\n", name, formatLabels(labels), formatValue(value))
+}
+
+// write the samples of a histogram whose Header was written.
+func (p *PromWriter) Histogram(name string, h *Histogram, labels ...string) {
+	cum := int64(0)
+	for i, n := range h.Counts {
+		cum += n
+		le := "+Inf"
+		if i < len(h.Bounds) {
+			le = formatValue(h.Bounds[i].Seconds())
+		}
+		p.Sample(name+"_bucket", float64(cum), append(append([]string{}, labels...), "le", le)...)
+	}
+	p.Sample(name+"_sum", h.Sum.Seconds(), labels...)
+	p.Sample(name+"_count", float64(h.Count), labels...)
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	escape := strings.NewReplacer("\\", `\\`, "\n", `\n`, `"`, `\"`)
+	parts := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, labels[i]+`="`+escape.Replace(labels[i+1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// write the per-method counters of s, with names starting with
+// prefix, e.g. "srpc_server".
+func (s *Snapshot) WritePrometheus(p *PromWriter, prefix string) {
+	names := []string{}
+	for svcMeth := range s.Methods {
+		names = append(names, svcMeth)
+	}
+	sort.Strings(names)
+	labels := func(svcMeth string) []string {
+		dot := strings.LastIndex(svcMeth, ".")
+		if dot < 0 {
+			return []string{"service", "", "method", svcMeth}
+		}
+		return []string{"service", svcMeth[:dot], "method", svcMeth[dot+1:]}
+	}
+
+	p.Header(prefix+"_requests_total", "counter", "Calls, by method.")
+	for _, name := range names {
+		p.Sample(prefix+"_requests_total", float64(s.Methods[name].Requests), labels(name)...)
+	}
+	p.Header(prefix+"_errors_total", "counter", "Failed calls, by method and code.")
+	for _, name := range names {
+		codes := []string{}
+		for code := range s.Methods[name].Errors {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			p.Sample(prefix+"_errors_total", float64(s.Methods[name].Errors[code]),
+				append(labels(name), "code", code)...)
+		}
+	}
+	p.Header(prefix+"_received_bytes_total", "counter", "Bytes of args or replies received, by method.")
+	for _, name := range names {
+		p.Sample(prefix+"_received_bytes_total", float64(s.Methods[name].Bytes_in), labels(name)...)
+	}
+	p.Header(prefix+"_sent_bytes_total", "counter", "Bytes of args or replies sent, by method.")
+	for _, name := range names {
+		p.Sample(prefix+"_sent_bytes_total", float64(s.Methods[name].Bytes_out), labels(name)...)
+	}
+	p.Header(prefix+"_latency_seconds", "histogram", "Call latency, by method.")
+	for _, name := range names {
+		p.Histogram(prefix+"_latency_seconds", &s.Methods[name].Latency, labels(name)...)
+	}
+}
@@ -0,0 +1,129 @@
+package stats
+
+import (
+	"srpc/common/protocol"
+	"sync"
+	"time"
+)
+
+// per-method counters kept by clients and servers.
+
+// upper bounds of the latency histogram buckets; a last bucket
+// takes everything slower.
+var LatencyBounds = []time.Duration{
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+type Histogram struct {
+	Bounds []time.Duration // shared, do not modify
+	Counts []int64         // Counts[i] calls took at most Bounds[i]; the last, longer
+	Count  int64
+	Sum    time.Duration
+}
+
+type Method struct {
+	Requests  int64
+	Errors    map[string]int64 // code name -> failed calls
+	Bytes_in  int64
+	Bytes_out int64
+	Latency   Histogram
+}
+
+type Snapshot struct {
+	Since   time.Time          // when counting started, or was last reset
+	Methods map[string]*Method // "Service.Method" -> counters
+}
+
+type Recorder struct {
+	mu      sync.Mutex
+	since   time.Time
+	methods map[string]*Method
+}
+
+func MakeRecorder() *Recorder {
+	r := &Recorder{}
+	r.Reset()
+	return r
+}
+
+// count one call of svcMeth that ended with code.
+func (r *Recorder) Record(svcMeth string, code protocol.Code, bytesIn int, bytesOut int, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.methods[svcMeth]
+	if !ok {
+		m = &Method{Errors: map[string]int64{}}
+		m.Latency = MakeHistogram()
+		r.methods[svcMeth] = m
+	}
+	m.Requests += 1
+	if code != protocol.OK {
+		m.Errors[code.String()] += 1
+	}
+	m.Bytes_in += int64(bytesIn)
+	m.Bytes_out += int64(bytesOut)
+	m.Latency.Add(latency)
+}
+
+// an empty histogram over LatencyBounds.
+func MakeHistogram() Histogram {
+	return Histogram{Bounds: LatencyBounds, Counts: make([]int64, len(LatencyBounds)+1)}
+}
+
+// count one observation of d. the caller serializes calls.
+func (h *Histogram) Add(d time.Duration) {
+	i := 0
+	for i < len(h.Bounds) && d > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i] += 1
+	h.Count += 1
+	h.Sum += d
+}
+
+// a copy of h, safe to keep.
+func (h *Histogram) Copy() Histogram {
+	c := *h
+	c.Counts = append([]int64{}, h.Counts...)
+	return c
+}
+
+// a copy of the counters, safe to keep.
+func (r *Recorder) Snapshot() Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := Snapshot{Since: r.since, Methods: map[string]*Method{}}
+	for svcMeth, m := range r.methods {
+		c := *m
+		c.Errors = map[string]int64{}
+		for code, n := range m.Errors {
+			c.Errors[code] = n
+		}
+		c.Latency = m.Latency.Copy()
+		s.Methods[svcMeth] = &c
+	}
+	return s
+}
+
+// start counting from zero.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.since = time.Now()
+	r.methods = map[string]*Method{}
+}
@@ -0,0 +1,80 @@
+package stats
+
+import (
+	"srpc/common/protocol"
+	"testing"
+	"time"
+)
+
+func TestRecorderCountsPerMethod(t *testing.T) {
+	r := MakeRecorder()
+	r.Record("KV.Get", protocol.OK, 10, 20, time.Millisecond)
+	r.Record("KV.Get", protocol.NotFound, 10, 0, 3*time.Millisecond)
+	r.Record("KV.Put", protocol.OK, 30, 5, time.Second)
+
+	s := r.Snapshot()
+	get := s.Methods["KV.Get"]
+	if get == nil || get.Requests != 2 || get.Bytes_in != 20 || get.Bytes_out != 20 {
+		t.Fatalf("KV.Get counted as %+v", get)
+	}
+	if len(get.Errors) != 1 || get.Errors[protocol.NotFound.String()] != 1 {
+		t.Fatalf("KV.Get errors %v", get.Errors)
+	}
+	if get.Latency.Count != 2 || get.Latency.Sum != 4*time.Millisecond {
+		t.Fatalf("KV.Get latency %+v", get.Latency)
+	}
+	if put := s.Methods["KV.Put"]; put == nil || put.Requests != 1 || len(put.Errors) != 0 {
+		t.Fatalf("KV.Put counted as %+v", put)
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	h := MakeHistogram()
+	h.Add(0)
+	h.Add(time.Millisecond)      // at a bound: that bucket
+	h.Add(time.Millisecond + 1)  // just over: the next
+	h.Add(30 * time.Millisecond) // the 50ms bucket
+	h.Add(time.Minute)           // the last, over every bound
+	want := map[int]int64{0: 2, 1: 1, 5: 1, len(LatencyBounds): 1}
+	for i, n := range h.Counts {
+		if n != want[i] {
+			t.Fatalf("bucket %d counted %d, want %d: %v", i, n, want[i], h.Counts)
+		}
+	}
+	if h.Count != 5 {
+		t.Fatalf("count %d", h.Count)
+	}
+}
+
+func TestSnapshotIsACopy(t *testing.T) {
+	r := MakeRecorder()
+	r.Record("KV.Get", protocol.Unavailable, 1, 1, time.Millisecond)
+	s := r.Snapshot()
+	r.Record("KV.Get", protocol.Unavailable, 1, 1, time.Millisecond)
+
+	m := s.Methods["KV.Get"]
+	if m.Requests != 1 || m.Errors[protocol.Unavailable.String()] != 1 || m.Latency.Count != 1 ||
+		m.Latency.Counts[0] != 1 {
+		t.Fatalf("snapshot changed by a later call: %+v", m)
+	}
+	m.Latency.Counts[0] = 100
+	m.Errors["x"] = 1
+	if m := r.Snapshot().Methods["KV.Get"]; m.Latency.Counts[0] != 2 || m.Errors["x"] != 0 {
+		t.Fatalf("recorder changed through a snapshot: %+v", m)
+	}
+}
+
+func TestReset(t *testing.T) {
+	r := MakeRecorder()
+	before := r.Snapshot().Since
+	r.Record("KV.Get", protocol.OK, 1, 1, time.Millisecond)
+	time.Sleep(time.Millisecond)
+	r.Reset()
+	s := r.Snapshot()
+	if len(s.Methods) != 0 {
+		t.Fatalf("methods kept after Reset: %v", s.Methods)
+	}
+	if !s.Since.After(before) {
+		t.Fatal("Since not moved by Reset")
+	}
+}
@@ -0,0 +1,197 @@
+package tlsconf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"srpc/common/logging"
+	"sync"
+	"time"
+)
+
+// TLS for client and server transports, from files on disk that
+// may be replaced while the process runs: a Loader checks them at
+// most every reloadInterval and picks up new certificates for the
+// handshakes after that.
+
+const reloadInterval = 1 * time.Second
+
+type Config struct {
+	Cert_file            string `json:"cert_file"` // PEM certificate chain; a client without one does no mutual TLS
+	Key_file             string `json:"key_file"`
+	Ca_file              string `json:"ca_file"`              // PEM CAs to verify the peer with; "" for the system roots
+	Server_name          string `json:"server_name"`          // the name a client expects the server to have; "" for the host dialed
+	Min_version          string `json:"min_version"`          // "1.0" to "1.3"; "" for 1.2
+	Client_auth          bool   `json:"client_auth"`          // servers only: require a certificate Ca_file vouches for
+	Insecure_skip_verify bool   `json:"insecure_skip_verify"` // clients only: do not verify the server at all, for tests
+}
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+type Loader struct {
+	mu      sync.Mutex
+	config  Config
+	min     uint16
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	mtimes  [3]time.Time // of Cert_file, Key_file and Ca_file when last loaded
+	checked time.Time
+}
+
+// load the files conf names, failing if any of them will not do.
+func MakeLoader(conf *Config) (*Loader, error) {
+	l := &Loader{config: *conf, min: tls.VersionTLS12}
+	if conf.Min_version != "" {
+		v, ok := versions[conf.Min_version]
+		if !ok {
+			return nil, fmt.Errorf("tlsconf: unknown min_version %q", conf.Min_version)
+		}
+		l.min = v
+	}
+	if (conf.Cert_file == "") != (conf.Key_file == "") {
+		return nil, fmt.Errorf("tlsconf: cert_file and key_file go together")
+	}
+	if conf.Client_auth && conf.Ca_file == "" {
+		return nil, fmt.Errorf("tlsconf: client_auth needs a ca_file")
+	}
+	mtimes, err := l.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := l.load(mtimes); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Loader) stat() ([3]time.Time, error) {
+	mtimes := [3]time.Time{}
+	for i, name := range []string{l.config.Cert_file, l.config.Key_file, l.config.Ca_file} {
+		if name == "" {
+			continue
+		}
+		st, err := os.Stat(name)
+		if err != nil {
+			return mtimes, fmt.Errorf("tlsconf: %v", err)
+		}
+		mtimes[i] = st.ModTime()
+	}
+	return mtimes, nil
+}
+
+// the caller holds l.mu, or has l to itself.
+func (l *Loader) load(mtimes [3]time.Time) error {
+	var cert *tls.Certificate
+	if l.config.Cert_file != "" {
+		c, err := tls.LoadX509KeyPair(l.config.Cert_file, l.config.Key_file)
+		if err != nil {
+			return fmt.Errorf("tlsconf: %v", err)
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if l.config.Ca_file != "" {
+		pem, err := os.ReadFile(l.config.Ca_file)
+		if err != nil {
+			return fmt.Errorf("tlsconf: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tlsconf: no certificates in %v", l.config.Ca_file)
+		}
+	}
+	l.cert, l.pool, l.mtimes = cert, pool, mtimes
+	return nil
+}
+
+// load the files again if they changed since last time. files that
+// are half written or broken are reported, and the old ones kept.
+func (l *Loader) reload() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Since(l.checked) < reloadInterval {
+		return
+	}
+	l.checked = time.Now()
+	mtimes, err := l.stat()
+	if err == nil && mtimes == l.mtimes {
+		return
+	}
+	if err == nil {
+		err = l.load(mtimes)
+	}
+	if err != nil {
+		logging.Default.Log(logging.Warn, "tlsconf: reload, keeping the old certificates", logging.Err(err))
+		return
+	}
+	logging.Default.Log(logging.Info, "tlsconf: reloaded certificates", logging.F("cert_file", l.config.Cert_file))
+}
+
+// a tls.Config for a listener. every handshake gets the
+// certificates on disk at the time.
+func (l *Loader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			l.reload()
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			c := &tls.Config{MinVersion: l.min}
+			if l.cert != nil {
+				c.Certificates = []tls.Certificate{*l.cert}
+			}
+			if l.config.Client_auth {
+				c.ClientAuth = tls.RequireAndVerifyClientCert
+				c.ClientCAs = l.pool
+			} else if l.pool != nil {
+				c.ClientAuth = tls.VerifyClientCertIfGiven
+				c.ClientCAs = l.pool
+			}
+			return c, nil
+		},
+	}
+}
+
+// a tls.Config for dialing host, with the certificates on disk now.
+func (l *Loader) ClientConfig(host string) *tls.Config {
+	l.reload()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := &tls.Config{
+		MinVersion:         l.min,
+		RootCAs:            l.pool,
+		ServerName:         host,
+		InsecureSkipVerify: l.config.Insecure_skip_verify,
+	}
+	if l.config.Server_name != "" {
+		c.ServerName = l.config.Server_name
+	}
+	if l.cert != nil {
+		c.Certificates = []tls.Certificate{*l.cert}
+	}
+	return c
+}
+
+// who the peer of a connection says it is, from the certificate it
+// presented: the common name, or the first DNS or URI name when
+// there is no common name. "" when it presented none.
+func PeerName(cs *tls.ConnectionState) string {
+	if cs == nil || len(cs.PeerCertificates) == 0 {
+		return ""
+	}
+	cert := cs.PeerCertificates[0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return ""
+}
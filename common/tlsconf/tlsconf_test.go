@@ -0,0 +1,249 @@
+package tlsconf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// a CA that issues certificates for the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+var serial int64
+
+func makeTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir()}
+	writePEM(t, ca.path("ca.pem"), "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) path(name string) string {
+	return filepath.Join(ca.dir, name)
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// issue a certificate for cn, valid for 127.0.0.1 too, into
+// name.pem and name.key.
+func (ca *testCA) issue(t *testing.T, name, cn string) (certFile, keyFile string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial + 1),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, _ := x509.MarshalECPrivateKey(key)
+	certFile, keyFile = ca.path(name+".pem"), ca.path(name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", kder)
+	return certFile, keyFile
+}
+
+func mustLoader(t *testing.T, conf *Config) *Loader {
+	l, err := MakeLoader(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// both ends of a loopback TCP connection, which unlike net.Pipe
+// lets a side write an alert its peer never reads.
+func connPair(t *testing.T) (server net.Conn, client net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+// shake hands; the names each side saw of the other.
+func handshake(t *testing.T, server, client *Loader) (serverSaw, clientSaw string, err error) {
+	sc, cc := connPair(t)
+	defer sc.Close()
+	defer cc.Close()
+	s := tls.Server(sc, server.ServerConfig())
+	c := tls.Client(cc, client.ClientConfig("server"))
+	errs := make(chan error, 1)
+	go func() { errs <- s.Handshake() }()
+	err = c.Handshake()
+	if serr := <-errs; err == nil {
+		err = serr
+	}
+	if err != nil {
+		return "", "", err
+	}
+	ss, cs := s.ConnectionState(), c.ConnectionState()
+	return PeerName(&ss), PeerName(&cs), nil
+}
+
+func TestMakeLoaderRejects(t *testing.T) {
+	ca := makeTestCA(t)
+	cert, key := ca.issue(t, "server", "server")
+	for _, conf := range []*Config{
+		{Cert_file: cert, Key_file: key, Min_version: "0.9"},
+		{Cert_file: cert},
+		{Cert_file: cert, Key_file: key, Client_auth: true},
+		{Cert_file: ca.path("nonesuch.pem"), Key_file: key},
+		{Cert_file: cert, Key_file: ca.path("ca.pem")},
+		{Ca_file: key},
+	} {
+		if _, err := MakeLoader(conf); err == nil {
+			t.Errorf("loaded %+v", conf)
+		}
+	}
+}
+
+func TestTLS(t *testing.T) {
+	ca := makeTestCA(t)
+	cert, key := ca.issue(t, "server", "server")
+	server := mustLoader(t, &Config{Cert_file: cert, Key_file: key})
+
+	_, saw, err := handshake(t, server, mustLoader(t, &Config{Ca_file: ca.path("ca.pem")}))
+	if err != nil || saw != "server" {
+		t.Fatalf("client saw %q, %v", saw, err)
+	}
+
+	// a client that does not trust the CA, or expects another name.
+	other := makeTestCA(t)
+	if _, _, err := handshake(t, server, mustLoader(t, &Config{Ca_file: other.path("ca.pem")})); err == nil {
+		t.Fatal("client trusted a server its CAs did not vouch for")
+	}
+	if _, _, err := handshake(t, server, mustLoader(t, &Config{Ca_file: ca.path("ca.pem"), Server_name: "other"})); err == nil {
+		t.Fatal("client accepted a server of another name")
+	}
+	if _, _, err := handshake(t, server, mustLoader(t, &Config{Insecure_skip_verify: true, Server_name: "other"})); err != nil {
+		t.Fatalf("insecure client: %v", err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := makeTestCA(t)
+	cert, key := ca.issue(t, "server", "server")
+	server := mustLoader(t, &Config{Cert_file: cert, Key_file: key, Ca_file: ca.path("ca.pem"), Client_auth: true})
+
+	ccert, ckey := ca.issue(t, "client", "billing")
+	saw, _, err := handshake(t, server, mustLoader(t, &Config{Cert_file: ccert, Key_file: ckey, Ca_file: ca.path("ca.pem")}))
+	if err != nil || saw != "billing" {
+		t.Fatalf("server saw %q, %v", saw, err)
+	}
+
+	if _, _, err := handshake(t, server, mustLoader(t, &Config{Ca_file: ca.path("ca.pem")})); err == nil {
+		t.Fatal("server took a client with no certificate")
+	}
+	other := makeTestCA(t)
+	ocert, okey := other.issue(t, "client", "stranger")
+	if _, _, err := handshake(t, server, mustLoader(t, &Config{Cert_file: ocert, Key_file: okey, Ca_file: ca.path("ca.pem")})); err == nil {
+		t.Fatal("server took a client another CA vouched for")
+	}
+}
+
+// as if reloadInterval had passed since the last check.
+func (l *Loader) expire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.checked = time.Time{}
+}
+
+// give a file a new modification time, as a rewrite would, without
+// waiting for the clock to move.
+func touch(t *testing.T, path string, d time.Duration) {
+	at := time.Now().Add(d)
+	if err := os.Chtimes(path, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadCertificates(t *testing.T) {
+	ca := makeTestCA(t)
+	cert, key := ca.issue(t, "server", "one")
+	server := mustLoader(t, &Config{Cert_file: cert, Key_file: key})
+	client := mustLoader(t, &Config{Ca_file: ca.path("ca.pem"), Server_name: "server"})
+
+	cname := func() string {
+		sc, cc := connPair(t)
+		defer sc.Close()
+		defer cc.Close()
+		s := tls.Server(sc, server.ServerConfig())
+		c := tls.Client(cc, &tls.Config{InsecureSkipVerify: true})
+		go s.Handshake()
+		if err := c.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		return c.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	if cn := cname(); cn != "one" {
+		t.Fatalf("served %q", cn)
+	}
+
+	ca.issue(t, "server", "two")
+	touch(t, cert, time.Minute)
+	touch(t, key, time.Minute)
+	if cn := cname(); cn != "one" {
+		t.Fatalf("reloaded within the interval: served %q", cn)
+	}
+	server.expire()
+	if cn := cname(); cn != "two" {
+		t.Fatalf("served %q after the files changed, want two", cn)
+	}
+
+	// a broken file is reported and the old certificate kept.
+	os.WriteFile(key, []byte("not a key"), 0600)
+	touch(t, key, 2*time.Minute)
+	server.expire()
+	if cn := cname(); cn != "two" {
+		t.Fatalf("served %q after a broken reload", cn)
+	}
+	_ = client
+}
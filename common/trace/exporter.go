@@ -0,0 +1,44 @@
+package trace
+
+import (
+	"encoding/json"
+	"os"
+	"srpc/common/logging"
+	"sync"
+)
+
+// takes finished spans somewhere. Export is called from many
+// goroutines and should not block for long.
+type Exporter interface {
+	Export(span *SpanData)
+}
+
+// writes spans to a file, one JSON object per line.
+type FileExporter struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// append spans to the file at path, creating it if need be.
+func MakeFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (e *FileExporter) Export(span *SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.enc.Encode(span); err != nil {
+		logging.Default.Log(logging.Warn, "trace: export span", logging.Err(err))
+	}
+}
+
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.f.Close()
+}
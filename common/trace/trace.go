@@ -0,0 +1,200 @@
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spans around calls, with the trace context carried between client
+// and server in request metadata under "traceparent", as in the W3C
+// Trace Context recommendation:
+//
+//	traceparent: 00-<32 hex trace id>-<16 hex parent span id>-<2 hex flags>
+
+const Header = "traceparent"
+
+const (
+	KindClient = "client"
+	KindServer = "server"
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+func (s SpanID) IsValid() bool  { return s != SpanID{} }
+
+// what a span passes on to its children, in-process or in a request.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+func ParseTraceparent(s string) (SpanContext, error) {
+	sc := SpanContext{}
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, fmt.Errorf("trace: bad traceparent %q", s)
+	}
+	// version 00 has exactly four fields; later versions may add more.
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, fmt.Errorf("trace: bad traceparent %q", s)
+	}
+	tid, err1 := hex.DecodeString(parts[1])
+	sid, err2 := hex.DecodeString(parts[2])
+	flags, err3 := hex.DecodeString(parts[3])
+	if err1 != nil || err2 != nil || err3 != nil || len(tid) != 16 || len(sid) != 8 || len(flags) != 1 {
+		return sc, fmt.Errorf("trace: bad traceparent %q", s)
+	}
+	copy(sc.TraceID[:], tid)
+	copy(sc.SpanID[:], sid)
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("trace: bad traceparent %q", s)
+	}
+	return sc, nil
+}
+
+// makes spans and hands the finished ones to an Exporter. a nil
+// *Tracer makes nil spans, and the methods of a nil *Span do
+// nothing, so that untraced code need not check.
+type Tracer struct {
+	exporter Exporter
+}
+
+func MakeTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+type Event struct {
+	Name       string            `json:"name"`
+	Time       time.Time         `json:"time"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// a finished span, as exporters see it.
+type SpanData struct {
+	Name       string            `json:"name"`
+	Kind       string            `json:"kind"`
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Status     string            `json:"status"` // a protocol.Code name
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Events     []Event           `json:"events,omitempty"`
+}
+
+type Span struct {
+	mu      sync.Mutex
+	tracer  *Tracer
+	context SpanContext
+	data    SpanData
+	ended   bool
+}
+
+// start a span, as a child of parent if it is valid and as the root
+// of a new trace otherwise. a span whose parent was not sampled is
+// not exported either.
+func (t *Tracer) Start(name string, kind string, parent SpanContext) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{tracer: t}
+	if parent.IsValid() {
+		s.context.TraceID = parent.TraceID
+		s.context.Sampled = parent.Sampled
+		s.data.ParentID = parent.SpanID.String()
+	} else {
+		rand.Read(s.context.TraceID[:])
+		s.context.Sampled = true
+	}
+	rand.Read(s.context.SpanID[:])
+	s.data.Name = name
+	s.data.Kind = kind
+	s.data.TraceID = s.context.TraceID.String()
+	s.data.SpanID = s.context.SpanID.String()
+	s.data.Start = time.Now()
+	s.data.Attributes = map[string]string{}
+	return s
+}
+
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Attributes[key] = value
+	}
+}
+
+// note that something happened; attrs are key, value pairs.
+func (s *Span) AddEvent(name string, attrs ...string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := Event{Name: name, Time: time.Now()}
+	if len(attrs) > 1 {
+		e.Attributes = map[string]string{}
+		for i := 0; i+1 < len(attrs); i += 2 {
+			e.Attributes[attrs[i]] = attrs[i+1]
+		}
+	}
+	if !s.ended {
+		s.data.Events = append(s.data.Events, e)
+	}
+}
+
+// finish the span with the status it ended in, and export it.
+// later calls do nothing.
+func (s *Span) End(status string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	s.data.Status = status
+	data := s.data
+	s.mu.Unlock()
+
+	if s.context.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(&data)
+	}
+}
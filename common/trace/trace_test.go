@@ -0,0 +1,146 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// keeps the spans exported to it.
+type memExporter struct {
+	mu    sync.Mutex
+	spans []*SpanData
+}
+
+func (m *memExporter) Export(span *SpanData) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spans = append(m.spans, span)
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	tp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" ||
+		!sc.Sampled {
+		t.Fatalf("parsed %+v", sc)
+	}
+	if s := sc.Traceparent(); s != tp {
+		t.Fatalf("formatted as %q", s)
+	}
+	sc.Sampled = false
+	if s := sc.Traceparent(); s[len(s)-2:] != "00" {
+		t.Fatalf("unsampled formatted as %q", s)
+	}
+	// a later version may carry more fields.
+	if _, err := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-more"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseTraceparentRejects(t *testing.T) {
+	for _, tp := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-more",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902zz-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+	} {
+		if _, err := ParseTraceparent(tp); err == nil {
+			t.Errorf("parsed %q", tp)
+		}
+	}
+}
+
+func TestChildSpansJoinTheTrace(t *testing.T) {
+	m := &memExporter{}
+	tr := MakeTracer(m)
+	root := tr.Start("KV.Get", KindClient, SpanContext{})
+	if !root.Context().IsValid() || !root.Context().Sampled {
+		t.Fatalf("root context %+v", root.Context())
+	}
+	// as a server would, from the request's metadata.
+	parent, err := ParseTraceparent(root.Context().Traceparent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := tr.Start("KV.Get", KindServer, parent)
+	child.SetAttribute("peer", "e1")
+	child.AddEvent("receive", "bytes", "10")
+	child.End("OK")
+	child.End("Internal")
+	root.End("OK")
+
+	if len(m.spans) != 2 {
+		t.Fatalf("%d spans exported, want 2", len(m.spans))
+	}
+	c, r := m.spans[0], m.spans[1]
+	if c.TraceID != r.TraceID || c.ParentID != r.SpanID || r.ParentID != "" || c.SpanID == r.SpanID {
+		t.Fatalf("child %+v not a child of root %+v", c, r)
+	}
+	if c.Status != "OK" || c.Kind != KindServer || c.Attributes["peer"] != "e1" ||
+		len(c.Events) != 1 || c.Events[0].Attributes["bytes"] != "10" {
+		t.Fatalf("child span %+v", c)
+	}
+}
+
+func TestUnsampledAndNilSpans(t *testing.T) {
+	m := &memExporter{}
+	tr := MakeTracer(m)
+	parent := tr.Start("KV.Get", KindClient, SpanContext{}).Context()
+	parent.Sampled = false
+	tr.Start("KV.Get", KindServer, parent).End("OK")
+	if len(m.spans) != 0 {
+		t.Fatal("span of an unsampled trace exported")
+	}
+
+	var none *Tracer
+	s := none.Start("KV.Get", KindClient, SpanContext{})
+	s.SetAttribute("k", "v")
+	s.AddEvent("e")
+	s.End("OK")
+	if s.Context().IsValid() {
+		t.Fatal("nil span has a valid context")
+	}
+}
+
+func TestFileExporterWritesJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	e, err := MakeFileExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := MakeTracer(e)
+	tr.Start("KV.Get", KindClient, SpanContext{}).End("OK")
+	tr.Start("KV.Put", KindClient, SpanContext{}).End("Unavailable")
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	names := []string{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var span SpanData
+		if err := json.Unmarshal(sc.Bytes(), &span); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		names = append(names, span.Name+" "+span.Status)
+	}
+	if len(names) != 2 || names[0] != "KV.Get OK" || names[1] != "KV.Put Unavailable" {
+		t.Fatalf("exported %v", names)
+	}
+}
@@ -0,0 +1,157 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"srpc/common/connect"
+	"sync/atomic"
+)
+
+// the registry's HTTP API for servers and clients. reads are served
+// by any member; writes on a follower are redirected to the leader.
+
+func register(w http.ResponseWriter, r *http.Request) {
+	reg := &connect.Registration{}
+	body, ok := decodeBody(w, r, reg)
+	if !ok {
+		return
+	}
+	actor, ok := rn.authorizeWrite(w, r, body, reg.Server_name)
+	if !ok {
+		rn.audit(r, actor, "register", reg.Server_name, reg.Services, errRefused)
+		return
+	}
+	err := rn.Register(reg)
+	rn.audit(r, actor, "register", reg.Server_name, reg.Services, err)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func unregister(w http.ResponseWriter, r *http.Request) {
+	hb := &connect.ServerArgs{}
+	body, ok := decodeBody(w, r, hb)
+	if !ok {
+		return
+	}
+	actor, ok := rn.authorizeWrite(w, r, body, hb.Server_name)
+	if !ok {
+		rn.audit(r, actor, "unregister", hb.Server_name, nil, errRefused)
+		return
+	}
+	err := rn.DeleteServer(hb.Server_name)
+	rn.audit(r, actor, "unregister", hb.Server_name, nil, err)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func heartbeat(w http.ResponseWriter, r *http.Request) {
+	hb := &connect.ServerArgs{}
+	body, ok := decodeBody(w, r, hb)
+	if !ok {
+		return
+	}
+	if _, ok := rn.authorizeWrite(w, r, body, hb.Server_name); !ok {
+		return
+	}
+	if !rn.ReceiveHeartBeat(hb.Server_name) {
+		// tell the server to register again.
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func services(w http.ResponseWriter, r *http.Request) {
+	serviceName := r.URL.Query().Get("service_name")
+	methodName := r.URL.Query().Get("method_name")
+	sel, err := connect.ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var endpoints []*connect.Endpoint
+	if serviceName == "" {
+		endpoints = []*connect.Endpoint{}
+		for _, ep := range rn.PullServices() {
+			if sel.Matches(&ep.Metadata) {
+				endpoints = append(endpoints, ep)
+			}
+		}
+	} else {
+		endpoints = rn.PullService(serviceName, methodName, sel)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(endpoints)
+}
+
+// count the requests made to an API handler, for statistics.
+func (rn *Network) counted(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&rn.count, 1)
+		if r.ContentLength > 0 {
+			atomic.AddInt64(&rn.bytes, r.ContentLength)
+		}
+		h(w, r)
+	}
+}
+
+// serve h only to those who may read the catalogue.
+func (rn *Network) guarded(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rn.authorizeRead(w, r) {
+			h(w, r)
+		}
+	}
+}
+
+// the largest body a POST to the API may have. it is read before
+// its signature can be checked.
+const maxBody = 1 << 20
+
+// decode a POSTed JSON body into v, and return the body as sent,
+// which is what a signature covers.
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err == nil {
+		err = json.Unmarshal(body, v)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, ErrNotCommitted) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	var notLeader *NotLeaderError
+	if errors.As(err, &notLeader) {
+		if notLeader.Leader != "" {
+			http.Redirect(w, r, "http://"+notLeader.Leader+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,163 @@
+package registry
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"srpc/common/auth"
+	"srpc/common/connect"
+	"srpc/common/logging"
+	"strconv"
+	"time"
+)
+
+// who may change the catalogue. with Options.Auth set, a write must
+// be signed (see connect.Key) either by the server it is about, with
+// its key in Server_keys, or by an admin. lookups, and the dashboard,
+// status and metrics, need a signature by any known key, or the
+// shared Configuration_key, unless reads are open.
+// every register and unregister goes to the audit trail.
+type AuthOptions struct {
+	Configuration_key string            // a key anyone may sign lookups with; it cannot write
+	Server_keys       map[string]string // server name -> the key only it may sign with
+	Admin_keys        map[string]string // id -> a key that may write for any server
+	Reader_keys       map[string]string // id -> a key that may look services up
+	Open_reads        bool              // anyone may look services up
+	Audit_file        string            // JSON lines of every write; "" for the registry's log
+}
+
+// what the audit trail records for a write that was turned away.
+var errRefused = errors.New("refused")
+
+// a request's claim to be signed.
+type signature struct {
+	id  string
+	ts  string
+	sig []byte
+}
+
+// the signature r carries, if it is there and fresh. the id is ""
+// if r is not signed at all.
+func signer(r *http.Request) (*signature, bool) {
+	s := &signature{id: r.Header.Get(connect.KeyIDHeader), ts: r.Header.Get(connect.TimestampHeader)}
+	if s.id == "" || r.Header.Get(connect.NonceHeader) == "" {
+		return s, false
+	}
+	ns, err := strconv.ParseInt(s.ts, 10, 64)
+	if err != nil {
+		return s, false
+	}
+	if skew := time.Since(time.Unix(0, ns)); skew > auth.MaxSkew || skew < -auth.MaxSkew {
+		return s, false
+	}
+	if s.sig, err = hex.DecodeString(r.Header.Get(connect.SignatureHeader)); err != nil {
+		return s, false
+	}
+	return s, true
+}
+
+// whether s is r's signature under key, r's body being body.
+func (s *signature) by(r *http.Request, body []byte, key string) bool {
+	if key == "" {
+		return false
+	}
+	return hmac.Equal(s.sig, auth.Sign([]byte(key), s.id, s.ts, connect.SignedTarget(r), body))
+}
+
+// check that r may change what the registry knows of serverName,
+// answering the caller if not. returns who signed r.
+func (rn *Network) authorizeWrite(w http.ResponseWriter, r *http.Request, body []byte, serverName string) (string, bool) {
+	a := rn.options.Auth
+	if a == nil {
+		return "", true
+	}
+	s, ok := signer(r)
+	if !ok {
+		http.Error(w, "registry: missing or stale signature", http.StatusUnauthorized)
+		return s.id, false
+	}
+	admin := s.by(r, body, a.Admin_keys[s.id])
+	if !admin && !s.by(r, body, a.Server_keys[s.id]) {
+		http.Error(w, "registry: bad signature", http.StatusUnauthorized)
+		return s.id, false
+	}
+	if !rn.replays.First(s.id, r.Header.Get(connect.NonceHeader)) {
+		http.Error(w, "registry: request replayed", http.StatusUnauthorized)
+		return s.id, false
+	}
+	if !admin && s.id != serverName {
+		http.Error(w, "registry: "+s.id+" may not write for "+serverName, http.StatusForbidden)
+		return s.id, false
+	}
+	return s.id, true
+}
+
+// check that r may look services up, or see the dashboard, status or
+// metrics, which show as much; answer the caller if not.
+func (rn *Network) authorizeRead(w http.ResponseWriter, r *http.Request) bool {
+	a := rn.options.Auth
+	if a == nil || a.Open_reads {
+		return true
+	}
+	s, ok := signer(r)
+	if !ok {
+		http.Error(w, "registry: missing or stale signature", http.StatusUnauthorized)
+		return false
+	}
+	for _, key := range []string{a.Reader_keys[s.id], a.Admin_keys[s.id], a.Server_keys[s.id], a.Configuration_key} {
+		if s.by(r, nil, key) {
+			if !rn.replays.First(s.id, r.Header.Get(connect.NonceHeader)) {
+				http.Error(w, "registry: request replayed", http.StatusUnauthorized)
+				return false
+			}
+			return true
+		}
+	}
+	http.Error(w, "registry: bad signature", http.StatusUnauthorized)
+	return false
+}
+
+// record who did what to the catalogue.
+func (rn *Network) audit(r *http.Request, actor string, op string, serverName string, services []*connect.ServiceInfo,
+	err error) {
+	fields := []logging.Field{
+		logging.F("actor", actor),
+		logging.Peer(r.RemoteAddr),
+		logging.F("op", op),
+		logging.F("server", serverName),
+	}
+	if len(services) > 0 {
+		names := []string{}
+		for _, svc := range services {
+			names = append(names, svc.Service_name+"."+svc.Method_name)
+		}
+		fields = append(fields, logging.F("services", names))
+	}
+	if err != nil {
+		fields = append(fields, logging.Err(err))
+	}
+	rn.auditLog().Log(logging.Info, "registry: audit", fields...)
+}
+
+func (rn *Network) auditLog() logging.Logger {
+	if rn.auditor != nil {
+		return rn.auditor
+	}
+	return getLogger()
+}
+
+// open the audit file the options name, if any.
+func (rn *Network) openAudit() error {
+	a := rn.options.Auth
+	if a == nil || a.Audit_file == "" {
+		return nil
+	}
+	f, err := logging.OpenRotatingFile(a.Audit_file, 0, 0)
+	if err != nil {
+		return err
+	}
+	rn.auditFile = f
+	rn.auditor = logging.JSON(f, logging.Info)
+	return nil
+}
@@ -0,0 +1,154 @@
+package registry
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"srpc/common/auth"
+	"srpc/common/connect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeAuthNetwork(t *testing.T) *Network {
+	n := makeTestNetwork(t, "")
+	n.options.Auth = &AuthOptions{
+		Configuration_key: "shared",
+		Server_keys:       map[string]string{"s1": "k1", "s2": "k2"},
+		Admin_keys:        map[string]string{"ops": "admin"},
+		Reader_keys:       map[string]string{"cli": "reader"},
+	}
+	return n
+}
+
+func signedRequest(method, target, body string, key *connect.Key) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	key.Sign(r, []byte(body))
+	return r
+}
+
+func writeStatus(n *Network, r *http.Request, body, serverName string) int {
+	w := httptest.NewRecorder()
+	if _, ok := n.authorizeWrite(w, r, []byte(body), serverName); ok {
+		return http.StatusOK
+	}
+	return w.Code
+}
+
+func TestAuthorizeWrite(t *testing.T) {
+	n := makeAuthNetwork(t)
+	body := `{"server_name":"s1"}`
+	cases := []struct {
+		name   string
+		key    *connect.Key
+		server string
+		want   int
+	}{
+		{"unsigned", nil, "s1", http.StatusUnauthorized},
+		{"own key", &connect.Key{Id: "s1", Secret: "k1"}, "s1", http.StatusOK},
+		{"another server's name", &connect.Key{Id: "s1", Secret: "k1"}, "s2", http.StatusForbidden},
+		{"wrong key", &connect.Key{Id: "s1", Secret: "k2"}, "s1", http.StatusUnauthorized},
+		{"admin", &connect.Key{Id: "ops", Secret: "admin"}, "s2", http.StatusOK},
+		{"shared key", &connect.Key{Id: "s1", Secret: "shared"}, "s1", http.StatusUnauthorized},
+		{"reader", &connect.Key{Id: "cli", Secret: "reader"}, "s1", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		r := signedRequest(http.MethodPost, "/api/register", body, c.key)
+		if got := writeStatus(n, r, body, c.server); got != c.want {
+			t.Errorf("%v: %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestAuthorizeWriteTampered(t *testing.T) {
+	n := makeAuthNetwork(t)
+	key := &connect.Key{Id: "s1", Secret: "k1"}
+	body := `{"server_name":"s1"}`
+
+	r := signedRequest(http.MethodPost, "/api/register", body, key)
+	if got := writeStatus(n, r, `{"server_name":"s2"}`, "s1"); got != http.StatusUnauthorized {
+		t.Errorf("changed body: %d", got)
+	}
+
+	r = signedRequest(http.MethodPost, "/api/register", body, key)
+	r.URL.Path = "/api/unregister"
+	if got := writeStatus(n, r, body, "s1"); got != http.StatusUnauthorized {
+		t.Errorf("changed path: %d", got)
+	}
+
+	// signed properly, but long ago.
+	r = httptest.NewRequest(http.MethodPost, "/api/register", strings.NewReader(body))
+	ts := strconv.FormatInt(time.Now().Add(-2*auth.MaxSkew).UnixNano(), 10)
+	r.Header.Set(connect.KeyIDHeader, "s1")
+	r.Header.Set(connect.TimestampHeader, ts)
+	r.Header.Set(connect.NonceHeader, "00")
+	sig := auth.Sign([]byte("k1"), "s1", ts, connect.SignedTarget(r), []byte(body))
+	r.Header.Set(connect.SignatureHeader, hex.EncodeToString(sig))
+	if got := writeStatus(n, r, body, "s1"); got != http.StatusUnauthorized {
+		t.Errorf("stale timestamp: %d", got)
+	}
+}
+
+func TestAuthorizeWriteReplay(t *testing.T) {
+	n := makeAuthNetwork(t)
+	body := `{"server_name":"s1"}`
+	r := signedRequest(http.MethodPost, "/api/unregister", body, &connect.Key{Id: "s1", Secret: "k1"})
+	if got := writeStatus(n, r, body, "s1"); got != http.StatusOK {
+		t.Fatalf("first time: %d", got)
+	}
+	again := httptest.NewRequest(http.MethodPost, "/api/unregister", strings.NewReader(body))
+	again.Header = r.Header.Clone()
+	if got := writeStatus(n, again, body, "s1"); got != http.StatusUnauthorized {
+		t.Fatalf("replayed: %d", got)
+	}
+}
+
+func TestAuthorizeRead(t *testing.T) {
+	n := makeAuthNetwork(t)
+	status := func(key *connect.Key) int {
+		r := signedRequest(http.MethodGet, "/api/services?service_name=KV&method_name=Get", "", key)
+		w := httptest.NewRecorder()
+		n.guarded(func(w http.ResponseWriter, r *http.Request) {})(w, r)
+		return w.Code
+	}
+	cases := []struct {
+		name string
+		key  *connect.Key
+		want int
+	}{
+		{"unsigned", nil, http.StatusUnauthorized},
+		{"reader", &connect.Key{Id: "cli", Secret: "reader"}, http.StatusOK},
+		{"shared key", &connect.Key{Id: "anyone", Secret: "shared"}, http.StatusOK},
+		{"server", &connect.Key{Id: "s2", Secret: "k2"}, http.StatusOK},
+		{"admin", &connect.Key{Id: "ops", Secret: "admin"}, http.StatusOK},
+		{"unknown key", &connect.Key{Id: "cli", Secret: "guess"}, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		if got := status(c.key); got != c.want {
+			t.Errorf("%v: %d, want %d", c.name, got, c.want)
+		}
+	}
+
+	n.options.Auth.Open_reads = true
+	if got := status(nil); got != http.StatusOK {
+		t.Errorf("unsigned with open reads: %d", got)
+	}
+}
+
+func TestDecodeBodyIsBounded(t *testing.T) {
+	var reg connect.Registration
+	body := `{"server_name":"s1"}`
+	r := httptest.NewRequest(http.MethodPost, "/api/register", strings.NewReader(body))
+	if got, ok := decodeBody(httptest.NewRecorder(), r, &reg); !ok || string(got) != body || reg.Server_name != "s1" {
+		t.Fatalf("decoded %q, %v", got, ok)
+	}
+
+	big := `{"server_name":"` + strings.Repeat("x", maxBody) + `"}`
+	r = httptest.NewRequest(http.MethodPost, "/api/register", strings.NewReader(big))
+	w := httptest.NewRecorder()
+	if _, ok := decodeBody(w, r, &reg); ok || w.Code != http.StatusBadRequest {
+		t.Fatalf("body over %d bytes: %v, %d", maxBody, ok, w.Code)
+	}
+}
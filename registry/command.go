@@ -0,0 +1,179 @@
+package registry
+
+import (
+	"srpc/common/connect"
+	"srpc/common/logging"
+	"time"
+)
+
+const (
+	OpAddServer     = "add_server"
+	OpDeleteServer  = "delete_server"
+	OpAddService    = "add_service"
+	OpDeleteService = "delete_service"
+	OpNoop          = "noop"     // appended by a new raft leader
+	OpConflict      = "conflict" // a refused registration, only for its Conflicts
+)
+
+// a change to the server/service catalogue. every write to the
+// registry is expressed as a Command so that it can be logged,
+// replayed on restart, and rebuilt from a snapshot.
+// heartbeats only move leases and are never logged.
+type Command struct {
+	Op           string            `json:"op"`
+	Server_name  string            `json:"server_name"`
+	Server_ip    string            `json:"server_ip,omitempty"`
+	Server_port  string            `json:"server_port,omitempty"`
+	Service_name string            `json:"service_name,omitempty"`
+	Method_name  string            `json:"method_name,omitempty"`
+	Service_key  string            `json:"service_key,omitempty"`
+	Epoch        int64             `json:"epoch,omitempty"`
+	Metadata     *connect.Metadata `json:"metadata,omitempty"`
+	Conflicts    []*Conflict       `json:"conflicts,omitempty"` // settled before it was committed
+}
+
+// make a command take effect. in a cluster it goes through the
+// raft log and is applied by every member once committed; a single
+// registry writes it ahead to its own log and applies it directly.
+// the caller must not hold rn.mu.
+func (rn *Network) commit(cmd *Command) error {
+	if rn.raft != nil {
+		return rn.raft.Submit(cmd)
+	}
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if rn.persister != nil {
+		if err := rn.persister.Append(cmd); err != nil {
+			getLogger().Log(logging.Error, "registry: append to log", logging.F("op", cmd.Op),
+				logging.F("server", cmd.Server_name), logging.Err(err))
+			return err
+		}
+	}
+	rn.recordConflicts(cmd)
+	rn.apply(cmd)
+	return nil
+}
+
+// apply the committed raft entry at index. an entry may be applied
+// again, but its conflicts are recorded only the first time.
+func (rn *Network) applyCommitted(index int, cmd *Command) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	if index > rn.conflictsAt {
+		rn.recordConflicts(cmd)
+		rn.conflictsAt = index
+	}
+	rn.apply(cmd)
+}
+
+// replace the catalogue with a raft snapshot.
+func (rn *Network) installSnapshot(cmds []*Command) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	old := rn.servers
+	rn.servers = map[interface{}]*Server{}
+	for _, cmd := range cmds {
+		rn.apply(cmd)
+	}
+	rn.solveConflictServices(true)
+	// leases are local to each member, keep them.
+	for name, server := range rn.servers {
+		if prev, ok := old[name]; ok {
+			server.lastActiveTime = prev.lastActiveTime
+			server.server_enabled = prev.server_enabled
+			server.count = prev.count
+		}
+	}
+}
+
+func (rn *Network) takeSnapshot() []*Command {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	return rn.stateCommands()
+}
+
+// apply a command to the in-memory state. replaying a command that
+// has already taken effect is harmless. the caller holds rn.mu.
+func (rn *Network) apply(cmd *Command) {
+	switch cmd.Op {
+	case OpAddServer:
+		if server, ok := rn.servers[cmd.Server_name]; ok {
+			// a server that registers again may have moved.
+			server.server_ip = cmd.Server_ip
+			server.server_port = cmd.Server_port
+			if cmd.Epoch > server.server_epoch {
+				server.server_epoch = cmd.Epoch
+			}
+			return
+		}
+		rn.servers[cmd.Server_name] = &Server{
+			server_name:    cmd.Server_name,
+			server_ip:      cmd.Server_ip,
+			server_port:    cmd.Server_port,
+			server_epoch:   cmd.Epoch,
+			server_enabled: true,
+			lastActiveTime: time.Now(),
+		}
+	case OpDeleteServer:
+		delete(rn.servers, cmd.Server_name)
+	case OpAddService:
+		server, ok := rn.servers[cmd.Server_name]
+		if !ok {
+			return
+		}
+		// conflicts were settled before the command was committed,
+		// so registering a method again just takes the new key.
+		if service := rn.getService(cmd.Server_name, cmd.Service_name, cmd.Method_name, true); service != nil {
+			service.service_key = cmd.Service_key
+			service.setMetadata(cmd.Metadata)
+			return
+		}
+		service := &Service{
+			service_name:    cmd.Service_name,
+			service_key:     cmd.Service_key,
+			method_name:     cmd.Method_name,
+			service_enabled: true,
+		}
+		service.setMetadata(cmd.Metadata)
+		server.services = append(server.services, service)
+	case OpDeleteService:
+		index := rn.getServiceIndexByName(cmd.Server_name, cmd.Service_name, cmd.Method_name, true)
+		if index < 0 {
+			return
+		}
+		_ = rn.deleteServiceAtIndex(cmd.Server_name, index, true)
+	case OpNoop, OpConflict:
+	default:
+		getLogger().Log(logging.Warn, "registry: unknown command", logging.F("op", cmd.Op))
+	}
+}
+
+// the commands that rebuild the current catalogue from scratch.
+// the caller holds rn.mu.
+func (rn *Network) stateCommands() []*Command {
+	cmds := []*Command{}
+	for _, server := range rn.servers {
+		cmds = append(cmds, &Command{
+			Op:          OpAddServer,
+			Server_name: server.server_name,
+			Server_ip:   server.server_ip,
+			Server_port: server.server_port,
+			Epoch:       server.server_epoch,
+		})
+		for _, service := range server.services {
+			meta := service.metadata()
+			cmds = append(cmds, &Command{
+				Op:           OpAddService,
+				Server_name:  server.server_name,
+				Service_name: service.service_name,
+				Method_name:  service.method_name,
+				Service_key:  service.service_key,
+				Metadata:     &meta,
+			})
+		}
+	}
+	return cmds
+}
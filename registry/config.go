@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"time"
+)
+
+type ConfigFormatInterface interface {
+	TransferToOptions() *Options
+	TransferToFormat(*Options)
+	Write(string) error
+	Parse(string) error
+	ParseFromText(string) error
+}
+
+type Config struct {
+	Options *Options
+	Format  ConfigFormatInterface
+}
+
+// settings of a registry process.
+type Options struct {
+	Registry_port     string
+	Data_dir          string        // empty means state is kept in memory only
+	Snapshot_interval time.Duration // how often the log is compacted into a snapshot
+	Lease_timeout     time.Duration // a server without heartbeats for this long is disabled
+	Lease_grace       time.Duration // extra lease time given to servers restored from disk
+	Me                string        // this registry's address in the cluster, as host:port
+	Peers             []string      // addresses of every cluster member; empty runs a single registry
+	Cluster_key       string        // members sign raft RPCs with it; "" leaves them unsigned
+	Conflict_policy   string        // PolicyLastWriterWins, PolicyReject or PolicyMerge
+	Metrics           bool          // serve Prometheus metrics on /metrics
+	Auth              *AuthOptions  // nil lets anyone read and write
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		Registry_port:     "8080",
+		Snapshot_interval: 30 * time.Second,
+		Lease_timeout:     3 * time.Second,
+		Lease_grace:       10 * time.Second,
+		Conflict_policy:   PolicyLastWriterWins,
+	}
+}
+
+func NewConfig(confName string, format ConfigFormatInterface) (*Config, error) {
+	c := &Config{
+		Format: format,
+	}
+	err := c.Parse(confName)
+	if err != nil {
+		return nil, err
+	}
+	c.Options = c.Format.TransferToOptions()
+	return c, nil
+}
+
+func NewConfigFromText(text string, format ConfigFormatInterface) (*Config, error) {
+	c := &Config{
+		Format: format,
+	}
+	err := c.ParseFromText(text)
+	if err != nil {
+		return nil, err
+	}
+	c.Options = c.Format.TransferToOptions()
+	return c, nil
+}
+
+func (c *Config) Parse(fname string) (err error) {
+	return c.Format.Parse(fname)
+}
+
+func (c *Config) ParseFromText(text string) error {
+	return c.Format.ParseFromText(text)
+}
+
+func (c *Config) Write(fname string) (err error) {
+	return c.Format.Write(fname)
+}
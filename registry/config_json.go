@@ -0,0 +1,118 @@
+package registry
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+)
+
+type JSONConfigFormat struct {
+	Configuation_name    string          `json:"configuation_name"`
+	Configuration_key    string          `json:"configuation_key"`
+	Configuation_version string          `json:"configuation_version"`
+	Registry_port        string          `json:"registry_port"`
+	Data_dir             string          `json:"data_dir"`
+	Snapshot_interval_ms int             `json:"snapshot_interval_ms"`
+	Lease_timeout_ms     int             `json:"lease_timeout_ms"`
+	Lease_grace_ms       int             `json:"lease_grace_ms"`
+	Me                   string          `json:"me"`
+	Peers                []string        `json:"peers"`
+	Cluster_key          string          `json:"cluster_key"`
+	Conflict_policy      string          `json:"conflict_policy"`
+	Metrics              bool            `json:"metrics"`
+	Auth                 *JSONAuthFormat `json:"auth,omitempty"`
+}
+
+// who may write to and read from the registry. configuation_key
+// above is a key anyone may sign lookups with.
+type JSONAuthFormat struct {
+	Server_keys map[string]string `json:"server_keys,omitempty"`
+	Admin_keys  map[string]string `json:"admin_keys,omitempty"`
+	Reader_keys map[string]string `json:"reader_keys,omitempty"`
+	Open_reads  *bool             `json:"open_reads,omitempty"` // default true
+	Audit_file  string            `json:"audit_file,omitempty"`
+}
+
+func (c *JSONConfigFormat) TransferToOptions() *Options {
+	o := DefaultOptions()
+	if c.Registry_port != "" {
+		o.Registry_port = c.Registry_port
+	}
+	o.Data_dir = c.Data_dir
+	if c.Snapshot_interval_ms > 0 {
+		o.Snapshot_interval = time.Duration(c.Snapshot_interval_ms) * time.Millisecond
+	}
+	if c.Lease_timeout_ms > 0 {
+		o.Lease_timeout = time.Duration(c.Lease_timeout_ms) * time.Millisecond
+	}
+	if c.Lease_grace_ms > 0 {
+		o.Lease_grace = time.Duration(c.Lease_grace_ms) * time.Millisecond
+	}
+	o.Me = c.Me
+	o.Peers = c.Peers
+	o.Cluster_key = c.Cluster_key
+	if c.Conflict_policy != "" {
+		o.Conflict_policy = c.Conflict_policy
+	}
+	o.Metrics = c.Metrics
+	if a := c.Auth; a != nil {
+		o.Auth = &AuthOptions{Configuration_key: c.Configuration_key, Open_reads: true}
+		o.Auth.Server_keys = a.Server_keys
+		o.Auth.Admin_keys = a.Admin_keys
+		o.Auth.Reader_keys = a.Reader_keys
+		if a.Open_reads != nil {
+			o.Auth.Open_reads = *a.Open_reads
+		}
+		o.Auth.Audit_file = a.Audit_file
+	}
+	return o
+}
+
+func (c *JSONConfigFormat) TransferToFormat(o *Options) {
+	c.Registry_port = o.Registry_port
+	c.Data_dir = o.Data_dir
+	c.Snapshot_interval_ms = int(o.Snapshot_interval / time.Millisecond)
+	c.Lease_timeout_ms = int(o.Lease_timeout / time.Millisecond)
+	c.Lease_grace_ms = int(o.Lease_grace / time.Millisecond)
+	c.Me = o.Me
+	c.Peers = o.Peers
+	c.Cluster_key = o.Cluster_key
+	c.Conflict_policy = o.Conflict_policy
+	c.Metrics = o.Metrics
+	c.Configuration_key = ""
+	c.Auth = nil
+	if a := o.Auth; a != nil {
+		openReads := a.Open_reads
+		c.Configuration_key = a.Configuration_key
+		c.Auth = &JSONAuthFormat{
+			Server_keys: a.Server_keys,
+			Admin_keys:  a.Admin_keys,
+			Reader_keys: a.Reader_keys,
+			Open_reads:  &openReads,
+			Audit_file:  a.Audit_file,
+		}
+	}
+}
+
+func (c *JSONConfigFormat) Write(fname string) error {
+	filePtr, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer filePtr.Close()
+	return json.NewEncoder(filePtr).Encode(c)
+}
+
+func (c *JSONConfigFormat) Parse(fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(&c)
+}
+
+func (c *JSONConfigFormat) ParseFromText(text string) error {
+	return json.NewDecoder(strings.NewReader(text)).Decode(&c)
+}
@@ -0,0 +1,287 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"srpc/common/connect"
+	"srpc/common/logging"
+	"time"
+)
+
+// how the registry settles registrations that contradict each other.
+//
+//	last_writer_wins: the registration with the higher epoch (a server
+//	picks a new one every time it starts) takes over; older ones are
+//	refused.
+//	reject: the registration that arrives second is refused.
+//	merge: both are kept. a server name claimed from a new address
+//	moves there and keeps the methods it had; a method registered
+//	again keeps its key unless it had none; a shared key is allowed.
+const (
+	PolicyLastWriterWins = "last_writer_wins"
+	PolicyReject         = "reject"
+	PolicyMerge          = "merge"
+)
+
+const (
+	ConflictServerName      = "server_name"      // two live servers claim one name
+	ConflictDuplicateMethod = "duplicate_method" // a server registers a method twice
+	ConflictServiceKey      = "service_key"      // two methods claim one service key
+)
+
+const maxConflicts = 100 // conflicts kept for the status API
+
+type Conflict struct {
+	Time         time.Time `json:"time"`
+	Kind         string    `json:"kind"`
+	Policy       string    `json:"policy"`
+	Server_name  string    `json:"server_name"`
+	Service_name string    `json:"service_name,omitempty"`
+	Method_name  string    `json:"method_name,omitempty"`
+	Service_key  string    `json:"service_key,omitempty"`
+	Existing     string    `json:"existing"`   // who held it
+	Incoming     string    `json:"incoming"`   // who asked for it
+	Resolution   string    `json:"resolution"` // "rejected", "replaced" or "merged"
+}
+
+// returned for a registration refused under the conflict policy.
+type ConflictError struct {
+	Conflict *Conflict
+}
+
+func (e *ConflictError) Error() string {
+	c := e.Conflict
+	return fmt.Sprintf("registry: %v conflict on %v: %v already held by %v",
+		c.Kind, c.Server_name, c.Incoming, c.Existing)
+}
+
+// conflicts are settled where the registration arrives, the leader
+// in a cluster, and carried by the commands that carry the outcome
+// out; every member records them as it applies those, so that all
+// of them tell the same history.
+
+// the caller holds rn.mu.
+func (rn *Network) recordConflict(c *Conflict) {
+	rn.conflicts = append(rn.conflicts, c)
+	if len(rn.conflicts) > maxConflicts {
+		rn.conflicts = rn.conflicts[len(rn.conflicts)-maxConflicts:]
+	}
+}
+
+// record the conflicts cmd carries. the caller holds rn.mu.
+func (rn *Network) recordConflicts(cmd *Command) {
+	for _, c := range cmd.Conflicts {
+		rn.recordConflict(c)
+	}
+}
+
+// whether policy is one the registry knows; "" is last_writer_wins.
+func validPolicy(policy string) bool {
+	switch policy {
+	case "", PolicyLastWriterWins, PolicyReject, PolicyMerge:
+		return true
+	}
+	return false
+}
+
+func (rn *Network) settle(c *Conflict, resolution string) *Conflict {
+	c.Time = time.Now()
+	c.Policy = rn.options.Conflict_policy
+	c.Resolution = resolution
+	return c
+}
+
+func (rn *Network) rejectConflict(c *Conflict) error {
+	return &ConflictError{Conflict: rn.settle(c, "rejected")}
+}
+
+// commit the conflict a registration was refused for, if it was,
+// so that it is recorded like any other. the caller must not hold
+// rn.mu.
+func (rn *Network) commitRefusal(err error) {
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		return
+	}
+	c := conflict.Conflict
+	if err := rn.commit(&Command{Op: OpConflict, Server_name: c.Server_name, Conflicts: []*Conflict{c}}); err != nil {
+		getLogger().Log(logging.Warn, "registry: record conflict", logging.F("server", c.Server_name),
+			logging.F("kind", c.Kind), logging.Err(err))
+	}
+}
+
+// whether a and b say the same of a service; no tags and an empty
+// set of them are the same.
+func sameMetadata(a, b connect.Metadata) bool {
+	if a.Version != b.Version || a.Weight != b.Weight || a.Zone != b.Zone || a.Describtion != b.Describtion ||
+		len(a.Tags) != len(b.Tags) {
+		return false
+	}
+	for k, v := range a.Tags {
+		if w, ok := b.Tags[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// register a server and exactly the methods it offers.
+func (rn *Network) Register(reg *connect.Registration) error {
+	merged, err := rn.AddServer(reg.Server_name, reg.Server_ip, reg.Server_port, reg.Epoch)
+	if err != nil {
+		return err
+	}
+	// drop whatever the server no longer offers, unless it is
+	// being merged with another registration of the same name.
+	if !merged {
+		offered := map[[2]string]bool{}
+		for _, svc := range reg.Services {
+			offered[[2]string{svc.Service_name, svc.Method_name}] = true
+		}
+		for _, ep := range rn.serverEndpoints(reg.Server_name) {
+			if offered[[2]string{ep.Service_name, ep.Method_name}] {
+				continue
+			}
+			if err := rn.DeleteService(reg.Server_name, ep.Service_name, ep.Method_name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, svc := range reg.Services {
+		meta := svc.Metadata
+		if err := rn.AddService(reg.Server_name, svc.Service_name, svc.Method_name, svc.Service_key, &meta); err != nil {
+			return err
+		}
+	}
+	rn.ReceiveHeartBeat(reg.Server_name)
+	return nil
+}
+
+// decide whether a server may take serverName at a new address,
+// and return the conflict settled, if any, for the command to carry.
+// a name held by a server whose lease has run out is free to take.
+// the caller holds rn.mu.
+func (rn *Network) resolveServerConflict(serverName, serverIp, serverPort string, epoch int64) (merged bool,
+	settled *Conflict, err error) {
+	server, ok := rn.servers[serverName]
+	if !ok || !server.server_enabled {
+		return false, nil, nil
+	}
+	if server.server_ip == serverIp && server.server_port == serverPort {
+		return false, nil, nil
+	}
+	c := &Conflict{
+		Kind:        ConflictServerName,
+		Server_name: serverName,
+		Existing:    net.JoinHostPort(server.server_ip, server.server_port),
+		Incoming:    net.JoinHostPort(serverIp, serverPort),
+	}
+	switch rn.options.Conflict_policy {
+	case PolicyReject:
+		return false, nil, rn.rejectConflict(c)
+	case PolicyMerge:
+		return true, rn.settle(c, "merged"), nil
+	default:
+		if epoch <= server.server_epoch {
+			return false, nil, rn.rejectConflict(c)
+		}
+		return false, rn.settle(c, "replaced"), nil
+	}
+}
+
+// decide how serviceName.methodName with serviceKey joins serverName,
+// returning the commands that carry it out; the last one carries the
+// conflicts settled. changing only the metadata of a method is not a
+// conflict.
+// the caller holds rn.mu.
+func (rn *Network) resolveServiceConflicts(serverName, serviceName, methodName, serviceKey string, meta *connect.Metadata) ([]*Command, error) {
+	server := rn.servers[serverName]
+	cmd := &Command{
+		Op:           OpAddService,
+		Server_name:  serverName,
+		Service_name: serviceName,
+		Method_name:  methodName,
+		Service_key:  serviceKey,
+		Metadata:     meta,
+	}
+	cmds := []*Command{cmd}
+
+	if existing := rn.getService(serverName, serviceName, methodName, true); existing != nil {
+		if existing.service_key == serviceKey {
+			if meta != nil && sameMetadata(existing.metadata(), *meta) {
+				return nil, nil
+			}
+			return cmds, nil
+		}
+		c := &Conflict{
+			Kind:         ConflictDuplicateMethod,
+			Server_name:  serverName,
+			Service_name: serviceName,
+			Method_name:  methodName,
+			Service_key:  serviceKey,
+			Existing:     existing.service_key,
+			Incoming:     serviceKey,
+		}
+		switch rn.options.Conflict_policy {
+		case PolicyReject:
+			return nil, rn.rejectConflict(c)
+		case PolicyMerge:
+			if existing.service_key != "" {
+				cmd.Service_key = existing.service_key
+			}
+			rn.settle(c, "merged")
+		default:
+			rn.settle(c, "replaced")
+		}
+		cmd.Conflicts = append(cmd.Conflicts, c)
+		if cmd.Service_key == existing.service_key {
+			return cmds, nil
+		}
+	}
+
+	if cmd.Service_key == "" {
+		return cmds, nil
+	}
+	for _, holder := range rn.servers {
+		for _, service := range holder.services {
+			if service.service_key != cmd.Service_key {
+				continue
+			}
+			if holder == server && service.service_name == serviceName && service.method_name == methodName {
+				continue
+			}
+			c := &Conflict{
+				Kind:         ConflictServiceKey,
+				Server_name:  serverName,
+				Service_name: serviceName,
+				Method_name:  methodName,
+				Service_key:  cmd.Service_key,
+				Existing:     holder.server_name + "/" + service.service_name + "." + service.method_name,
+				Incoming:     serverName + "/" + serviceName + "." + methodName,
+			}
+			switch rn.options.Conflict_policy {
+			case PolicyReject:
+				return nil, rn.rejectConflict(c)
+			case PolicyMerge:
+				rn.settle(c, "merged")
+			default:
+				if server.server_epoch < holder.server_epoch {
+					return nil, rn.rejectConflict(c)
+				}
+				// the newer registration takes the key.
+				rn.settle(c, "replaced")
+				meta := service.metadata()
+				cmds = append([]*Command{{
+					Op:           OpAddService,
+					Server_name:  holder.server_name,
+					Service_name: service.service_name,
+					Method_name:  service.method_name,
+					Metadata:     &meta,
+				}}, cmds...)
+			}
+			cmd.Conflicts = append(cmd.Conflicts, c)
+		}
+	}
+	return cmds, nil
+}
@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"errors"
+	"testing"
+)
+
+func conflictsOf(n *Network) []*Conflict {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]*Conflict{}, n.conflicts...)
+}
+
+func TestConflictPolicies(t *testing.T) {
+	cases := []struct {
+		policy     string
+		epoch      int64
+		refused    bool
+		resolution string
+		address    string
+	}{
+		{PolicyLastWriterWins, 2, false, "replaced", "10.0.0.2"},
+		{PolicyLastWriterWins, 1, true, "rejected", "10.0.0.1"},
+		{PolicyReject, 2, true, "rejected", "10.0.0.1"},
+		{PolicyMerge, 2, false, "merged", "10.0.0.2"},
+	}
+	for _, c := range cases {
+		n := makeTestNetwork(t, "")
+		n.options.Conflict_policy = c.policy
+		if _, err := n.AddServer("s1", "10.0.0.1", "1", 1); err != nil {
+			t.Fatal(err)
+		}
+		_, err := n.AddServer("s1", "10.0.0.2", "1", c.epoch)
+		var conflict *ConflictError
+		if refused := errors.As(err, &conflict); refused != c.refused {
+			t.Errorf("%v, epoch %d: %v", c.policy, c.epoch, err)
+		}
+		recorded := conflictsOf(n)
+		if len(recorded) != 1 || recorded[0].Resolution != c.resolution || recorded[0].Policy != c.policy {
+			t.Errorf("%v, epoch %d: recorded %+v", c.policy, c.epoch, recorded)
+		}
+		n.mu.Lock()
+		if ip := n.servers["s1"].server_ip; ip != c.address {
+			t.Errorf("%v, epoch %d: s1 at %v, want %v", c.policy, c.epoch, ip, c.address)
+		}
+		n.mu.Unlock()
+	}
+}
+
+func TestUnknownConflictPolicy(t *testing.T) {
+	if err := MakeNetworkFromConfigText(`{"conflict_policy": "last_writer_win"}`); err == nil {
+		t.Fatal("made a network with a misspelt conflict policy")
+	}
+}
+
+func TestConflictsRecordedOncePerEntry(t *testing.T) {
+	n := makeTestNetwork(t, "")
+	cmd := &Command{Op: OpConflict, Server_name: "s1", Conflicts: []*Conflict{{Kind: ConflictServerName}}}
+	n.applyCommitted(1, cmd)
+	// applied again, as when a snapshot is installed while entries
+	// are being applied.
+	n.applyCommitted(1, cmd)
+	if got := len(conflictsOf(n)); got != 1 {
+		t.Fatalf("%d conflicts recorded for one entry", got)
+	}
+	n.applyCommitted(2, cmd)
+	if got := len(conflictsOf(n)); got != 2 {
+		t.Fatalf("%d conflicts recorded for two entries", got)
+	}
+}
@@ -1,197 +1,35 @@
 package registry
 
 import (
+	"sort"
 	"sync"
 	"time"
-	"math/rand"
 	"sync/atomic"
+	"srpc/common/auth"
+	"srpc/common/connect"
+	"srpc/common/logging"
 )
 
 type Network struct {
 	mu             sync.Mutex
-	reliable       bool
-	longDelays     bool                        // pause a long time on send on disabled connection
-	longReordering bool                        // sometimes delay replies a long time
-	servers        map[interface{}]*Server     // servers, by name
-	connections    map[interface{}]interface{} // endname -> servername
-	done           chan struct{} // closed when Network is cleaned up
-	count          int32         // total RPC count, for statistics
-	bytes          int64         // total bytes send, for statistics
+	servers        map[interface{}]*Server // servers, by name
+	done           chan struct{}           // closed when Network is cleaned up
+	closing        sync.Once
+	count          int32                   // total API requests, for statistics
+	bytes          int64                   // total bytes received, for statistics
+	options        *Options
+	persister      *Persister // nil when state is kept in memory only, or in a cluster
+	raft           *Raft      // nil when running as a single registry
+	conflicts      []*Conflict // recent registration conflicts, oldest first
+	conflictsAt    int         // raft log index of the last entry whose conflicts were recorded
+	graceDeadline  time.Time  // leases restored from disk do not expire before this
+	auditor        logging.Logger        // where writes are recorded; nil for the registry's log
+	auditFile      *logging.RotatingFile // nil unless Options.Auth names an audit file
+	replays        *auth.ReplayCache         // nonces of signed requests lately accepted
 }
 
 func (rn *Network) Cleanup() {
-	close(rn.done)
-}
-
-func (rn *Network) Reliable(yes bool) {
-	rn.mu.Lock()
-	defer rn.mu.Unlock()
-
-	rn.reliable = yes
-}
-
-func (rn *Network) LongReordering(yes bool) {
-	rn.mu.Lock()
-	defer rn.mu.Unlock()
-
-	rn.longReordering = yes
-}
-
-func (rn *Network) LongDelays(yes bool) {
-	rn.mu.Lock()
-	defer rn.mu.Unlock()
-
-	rn.longDelays = yes
-}
-
-func (rn *Network) readEndnameInfo(endname interface{}) (enabled bool,
-	servername interface{}, server *Server, reliable bool, longreordering bool,
-) {
-	rn.mu.Lock()
-	defer rn.mu.Unlock()
-
-	enabled = rn.enabled[endname]
-	servername = rn.connections[endname]
-	if servername != nil {
-		server = rn.servers[servername]
-	}
-	reliable = rn.reliable
-	longreordering = rn.longReordering
-	return
-}
-
-func (rn *Network) isServerDead(endname interface{}, servername interface{}, server *Server) bool {
-	rn.mu.Lock()
-	defer rn.mu.Unlock()
-
-	if rn.enabled[endname] == false || rn.servers[servername] != server {
-		return true
-	}
-	return false
-}
-
-func (rn *Network) processReq(req reqMsg) {
-	enabled, servername, server, reliable, longreordering := rn.readEndnameInfo(req.endname)
-
-	if enabled && servername != nil && server != nil {
-		if reliable == false {
-			// short delay
-			ms := (rand.Int() % 27)
-			time.Sleep(time.Duration(ms) * time.Millisecond)
-		}
-
-		if reliable == false && (rand.Int()%1000) < 100 {
-			// drop the request, return as if timeout
-			req.replyCh <- replyMsg{false, nil}
-			return
-		}
-
-		// execute the request (call the RPC handler).
-		// in a separate thread so that we can periodically check
-		// if the server has been killed and the RPC should get a
-		// failure reply.
-		ech := make(chan replyMsg)
-		go func() {
-			r := server.dispatch(req)
-			ech <- r
-		}()
-
-		// wait for handler to return,
-		// but stop waiting if DeleteServer() has been called,
-		// and return an error.
-		var reply replyMsg
-		replyOK := false
-		serverDead := false
-		for replyOK == false && serverDead == false {
-			select {
-			case reply = <-ech:
-				replyOK = true
-			case <-time.After(100 * time.Millisecond):
-				serverDead = rn.isServerDead(req.endname, servername, server)
-				if serverDead {
-					go func() {
-						<-ech // drain channel to let the goroutine created earlier terminate
-					}()
-				}
-			}
-		}
-
-		// do not reply if DeleteServer() has been called, i.e.
-		// the server has been killed. this is needed to avoid
-		// situation in which a client gets a positive reply
-		// to an Append, but the server persisted the update
-		// into the old Persister. config.go is careful to call
-		// DeleteServer() before superseding the Persister.
-		serverDead = rn.isServerDead(req.endname, servername, server)
-
-		if replyOK == false || serverDead == true {
-			// server was killed while we were waiting; return error.
-			req.replyCh <- replyMsg{false, nil}
-		} else if reliable == false && (rand.Int()%1000) < 100 {
-			// drop the reply, return as if timeout
-			req.replyCh <- replyMsg{false, nil}
-		} else if longreordering == true && rand.Intn(900) < 600 {
-			// delay the response for a while
-			ms := 200 + rand.Intn(1+rand.Intn(2000))
-			// Russ points out that this timer arrangement will decrease
-			// the number of goroutines, so that the race
-			// detector is less likely to get upset.
-			time.AfterFunc(time.Duration(ms)*time.Millisecond, func() {
-				atomic.AddInt64(&rn.bytes, int64(len(reply.reply)))
-				req.replyCh <- reply
-			})
-		} else {
-			atomic.AddInt64(&rn.bytes, int64(len(reply.reply)))
-			req.replyCh <- reply
-		}
-	} else {
-		// simulate no reply and eventual timeout.
-		ms := 0
-		if rn.longDelays {
-			// let Raft tests check that leader doesn't send
-			// RPCs synchronously.
-			ms = (rand.Int() % 7000)
-		} else {
-			// many kv tests require the client to try each
-			// server in fairly rapid succession.
-			ms = (rand.Int() % 100)
-		}
-		time.AfterFunc(time.Duration(ms)*time.Millisecond, func() {
-			req.replyCh <- replyMsg{false, nil}
-		})
-	}
-
-}
-
-// func (rn *Network) AddServer(servername interface{}, rs *Server) {
-// 	rn.mu.Lock()
-// 	defer rn.mu.Unlock()
-
-// 	rn.servers[servername] = rs
-// }
-
-// func (rn *Network) DeleteServer(servername interface{}) {
-// 	rn.mu.Lock()
-// 	defer rn.mu.Unlock()
-
-// 	rn.servers[servername] = nil
-// }
-
-// connect a ClientEnd to a server.
-// a ClientEnd can only be connected once in its lifetime.
-func (rn *Network) Connect(endname interface{}, servername interface{}) {
-	rn.mu.Lock()
-	defer rn.mu.Unlock()
-
-	rn.connections[endname] = servername
-}
-
-// enable/disable a ClientEnd.
-func (rn *Network) Enable(endname interface{}, enabled bool) {
-	rn.mu.Lock()
-	defer rn.mu.Unlock()
-
-	rn.enabled[endname] = enabled
+	rn.closing.Do(func() { close(rn.done) })
 }
 
 // get a server's count of incoming RPCs.
@@ -220,29 +58,120 @@ type Server struct {
 	server_ip      string
 	server_port    string
 	server_enabled bool
+	server_epoch   int64 // registration epoch, newer registrations win conflicts
 	lastActiveTime time.Time
+	count          int // registry RPCs received from this server
 	services       []*Service
 }
 
+// get the number of registry RPCs received from a server.
+func (rs *Server) GetCount() int {
+	return rs.count
+}
+
 type Service struct {
 	service_name    string
 	service_key     string
 	method_name     string
 	describtion     string
 	service_enabled bool
+	version         string
+	weight          int
+	zone            string
+	tags            map[string]string
+}
+
+func (service *Service) metadata() connect.Metadata {
+	return connect.Metadata{
+		Version:     service.version,
+		Weight:      service.weight,
+		Zone:        service.zone,
+		Tags:        service.tags,
+		Describtion: service.describtion,
+	}
+}
+
+func (service *Service) setMetadata(meta *connect.Metadata) {
+	if meta == nil {
+		meta = &connect.Metadata{}
+	}
+	service.version = meta.Version
+	service.weight = meta.Weight
+	service.zone = meta.Zone
+	service.tags = meta.Tags
+	service.describtion = meta.Describtion
+}
+
+
+// rebuild the catalogue from the data directory. every restored
+// server gets a fresh lease plus a grace period, so that it is not
+// reported dead before it has had a chance to heartbeat again.
+func (rn *Network) restore() error {
+	cmds, err := rn.persister.Restore()
+	if err != nil {
+		return err
+	}
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	for _, cmd := range cmds {
+		rn.recordConflicts(cmd)
+		rn.apply(cmd)
+	}
+	rn.solveConflictServices(true)
+	now := time.Now()
+	for _, server := range rn.servers {
+		server.lastActiveTime = now
+		server.server_enabled = true
+	}
+	rn.graceDeadline = now.Add(rn.options.Lease_grace)
+	return nil
 }
 
+// compact the log into a snapshot of the current catalogue.
+func (rn *Network) Sync() error {
+	if rn.persister == nil {
+		return nil
+	}
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
 
-func (rn *Network) Sync() {
+	return rn.persister.SaveSnapshot(rn.stateCommands())
+}
 
+func (rn *Network) snapshotLoop() {
+	for {
+		select {
+		case <-rn.done:
+			return
+		case <-time.After(rn.options.Snapshot_interval):
+		}
+		if rn.persister.LogSize() == 0 {
+			continue
+		}
+		if err := rn.Sync(); err != nil {
+			getLogger().Log(logging.Error, "registry: snapshot", logging.Err(err))
+		}
+	}
 }
 
+// disable servers whose lease has run out.
 func (rn *Network) checkTimeout() {
-	for {		
-		time.Sleep(200)
+	for {
+		select {
+		case <-rn.done:
+			return
+		case <-time.After(rn.options.Lease_timeout / 4):
+		}
 		rn.mu.Lock()
-		for _, server := range rn.servers {
-			server.server_enabled = false
+		now := time.Now()
+		if now.After(rn.graceDeadline) {
+			for _, server := range rn.servers {
+				if now.Sub(server.lastActiveTime) > rn.options.Lease_timeout {
+					server.server_enabled = false
+				}
+			}
 		}
 		rn.mu.Unlock()
 	}
@@ -281,8 +210,60 @@ func (rn *Network) checkServiceAtMostOne(serverName, serviceName, methodName str
 	return len(services) <= 1
 }
 
-func (rn *Network) solveConflictServices() {
-	
+// collapse duplicate entries of a method on one server according
+// to the conflict policy: the last one wins, the first one wins, or
+// the first one is kept with the key of a later one if it had none.
+func (rn *Network) solveConflictServices(isLocked bool) {
+	if !isLocked {
+		rn.mu.Lock()
+		defer rn.mu.Unlock()
+	}
+
+	for _, server := range rn.servers {
+		seen := map[[2]string]bool{}
+		for _, service := range server.services {
+			pair := [2]string{service.service_name, service.method_name}
+			if seen[pair] || rn.checkServiceAtMostOne(server.server_name, pair[0], pair[1], true) {
+				continue
+			}
+			seen[pair] = true
+			dups := []*Service{}
+			for _, s := range server.services {
+				if s.service_name == pair[0] && s.method_name == pair[1] {
+					dups = append(dups, s)
+				}
+			}
+			keep := dups[0]
+			resolution := "rejected"
+			switch rn.options.Conflict_policy {
+			case PolicyLastWriterWins:
+				keep = dups[len(dups)-1]
+				resolution = "replaced"
+			case PolicyMerge:
+				for _, s := range dups[1:] {
+					if keep.service_key == "" {
+						keep.service_key = s.service_key
+					}
+				}
+				resolution = "merged"
+			}
+			rn.recordConflict(rn.settle(&Conflict{
+				Kind:         ConflictDuplicateMethod,
+				Server_name:  server.server_name,
+				Service_name: pair[0],
+				Method_name:  pair[1],
+				Existing:     dups[0].service_key,
+				Incoming:     dups[len(dups)-1].service_key,
+			}, resolution))
+			services := []*Service{}
+			for _, s := range server.services {
+				if s == keep || s.service_name != pair[0] || s.method_name != pair[1] {
+					services = append(services, s)
+				}
+			}
+			server.services = services
+		}
+	}
 }
 
 func (rn *Network) checkService(serverName, serviceName, methodName string, isLocked bool) bool {
@@ -399,6 +380,24 @@ func (rn *Network) getServiceKey(serverName, serviceName, methodName string, isL
 	return service.service_key
 }
 
+func (rn *Network) getServiceIndexByName(serverName, serviceName, methodName string, isLocked bool) int {
+	if !isLocked {
+		rn.mu.Lock()
+		defer rn.mu.Unlock()
+	}
+
+	server, ok := rn.servers[serverName]
+	if !ok {
+		return -1
+	}
+	for i, service := range server.services {
+		if service.service_name == serviceName && service.method_name == methodName {
+			return i
+		}
+	}
+	return -1
+}
+
 func (rn *Network) getServiceIndex(serverName, key string, isLocked bool) int {
 	if !isLocked {
 		rn.mu.Lock()
@@ -434,72 +433,231 @@ func (rn *Network) deleteServiceAtIndex(serverName string, index int, isLocked b
 	return true
 }
 
-func (rn *Network) AddServer(serverName string) {
+// add a server, or move it to a new address. reports whether the
+// registration was merged with a live server of the same name.
+func (rn *Network) AddServer(serverName, serverIp, serverPort string, epoch int64) (bool, error) {
 	rn.mu.Lock()
-	defer rn.mu.Unlock()
+	server, ok := rn.servers[serverName]
+	if ok && server.server_ip == serverIp && server.server_port == serverPort && server.server_epoch == epoch {
+		rn.mu.Unlock()
+		return false, nil
+	}
+	merged, settled, err := rn.resolveServerConflict(serverName, serverIp, serverPort, epoch)
+	rn.mu.Unlock()
+	if err != nil {
+		rn.commitRefusal(err)
+		return false, err
+	}
 
-	_, ok := rn.servers[serverName]
-	if ok {
-		return
+	cmd := &Command{
+		Op:          OpAddServer,
+		Server_name: serverName,
+		Server_ip:   serverIp,
+		Server_port: serverPort,
+		Epoch:       epoch,
+	}
+	if settled != nil {
+		cmd.Conflicts = []*Conflict{settled}
 	}
-	rn.servers[serverName] = &Server{}
+	return merged, rn.commit(cmd)
 }
 
-func (rn *Network) DeleteServer(serverName string) {
+func (rn *Network) DeleteServer(serverName string) error {
 	rn.mu.Lock()
-	defer rn.mu.Unlock()
-
 	_, ok := rn.servers[serverName]
+	rn.mu.Unlock()
 	if !ok {
-		return
+		return nil
 	}
-	delete(rn.servers, serverName)
+
+	return rn.commit(&Command{Op: OpDeleteServer, Server_name: serverName})
 }
 
-func (rn *Network) AddService(serverName, serviceName, methodName string) {
+func (rn *Network) AddService(serverName, serviceName, methodName, serviceKey string, meta *connect.Metadata) error {
 	rn.mu.Lock()
-	defer rn.mu.Unlock()
-
-	server, ok := rn.servers[serverName]
+	_, ok := rn.servers[serverName]
 	if !ok {
-		return
+		rn.mu.Unlock()
+		return nil
 	}
-	ok = rn.checkService(serverName, serviceName, methodName, true)
-	if ok {
-		return
+	cmds, err := rn.resolveServiceConflicts(serverName, serviceName, methodName, serviceKey, meta)
+	rn.mu.Unlock()
+	if err != nil {
+		rn.commitRefusal(err)
+		return err
+	}
+
+	for _, cmd := range cmds {
+		if err := rn.commit(cmd); err != nil {
+			return err
+		}
 	}
-	server.services = append(server.services, &Service{})
+	return nil
 }
 
-func (rn *Network) DeleteService(serverName, serviceName, methodName string) {
+func (rn *Network) DeleteService(serverName, serviceName, methodName string) error {
 	rn.mu.Lock()
-	defer rn.mu.Unlock()
-	
 	_, ok := rn.servers[serverName]
 	if !ok {
-		return
+		rn.mu.Unlock()
+		return nil
 	}
 	ok = rn.checkService(serverName, serviceName, methodName, true)
+	rn.mu.Unlock()
 	if !ok {
-		return
+		return nil
 	}
-	serviceKey := rn.getServiceKey(serverName, serviceName, methodName, true)
-	index := rn.getServiceIndex(serverName, serviceKey, true)
-	_ = rn.deleteServiceAtIndex(serverName, index, true)
+
+	return rn.commit(&Command{
+		Op:           OpDeleteService,
+		Server_name:  serverName,
+		Service_name: serviceName,
+		Method_name:  methodName,
+	})
 }
 
-func (rn *Network) ReceiveHeartBeat() {
+// renew a server's lease. leases are not replicated: servers
+// heartbeat every registry they know of.
+func (rn *Network) ReceiveHeartBeat(serverName string) bool {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
 
+	server, ok := rn.servers[serverName]
+	if !ok {
+		return false
+	}
+	server.count += 1
+	rn.refreshTimeout(serverName, true)
+	return true
 }
 
-func (rn *Network) PullServices() {
+// every server offering serviceName.methodName whose metadata
+// matches sel. an empty methodName matches every method of the
+// service, a nil sel matches everything.
+func (rn *Network) PullService(serviceName, methodName string, sel *connect.Selector) []*connect.Endpoint {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
 
+	endpoints := []*connect.Endpoint{}
+	for _, server := range rn.servers {
+		for _, service := range server.services {
+			if service.service_name != serviceName {
+				continue
+			}
+			if methodName != "" && service.method_name != methodName {
+				continue
+			}
+			meta := service.metadata()
+			if !sel.Matches(&meta) {
+				continue
+			}
+			endpoints = append(endpoints, makeEndpoint(server, service))
+		}
+	}
+	return endpoints
 }
 
-func (rn *Network) PullService() {
+// the methods one server has registered.
+func (rn *Network) serverEndpoints(serverName string) []*connect.Endpoint {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
 
+	endpoints := []*connect.Endpoint{}
+	server, ok := rn.servers[serverName]
+	if !ok {
+		return endpoints
+	}
+	for _, service := range server.services {
+		endpoints = append(endpoints, &connect.Endpoint{
+			Server_name:  server.server_name,
+			Service_name: service.service_name,
+			Method_name:  service.method_name,
+		})
+	}
+	return endpoints
 }
 
-func (rn *Network) GetStatus() {
+// every server and method known to the registry.
+func (rn *Network) PullServices() []*connect.Endpoint {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	endpoints := []*connect.Endpoint{}
+	for _, server := range rn.servers {
+		for _, service := range server.services {
+			endpoints = append(endpoints, makeEndpoint(server, service))
+		}
+	}
+	return endpoints
+}
+
+func makeEndpoint(server *Server, service *Service) *connect.Endpoint {
+	return &connect.Endpoint{
+		Server_name:  server.server_name,
+		Server_ip:    server.server_ip,
+		Server_port:  server.server_port,
+		Service_name: service.service_name,
+		Method_name:  service.method_name,
+		Service_key:  service.service_key,
+		Enabled:      server.server_enabled && service.service_enabled,
+		Metadata:     service.metadata(),
+	}
+}
+
+// take a consistent snapshot of every server and its services,
+// for the status API and the dashboard.
+func (rn *Network) GetStatus() *Status {
+	status := &Status{
+		Time:       time.Now(),
+		TotalCount: rn.GetTotalCount(),
+		TotalBytes: rn.GetTotalBytes(),
+		Servers:    []*ServerStatus{},
+	}
+	if rn.raft != nil {
+		// ask raft before taking rn.mu; raft calls into the
+		// Network with its own lock held.
+		term, state, leader := rn.raft.GetState()
+		status.Cluster = &ClusterStatus{
+			Me:     rn.options.Me,
+			Role:   []string{"follower", "candidate", "leader"}[state],
+			Term:   term,
+			Leader: leader,
+			Peers:  rn.options.Peers,
+		}
+	}
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
 
+	for _, server := range rn.servers {
+		ss := &ServerStatus{
+			Name:           server.server_name,
+			Ip:             server.server_ip,
+			Port:           server.server_port,
+			Enabled:        server.server_enabled,
+			LastActiveTime: server.lastActiveTime,
+			Epoch:          server.server_epoch,
+			Count:          server.GetCount(),
+			Services:       []*ServiceStatus{},
+		}
+		for _, service := range server.services {
+			ss.Services = append(ss.Services, &ServiceStatus{
+				Name:        service.service_name,
+				Method:      service.method_name,
+				Key:         service.service_key,
+				Describtion: service.describtion,
+				Enabled:     service.service_enabled,
+				Version:     service.version,
+				Weight:      service.weight,
+				Zone:        service.zone,
+				Tags:        service.tags,
+			})
+		}
+		status.Servers = append(status.Servers, ss)
+	}
+	status.Conflicts = append([]*Conflict{}, rn.conflicts...)
+	sort.Slice(status.Servers, func(i, j int) bool {
+		return status.Servers[i].Name < status.Servers[j].Name
+	})
+	return status
 }
\ No newline at end of file
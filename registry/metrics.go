@@ -0,0 +1,54 @@
+package registry
+
+import (
+	"net/http"
+	"srpc/common/stats"
+)
+
+// the registry's state for Prometheus, on /metrics when
+// Options.Metrics is set.
+func metrics(w http.ResponseWriter, r *http.Request) {
+	st := rn.GetStatus()
+	w.Header().Set("Content-Type", stats.PromContentType)
+	p := stats.MakePromWriter(w)
+
+	p.Header("srpc_registry_api_requests_total", "counter", "Requests to the registry API.")
+	p.Sample("srpc_registry_api_requests_total", float64(st.TotalCount))
+	p.Header("srpc_registry_api_received_bytes_total", "counter", "Bytes received by the registry API.")
+	p.Sample("srpc_registry_api_received_bytes_total", float64(st.TotalBytes))
+
+	active, expired, endpoints := 0, 0, 0
+	for _, server := range st.Servers {
+		if server.Enabled {
+			active += 1
+		} else {
+			expired += 1
+		}
+		for _, service := range server.Services {
+			if server.Enabled && service.Enabled {
+				endpoints += 1
+			}
+		}
+	}
+	p.Header("srpc_registry_leases", "gauge", "Registered servers, by whether their lease is active.")
+	p.Sample("srpc_registry_leases", float64(active), "state", "active")
+	p.Sample("srpc_registry_leases", float64(expired), "state", "expired")
+	p.Header("srpc_registry_endpoints", "gauge", "Methods offered by servers with an active lease.")
+	p.Sample("srpc_registry_endpoints", float64(endpoints))
+
+	p.Header("srpc_registry_heartbeat_age_seconds", "gauge", "Time since each server's last heartbeat.")
+	for _, server := range st.Servers {
+		p.Sample("srpc_registry_heartbeat_age_seconds", server.HeartbeatAge(st.Time).Seconds(), "server", server.Name)
+	}
+
+	if st.Cluster != nil {
+		leader := 0.0
+		if st.Cluster.Role == "leader" {
+			leader = 1
+		}
+		p.Header("srpc_registry_raft_term", "gauge", "Current raft term of this member.")
+		p.Sample("srpc_registry_raft_term", float64(st.Cluster.Term))
+		p.Header("srpc_registry_raft_leader", "gauge", "Whether this member is the raft leader.")
+		p.Sample("srpc_registry_raft_leader", leader)
+	}
+}
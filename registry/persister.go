@@ -0,0 +1,174 @@
+package registry
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	walFile      = "wal.log"
+)
+
+// keeps the registry catalogue in a data directory as a snapshot
+// plus an append-only write-ahead log of the commands since then.
+type Persister struct {
+	mu    sync.Mutex
+	dir   string
+	wal   *os.File
+	index int // index of the last command written
+	count int // commands in the log since the last snapshot
+}
+
+type walRecord struct {
+	Index   int      `json:"index"`
+	Command *Command `json:"command"`
+}
+
+type snapshot struct {
+	Index    int        `json:"index"` // last command included
+	Commands []*Command `json:"commands"`
+}
+
+func MakePersister(dir string) (*Persister, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Persister{dir: dir}, nil
+}
+
+// read back the snapshot and the log, returning every command
+// needed to rebuild the catalogue, and open the log for appending.
+func (ps *Persister) Restore() ([]*Command, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	cmds := []*Command{}
+	snap := &snapshot{}
+	data, err := os.ReadFile(filepath.Join(ps.dir, snapshotFile))
+	if err == nil {
+		if err := json.Unmarshal(data, snap); err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, snap.Commands...)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	ps.index = snap.Index
+
+	f, err := os.OpenFile(filepath.Join(ps.dir, walFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// a torn record at the tail is a write that never completed;
+	// keep everything before it and cut the log there.
+	var valid int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		rec := &walRecord{}
+		if json.Unmarshal(line, rec) != nil || rec.Command == nil {
+			break
+		}
+		valid += int64(len(line))
+		if rec.Index <= snap.Index {
+			// already part of the snapshot.
+			continue
+		}
+		cmds = append(cmds, rec.Command)
+		ps.index = rec.Index
+		ps.count += 1
+	}
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(valid, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	ps.wal = f
+	return cmds, nil
+}
+
+// durably append a command to the log.
+func (ps *Persister) Append(cmd *Command) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	data, err := json.Marshal(&walRecord{Index: ps.index + 1, Command: cmd})
+	if err != nil {
+		return err
+	}
+	if _, err := ps.wal.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := ps.wal.Sync(); err != nil {
+		return err
+	}
+	ps.index += 1
+	ps.count += 1
+	return nil
+}
+
+// number of commands logged since the last snapshot.
+func (ps *Persister) LogSize() int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.count
+}
+
+// replace the snapshot with the given catalogue and empty the log.
+// the caller must not append concurrently, so that cmds covers
+// every command logged so far.
+func (ps *Persister) SaveSnapshot(cmds []*Command) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	data, err := json.Marshal(&snapshot{Index: ps.index, Commands: cmds})
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(ps.dir, snapshotFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	if err := os.Rename(tmp, filepath.Join(ps.dir, snapshotFile)); err != nil {
+		return err
+	}
+	// records up to ps.index are now covered by the snapshot, so a
+	// crash before the truncate only leaves records that are skipped.
+	if err := ps.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := ps.wal.Seek(0, 0); err != nil {
+		return err
+	}
+	ps.count = 0
+	return nil
+}
+
+func (ps *Persister) Close() error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.wal == nil {
+		return nil
+	}
+	return ps.wal.Close()
+}
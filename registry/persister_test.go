@@ -0,0 +1,183 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"srpc/common/auth"
+	"srpc/common/connect"
+	"strings"
+	"testing"
+)
+
+// a Network of its own, apart from the registry's, kept in dir
+// unless dir is "". no background loops run.
+func makeTestNetwork(t *testing.T, dir string) *Network {
+	n := &Network{
+		options: DefaultOptions(),
+		servers: map[interface{}]*Server{},
+		done:    make(chan struct{}),
+		replays: auth.MakeReplayCache(),
+	}
+	if dir != "" {
+		ps, err := MakePersister(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.persister = ps
+		t.Cleanup(func() { ps.Close() })
+		if err := n.restore(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return n
+}
+
+func restoreNames(t *testing.T, dir string) []string {
+	ps, err := MakePersister(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+	cmds, err := ps.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, cmd := range cmds {
+		names = append(names, cmd.Server_name)
+	}
+	return names
+}
+
+func appendNames(t *testing.T, ps *Persister, names ...string) {
+	for _, name := range names {
+		if err := ps.Append(&Command{Op: OpAddServer, Server_name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPersisterRestoresLog(t *testing.T) {
+	dir := t.TempDir()
+	ps, _ := MakePersister(dir)
+	if _, err := ps.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	appendNames(t, ps, "a", "b", "c")
+	ps.Close()
+
+	if got := strings.Join(restoreNames(t, dir), ","); got != "a,b,c" {
+		t.Fatalf("restored %q, want a,b,c", got)
+	}
+}
+
+func TestPersisterRestoresSnapshotAndLog(t *testing.T) {
+	dir := t.TempDir()
+	ps, _ := MakePersister(dir)
+	if _, err := ps.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	appendNames(t, ps, "a", "b")
+	snap := []*Command{{Op: OpAddServer, Server_name: "a"}, {Op: OpAddServer, Server_name: "b"}}
+	if err := ps.SaveSnapshot(snap); err != nil {
+		t.Fatal(err)
+	}
+	if n := ps.LogSize(); n != 0 {
+		t.Fatalf("log size %d after a snapshot", n)
+	}
+	appendNames(t, ps, "c")
+	ps.Close()
+
+	if got := strings.Join(restoreNames(t, dir), ","); got != "a,b,c" {
+		t.Fatalf("restored %q, want a,b,c", got)
+	}
+}
+
+func TestPersisterCutsTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	ps, _ := MakePersister(dir)
+	if _, err := ps.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	appendNames(t, ps, "a", "b")
+	ps.Close()
+
+	// a write cut short by a crash.
+	f, err := os.OpenFile(filepath.Join(dir, walFile), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"index":3,"command":{"op":"add_ser`)
+	f.Close()
+
+	ps, _ = MakePersister(dir)
+	cmds, err := ps.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmds) != 2 {
+		t.Fatalf("restored %d commands, want 2", len(cmds))
+	}
+	// what is appended next goes after the last whole record.
+	appendNames(t, ps, "c")
+	ps.Close()
+	if got := strings.Join(restoreNames(t, dir), ","); got != "a,b,c" {
+		t.Fatalf("restored %q, want a,b,c", got)
+	}
+}
+
+// the catalogue of n as "server/Service.Method" entries, sorted.
+func catalogue(n *Network) []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	entries := []string{}
+	for _, server := range n.servers {
+		for _, service := range server.services {
+			entries = append(entries, server.server_name+"/"+service.service_name+"."+service.method_name)
+		}
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+func TestNetworkRestoresCatalogue(t *testing.T) {
+	dir := t.TempDir()
+	n := makeTestNetwork(t, dir)
+	register := func(name string, methods ...string) {
+		reg := &connect.Registration{Server_name: name, Server_ip: "10.0.0.1", Server_port: name}
+		for _, m := range methods {
+			reg.Services = append(reg.Services, &connect.ServiceInfo{Service_name: "KV", Method_name: m})
+		}
+		if err := n.Register(reg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	register("s1", "Get", "Put")
+	register("s2", "Get")
+	if err := n.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	// after the snapshot, only in the log.
+	register("s1", "Get")
+	register("s3", "Put")
+	if err := n.DeleteServer("s2"); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join(catalogue(n), ",")
+	n.persister.Close()
+
+	restored := makeTestNetwork(t, dir)
+	if got := strings.Join(catalogue(restored), ","); got != want {
+		t.Fatalf("restored %q, want %q", got, want)
+	}
+	if want != "s1/KV.Get,s3/KV.Put" {
+		t.Fatalf("catalogue %q", want)
+	}
+}
+
+func TestNetworkCleanupTwice(t *testing.T) {
+	n := makeTestNetwork(t, "")
+	n.Cleanup()
+	n.Cleanup()
+}
@@ -0,0 +1,855 @@
+package registry
+
+// replication of the registry catalogue across a small cluster.
+// this is the raft of the labs, carried over HTTP/JSON instead of
+// labrpc: the log holds registry Commands, the leader serializes
+// every write, and each member applies committed commands to its
+// own Network so that reads can be served anywhere.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"srpc/common/auth"
+	"srpc/common/connect"
+	"srpc/common/logging"
+	"sync"
+	"time"
+)
+
+const (
+	Follower = iota
+	Candidate
+	Leader
+)
+
+const (
+	heartbeatInterval  = 100 * time.Millisecond
+	electionTimeoutMin = 400 * time.Millisecond
+	electionTimeoutMax = 800 * time.Millisecond
+	submitTimeout      = 2 * time.Second
+	maxRaftLog         = 1000 // entries kept before the log is compacted
+
+	raftStateFile    = "raft-state.json"
+	raftSnapshotFile = "raft-snapshot.json"
+)
+
+type LogEntry struct {
+	Term    int      `json:"term"`
+	Command *Command `json:"command"`
+}
+
+// returned by writes on a member that is not the leader.
+type NotLeaderError struct {
+	Leader string // empty while an election is in progress
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "registry: no leader elected"
+	}
+	return fmt.Sprintf("registry: not the leader, leader is %v", e.Leader)
+}
+
+// returned by Submit when this member, still the leader, could not
+// get the command committed in time, e.g. for want of a majority.
+// the command may yet be applied later.
+var ErrNotCommitted = errors.New("registry: write not committed in time")
+
+type Raft struct {
+	mu     sync.Mutex
+	me     string   // this member's address
+	peers  []string // the other members
+	dir    string   // where state is persisted, empty for memory only
+	client *http.Client
+	key    *connect.Key      // signs RPCs to peers; nil for unsigned RPCs
+	peerOK *auth.ReplayCache // nonces of peer RPCs lately accepted
+
+	// persistent state.
+	currentTerm       int
+	votedFor          string
+	log               []LogEntry // log[0] stands for the last entry in the snapshot
+	lastIncludedIndex int
+	snapshotCmds      []*Command // the catalogue as of lastIncludedIndex
+
+	// volatile state.
+	state       int
+	leader      string
+	commitIndex int
+	lastApplied int
+	lastHeard   time.Time
+	timeout     time.Duration
+	nextIndex   map[string]int
+	matchIndex  map[string]int
+	waiters     map[int]chan int // log index -> term of the entry applied there
+	pending     []*Command       // snapshot received from the leader, not yet installed
+	applyCond   *sync.Cond
+	done        chan struct{}
+	closing     sync.Once
+
+	// hooks into the state machine.
+	apply    func(index int, cmd *Command)
+	install  func(cmds []*Command)
+	snapshot func() []*Command
+}
+
+type RequestVoteArgs struct {
+	Term         int    `json:"term"`
+	CandidateId  string `json:"candidate_id"`
+	LastLogIndex int    `json:"last_log_index"`
+	LastLogTerm  int    `json:"last_log_term"`
+}
+
+type RequestVoteReply struct {
+	Term        int  `json:"term"`
+	VoteGranted bool `json:"vote_granted"`
+}
+
+type AppendEntriesArgs struct {
+	Term         int        `json:"term"`
+	LeaderId     string     `json:"leader_id"`
+	PrevLogIndex int        `json:"prev_log_index"`
+	PrevLogTerm  int        `json:"prev_log_term"`
+	Entries      []LogEntry `json:"entries"`
+	LeaderCommit int        `json:"leader_commit"`
+}
+
+type AppendEntriesReply struct {
+	Term          int  `json:"term"`
+	Success       bool `json:"success"`
+	ConflictIndex int  `json:"conflict_index"` // where the leader should retry from
+}
+
+type InstallSnapshotArgs struct {
+	Term              int        `json:"term"`
+	LeaderId          string     `json:"leader_id"`
+	LastIncludedIndex int        `json:"last_included_index"`
+	LastIncludedTerm  int        `json:"last_included_term"`
+	Commands          []*Command `json:"commands"`
+}
+
+type InstallSnapshotReply struct {
+	Term int `json:"term"`
+}
+
+type raftState struct {
+	CurrentTerm       int        `json:"current_term"`
+	VotedFor          string     `json:"voted_for"`
+	LastIncludedIndex int        `json:"last_included_index"`
+	Log               []LogEntry `json:"log"`
+}
+
+// clusterKey, shared by every member, signs RPCs between them;
+// "" leaves them unsigned, for clusters on a trusted network.
+func MakeRaft(me string, members []string, dir string, clusterKey string,
+	apply func(int, *Command), install func([]*Command), snapshot func() []*Command) (*Raft, error) {
+	rf := &Raft{
+		me:       me,
+		dir:      dir,
+		client:   &http.Client{Timeout: heartbeatInterval * 2},
+		peerOK:   auth.MakeReplayCache(),
+		log:      []LogEntry{{Term: 0}},
+		waiters:  map[int]chan int{},
+		done:     make(chan struct{}),
+		apply:    apply,
+		install:  install,
+		snapshot: snapshot,
+	}
+	for _, member := range members {
+		if member != me {
+			rf.peers = append(rf.peers, member)
+		}
+	}
+	if clusterKey != "" {
+		rf.key = &connect.Key{Id: me, Secret: clusterKey}
+	}
+	rf.applyCond = sync.NewCond(&rf.mu)
+	if err := rf.readPersist(); err != nil {
+		return nil, err
+	}
+	rf.resetElectionTimer()
+	go rf.ticker()
+	go rf.applier()
+	return rf, nil
+}
+
+// stop the member. it writes nothing to its directory after Kill
+// returns, though RPCs in flight may still finish.
+func (rf *Raft) Kill() {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.closing.Do(func() { close(rf.done) })
+	rf.applyCond.Broadcast()
+}
+
+func (rf *Raft) killed() bool {
+	select {
+	case <-rf.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// report this member's view of the cluster.
+func (rf *Raft) GetState() (term int, state int, leader string) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.currentTerm, rf.state, rf.leader
+}
+
+// append a command to the log and wait until it has been applied.
+// only the leader accepts commands.
+func (rf *Raft) Submit(cmd *Command) error {
+	rf.mu.Lock()
+	if rf.state != Leader {
+		leader := rf.leader
+		rf.mu.Unlock()
+		return &NotLeaderError{Leader: leader}
+	}
+	term := rf.currentTerm
+	rf.log = append(rf.log, LogEntry{Term: term, Command: cmd})
+	index := rf.lastIndex()
+	rf.matchIndex[rf.me] = index
+	ch := make(chan int, 1)
+	rf.waiters[index] = ch
+	rf.persist()
+	rf.advanceCommit()
+	rf.mu.Unlock()
+
+	rf.broadcastAppend()
+
+	select {
+	case applied := <-ch:
+		if applied != term {
+			// another leader's entry took this slot.
+			return &NotLeaderError{}
+		}
+		return nil
+	case <-time.After(submitTimeout):
+		rf.mu.Lock()
+		delete(rf.waiters, index)
+		leader := rf.leader
+		rf.mu.Unlock()
+		if leader == rf.me || leader == "" {
+			// sending the caller to ourselves would only loop.
+			return ErrNotCommitted
+		}
+		return &NotLeaderError{Leader: leader}
+	case <-rf.done:
+		return &NotLeaderError{}
+	}
+}
+
+//
+// log indexing. the caller holds rf.mu.
+//
+
+func (rf *Raft) lastIndex() int {
+	return rf.lastIncludedIndex + len(rf.log) - 1
+}
+
+func (rf *Raft) termAt(index int) int {
+	return rf.log[index-rf.lastIncludedIndex].Term
+}
+
+func (rf *Raft) entriesFrom(index int) []LogEntry {
+	entries := make([]LogEntry, len(rf.log[index-rf.lastIncludedIndex:]))
+	copy(entries, rf.log[index-rf.lastIncludedIndex:])
+	return entries
+}
+
+//
+// persistence. the caller holds rf.mu.
+//
+
+func (rf *Raft) persist() {
+	if rf.dir == "" || rf.killed() {
+		return
+	}
+	state := &raftState{
+		CurrentTerm:       rf.currentTerm,
+		VotedFor:          rf.votedFor,
+		LastIncludedIndex: rf.lastIncludedIndex,
+		Log:               rf.log,
+	}
+	if err := writeFileAtomic(filepath.Join(rf.dir, raftStateFile), state); err != nil {
+		fatal("registry: persist raft state", logging.Err(err))
+	}
+}
+
+func (rf *Raft) persistSnapshot(cmds []*Command) {
+	rf.snapshotCmds = cmds
+	if rf.dir == "" || rf.killed() {
+		return
+	}
+	snap := &snapshot{Index: rf.lastIncludedIndex, Commands: cmds}
+	if err := writeFileAtomic(filepath.Join(rf.dir, raftSnapshotFile), snap); err != nil {
+		fatal("registry: persist raft snapshot", logging.Err(err))
+	}
+	rf.persist()
+}
+
+func (rf *Raft) readPersist() error {
+	if rf.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(rf.dir, 0755); err != nil {
+		return err
+	}
+	snap := &snapshot{}
+	if ok, err := readFile(filepath.Join(rf.dir, raftSnapshotFile), snap); err != nil {
+		return err
+	} else if ok {
+		rf.install(snap.Commands)
+		rf.snapshotCmds = snap.Commands
+	}
+	state := &raftState{}
+	if ok, err := readFile(filepath.Join(rf.dir, raftStateFile), state); err != nil {
+		return err
+	} else if ok {
+		rf.currentTerm = state.CurrentTerm
+		rf.votedFor = state.VotedFor
+		rf.lastIncludedIndex = state.LastIncludedIndex
+		rf.log = state.Log
+	}
+	rf.commitIndex = rf.lastIncludedIndex
+	rf.lastApplied = rf.lastIncludedIndex
+	return nil
+}
+
+func writeFileAtomic(fname string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := fname + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	return os.Rename(tmp, fname)
+}
+
+func readFile(fname string, v interface{}) (bool, error) {
+	data, err := os.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+//
+// elections.
+//
+
+func (rf *Raft) resetElectionTimer() {
+	rf.lastHeard = time.Now()
+	spread := int64(electionTimeoutMax - electionTimeoutMin)
+	rf.timeout = electionTimeoutMin + time.Duration(rand.Int63n(spread))
+}
+
+func (rf *Raft) ticker() {
+	for !rf.killed() {
+		time.Sleep(heartbeatInterval / 2)
+		rf.mu.Lock()
+		if rf.state == Leader {
+			rf.mu.Unlock()
+			rf.broadcastAppend()
+			time.Sleep(heartbeatInterval / 2)
+			continue
+		}
+		if time.Since(rf.lastHeard) >= rf.timeout {
+			rf.startElection()
+		}
+		rf.mu.Unlock()
+	}
+}
+
+// the caller holds rf.mu.
+func (rf *Raft) startElection() {
+	rf.state = Candidate
+	rf.currentTerm += 1
+	rf.votedFor = rf.me
+	rf.leader = ""
+	rf.resetElectionTimer()
+	rf.persist()
+
+	args := &RequestVoteArgs{
+		Term:         rf.currentTerm,
+		CandidateId:  rf.me,
+		LastLogIndex: rf.lastIndex(),
+		LastLogTerm:  rf.termAt(rf.lastIndex()),
+	}
+	votes := 1
+	if votes > (len(rf.peers)+1)/2 {
+		rf.becomeLeader()
+		return
+	}
+	for _, peer := range rf.peers {
+		go func(peer string) {
+			reply := &RequestVoteReply{}
+			if !rf.call(peer, "/raft/vote", args, reply) {
+				return
+			}
+			rf.mu.Lock()
+			defer rf.mu.Unlock()
+			if reply.Term > rf.currentTerm {
+				rf.stepDown(reply.Term)
+				return
+			}
+			if rf.state != Candidate || rf.currentTerm != args.Term || !reply.VoteGranted {
+				return
+			}
+			votes += 1
+			if votes > (len(rf.peers)+1)/2 {
+				rf.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+// the caller holds rf.mu.
+func (rf *Raft) becomeLeader() {
+	rf.state = Leader
+	rf.leader = rf.me
+	rf.nextIndex = map[string]int{}
+	rf.matchIndex = map[string]int{}
+	for _, peer := range rf.peers {
+		rf.nextIndex[peer] = rf.lastIndex() + 1
+		rf.matchIndex[peer] = 0
+	}
+	// commit an entry of our own term, which also commits
+	// whatever earlier leaders left behind.
+	rf.log = append(rf.log, LogEntry{Term: rf.currentTerm, Command: &Command{Op: OpNoop}})
+	rf.matchIndex[rf.me] = rf.lastIndex()
+	rf.persist()
+	rf.advanceCommit()
+	getLogger().Log(logging.Info, "registry: became leader", logging.F("me", rf.me), logging.F("term", rf.currentTerm))
+	go rf.broadcastAppend()
+}
+
+// the caller holds rf.mu.
+func (rf *Raft) stepDown(term int) {
+	if term > rf.currentTerm {
+		rf.currentTerm = term
+		rf.votedFor = ""
+	}
+	rf.state = Follower
+	rf.persist()
+}
+
+func (rf *Raft) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if args.Term > rf.currentTerm {
+		rf.stepDown(args.Term)
+		rf.leader = ""
+	}
+	reply.Term = rf.currentTerm
+	if args.Term < rf.currentTerm {
+		return
+	}
+	if rf.votedFor != "" && rf.votedFor != args.CandidateId {
+		return
+	}
+	// only vote for candidates whose log is at least as up to date.
+	lastTerm := rf.termAt(rf.lastIndex())
+	if args.LastLogTerm < lastTerm ||
+		(args.LastLogTerm == lastTerm && args.LastLogIndex < rf.lastIndex()) {
+		return
+	}
+	rf.votedFor = args.CandidateId
+	rf.persist()
+	rf.resetElectionTimer()
+	reply.VoteGranted = true
+}
+
+//
+// replication.
+//
+
+func (rf *Raft) broadcastAppend() {
+	for _, peer := range rf.peers {
+		go rf.replicate(peer)
+	}
+}
+
+func (rf *Raft) replicate(peer string) {
+	rf.mu.Lock()
+	if rf.state != Leader {
+		rf.mu.Unlock()
+		return
+	}
+	next := rf.nextIndex[peer]
+	if next <= rf.lastIncludedIndex {
+		rf.mu.Unlock()
+		rf.sendSnapshot(peer)
+		return
+	}
+	args := &AppendEntriesArgs{
+		Term:         rf.currentTerm,
+		LeaderId:     rf.me,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  rf.termAt(next - 1),
+		Entries:      rf.entriesFrom(next),
+		LeaderCommit: rf.commitIndex,
+	}
+	rf.mu.Unlock()
+
+	reply := &AppendEntriesReply{}
+	if !rf.call(peer, "/raft/append", args, reply) {
+		return
+	}
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if reply.Term > rf.currentTerm {
+		rf.stepDown(reply.Term)
+		return
+	}
+	if rf.state != Leader || rf.currentTerm != args.Term {
+		return
+	}
+	if reply.Success {
+		match := args.PrevLogIndex + len(args.Entries)
+		if match > rf.matchIndex[peer] {
+			rf.matchIndex[peer] = match
+			rf.nextIndex[peer] = match + 1
+		}
+		rf.advanceCommit()
+	} else if reply.ConflictIndex > 0 {
+		rf.nextIndex[peer] = reply.ConflictIndex
+	}
+}
+
+// the caller holds rf.mu.
+func (rf *Raft) advanceCommit() {
+	for n := rf.lastIndex(); n > rf.commitIndex && n > rf.lastIncludedIndex; n-- {
+		if rf.termAt(n) != rf.currentTerm {
+			break
+		}
+		count := 0
+		for _, match := range rf.matchIndex {
+			if match >= n {
+				count += 1
+			}
+		}
+		if count > (len(rf.peers)+1)/2 {
+			rf.commitIndex = n
+			rf.applyCond.Broadcast()
+			return
+		}
+	}
+}
+
+func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	reply.Term = rf.currentTerm
+	if args.Term < rf.currentTerm {
+		return
+	}
+	if args.Term > rf.currentTerm || rf.state != Follower {
+		rf.stepDown(args.Term)
+	}
+	reply.Term = rf.currentTerm
+	rf.leader = args.LeaderId
+	rf.resetElectionTimer()
+
+	// entries already folded into our snapshot match by definition.
+	if args.PrevLogIndex < rf.lastIncludedIndex {
+		skip := rf.lastIncludedIndex - args.PrevLogIndex
+		if skip > len(args.Entries) {
+			skip = len(args.Entries)
+		}
+		args.Entries = args.Entries[skip:]
+		args.PrevLogIndex = rf.lastIncludedIndex
+		args.PrevLogTerm = rf.termAt(rf.lastIncludedIndex)
+	}
+	if args.PrevLogIndex > rf.lastIndex() {
+		reply.ConflictIndex = rf.lastIndex() + 1
+		return
+	}
+	if rf.termAt(args.PrevLogIndex) != args.PrevLogTerm {
+		// back up over the whole conflicting term at once.
+		term := rf.termAt(args.PrevLogIndex)
+		index := args.PrevLogIndex
+		for index > rf.lastIncludedIndex+1 && rf.termAt(index-1) == term {
+			index--
+		}
+		reply.ConflictIndex = index
+		return
+	}
+
+	// keep matching entries, so that a stale, reordered request
+	// cannot cut off entries a newer one already appended.
+	for i, entry := range args.Entries {
+		index := args.PrevLogIndex + 1 + i
+		if index <= rf.lastIndex() {
+			if rf.termAt(index) == entry.Term {
+				continue
+			}
+			rf.log = rf.log[:index-rf.lastIncludedIndex]
+		}
+		rf.log = append(rf.log, args.Entries[i:]...)
+		rf.persist()
+		break
+	}
+
+	last := args.PrevLogIndex + len(args.Entries)
+	if args.LeaderCommit > rf.commitIndex {
+		rf.commitIndex = args.LeaderCommit
+		if rf.commitIndex > last {
+			rf.commitIndex = last
+		}
+		rf.applyCond.Broadcast()
+	}
+	reply.Success = true
+}
+
+//
+// snapshots.
+//
+
+func (rf *Raft) sendSnapshot(peer string) {
+	rf.mu.Lock()
+	args := &InstallSnapshotArgs{
+		Term:              rf.currentTerm,
+		LeaderId:          rf.me,
+		LastIncludedIndex: rf.lastIncludedIndex,
+		LastIncludedTerm:  rf.termAt(rf.lastIncludedIndex),
+		Commands:          rf.snapshotCmds,
+	}
+	rf.mu.Unlock()
+
+	reply := &InstallSnapshotReply{}
+	if !rf.call(peer, "/raft/snapshot", args, reply) {
+		return
+	}
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if reply.Term > rf.currentTerm {
+		rf.stepDown(reply.Term)
+		return
+	}
+	if rf.state != Leader || rf.currentTerm != args.Term {
+		return
+	}
+	if args.LastIncludedIndex > rf.matchIndex[peer] {
+		rf.matchIndex[peer] = args.LastIncludedIndex
+		rf.nextIndex[peer] = args.LastIncludedIndex + 1
+	}
+}
+
+func (rf *Raft) InstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapshotReply) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	reply.Term = rf.currentTerm
+	if args.Term < rf.currentTerm {
+		return
+	}
+	if args.Term > rf.currentTerm || rf.state != Follower {
+		rf.stepDown(args.Term)
+	}
+	reply.Term = rf.currentTerm
+	rf.leader = args.LeaderId
+	rf.resetElectionTimer()
+
+	if args.LastIncludedIndex <= rf.lastApplied {
+		return
+	}
+	if args.LastIncludedIndex < rf.lastIndex() && rf.termAt(args.LastIncludedIndex) == args.LastIncludedTerm {
+		rf.log = rf.entriesFrom(args.LastIncludedIndex)
+	} else {
+		rf.log = []LogEntry{{Term: args.LastIncludedTerm}}
+	}
+	rf.lastIncludedIndex = args.LastIncludedIndex
+	rf.log[0].Command = nil
+	rf.commitIndex = args.LastIncludedIndex
+	rf.lastApplied = args.LastIncludedIndex
+	rf.persistSnapshot(args.Commands)
+	// the applier installs it, so that it never races with
+	// entries that are being applied.
+	rf.pending = args.Commands
+	rf.applyCond.Broadcast()
+}
+
+// fold applied entries into a snapshot once the log grows too long.
+func (rf *Raft) maybeCompact() {
+	rf.mu.Lock()
+	if len(rf.log) <= maxRaftLog {
+		rf.mu.Unlock()
+		return
+	}
+	rf.mu.Unlock()
+
+	// only the applier changes the catalogue, and it is the caller,
+	// so the snapshot reflects exactly lastApplied.
+	cmds := rf.snapshot()
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	index := rf.lastApplied
+	if index <= rf.lastIncludedIndex {
+		return
+	}
+	rf.log = rf.entriesFrom(index)
+	rf.log[0].Command = nil
+	rf.lastIncludedIndex = index
+	rf.persistSnapshot(cmds)
+}
+
+//
+// applying committed entries.
+//
+
+func (rf *Raft) applier() {
+	for {
+		rf.mu.Lock()
+		for rf.lastApplied >= rf.commitIndex && rf.pending == nil && !rf.killed() {
+			rf.applyCond.Wait()
+		}
+		if rf.killed() {
+			rf.mu.Unlock()
+			return
+		}
+		if rf.pending != nil {
+			cmds := rf.pending
+			rf.pending = nil
+			rf.mu.Unlock()
+			rf.install(cmds)
+			continue
+		}
+		start := rf.lastApplied + 1
+		entries := make([]LogEntry, rf.commitIndex-rf.lastApplied)
+		copy(entries, rf.log[start-rf.lastIncludedIndex:rf.commitIndex-rf.lastIncludedIndex+1])
+		rf.mu.Unlock()
+
+		// applying a command twice is harmless, so entries that a
+		// concurrently installed snapshot already covers are fine.
+		for i, entry := range entries {
+			rf.apply(start+i, entry.Command)
+
+			rf.mu.Lock()
+			index := start + i
+			if index > rf.lastApplied {
+				rf.lastApplied = index
+			}
+			if ch, ok := rf.waiters[index]; ok {
+				ch <- entry.Term
+				delete(rf.waiters, index)
+			}
+			rf.mu.Unlock()
+		}
+		rf.maybeCompact()
+	}
+}
+
+//
+// transport.
+//
+
+func (rf *Raft) call(peer, path string, args interface{}, reply interface{}) bool {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return false
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://"+peer+path, bytes.NewReader(data))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rf.key.Sign(req, data)
+	resp, err := rf.client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	return json.NewDecoder(resp.Body).Decode(reply) == nil
+}
+
+// read the body of an RPC from a peer into args, checking that a
+// member signed it with the cluster key, if there is one.
+func (rf *Raft) readRPC(w http.ResponseWriter, r *http.Request, args interface{}) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if rf.key != nil {
+		s, ok := signer(r)
+		if !ok || !rf.isPeer(s.id) || !s.by(r, body, rf.key.Secret) || !rf.peerOK.First(s.id, r.Header.Get(connect.NonceHeader)) {
+			http.Error(w, "registry: raft RPC not signed by a member", http.StatusUnauthorized)
+			return false
+		}
+	}
+	if err := json.Unmarshal(body, args); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (rf *Raft) isPeer(id string) bool {
+	for _, peer := range rf.peers {
+		if peer == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (rf *Raft) handleVote(w http.ResponseWriter, r *http.Request) {
+	args := &RequestVoteArgs{}
+	if !rf.readRPC(w, r, args) {
+		return
+	}
+	reply := &RequestVoteReply{}
+	rf.RequestVote(args, reply)
+	json.NewEncoder(w).Encode(reply)
+}
+
+func (rf *Raft) handleAppend(w http.ResponseWriter, r *http.Request) {
+	args := &AppendEntriesArgs{}
+	if !rf.readRPC(w, r, args) {
+		return
+	}
+	reply := &AppendEntriesReply{}
+	rf.AppendEntries(args, reply)
+	json.NewEncoder(w).Encode(reply)
+}
+
+func (rf *Raft) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	args := &InstallSnapshotArgs{}
+	if !rf.readRPC(w, r, args) {
+		return
+	}
+	reply := &InstallSnapshotReply{}
+	rf.InstallSnapshot(args, reply)
+	json.NewEncoder(w).Encode(reply)
+}
@@ -0,0 +1,294 @@
+package registry
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"srpc/common/connect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a raft cluster in one process, over HTTP on loopback. a member can
+// be cut off, which fails every RPC it sends or is sent.
+type testCluster struct {
+	t       *testing.T
+	members []*testMember
+	mu      sync.Mutex
+	cut     map[string]bool
+}
+
+type testMember struct {
+	addr    string
+	rf      *Raft
+	srv     *http.Server
+	mu      sync.Mutex
+	applied []*Command
+}
+
+func makeTestCluster(t *testing.T, n int, clusterKey string) *testCluster {
+	c := &testCluster{t: t, cut: map[string]bool{}}
+	listeners := []net.Listener{}
+	addrs := []string{}
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners = append(listeners, l)
+		addrs = append(addrs, l.Addr().String())
+	}
+	for i, addr := range addrs {
+		m := &testMember{addr: addr}
+		rf, err := MakeRaft(addr, addrs, t.TempDir(), clusterKey, m.apply, m.install, m.snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.rf = rf
+		mux := http.NewServeMux()
+		mux.HandleFunc("/raft/vote", c.link(addr, rf.handleVote))
+		mux.HandleFunc("/raft/append", c.link(addr, rf.handleAppend))
+		mux.HandleFunc("/raft/snapshot", c.link(addr, rf.handleSnapshot))
+		m.srv = &http.Server{Handler: mux}
+		go m.srv.Serve(listeners[i])
+		c.members = append(c.members, m)
+	}
+	t.Cleanup(func() {
+		for _, m := range c.members {
+			m.rf.Kill()
+			m.srv.Close()
+		}
+	})
+	return c
+}
+
+// pass RPCs to addr on to h unless either end is cut off. the
+// sender is known by the key it signed with.
+func (c *testCluster) link(addr string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		cut := c.cut[addr] || c.cut[r.Header.Get(connect.KeyIDHeader)]
+		c.mu.Unlock()
+		if cut {
+			http.Error(w, "cut off", http.StatusServiceUnavailable)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (c *testCluster) setCut(addr string, cut bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cut[addr] = cut
+}
+
+func (m *testMember) apply(index int, cmd *Command) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.applied = append(m.applied, cmd)
+}
+
+func (m *testMember) install(cmds []*Command) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.applied = append([]*Command{}, cmds...)
+}
+
+func (m *testMember) snapshot() []*Command {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Command{}, m.applied...)
+}
+
+// the server names of the commands m applied, noops left out.
+func (m *testMember) names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := []string{}
+	for _, cmd := range m.applied {
+		if cmd.Op != OpNoop {
+			names = append(names, cmd.Server_name)
+		}
+	}
+	return names
+}
+
+func waitFor(t *testing.T, d time.Duration, what string, ok func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(d)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// the member every member not cut off takes for leader, nil while
+// they do not agree on one.
+func (c *testCluster) leader() *testMember {
+	var leader *testMember
+	agreed := ""
+	for _, m := range c.members {
+		c.mu.Lock()
+		cut := c.cut[m.addr]
+		c.mu.Unlock()
+		if cut {
+			continue
+		}
+		_, state, l := m.rf.GetState()
+		if l == "" || (agreed != "" && l != agreed) {
+			return nil
+		}
+		agreed = l
+		if state == Leader {
+			leader = m
+		}
+	}
+	if leader == nil || leader.addr != agreed {
+		return nil
+	}
+	return leader
+}
+
+func (c *testCluster) waitLeader() *testMember {
+	var leader *testMember
+	waitFor(c.t, 5*time.Second, "a leader", func() bool {
+		leader = c.leader()
+		return leader != nil
+	})
+	return leader
+}
+
+func TestRaftElectsOneLeader(t *testing.T) {
+	c := makeTestCluster(t, 3, "cluster")
+	first := c.waitLeader()
+	firstTerm, _, _ := first.rf.GetState()
+
+	c.setCut(first.addr, true)
+	second := c.waitLeader()
+	if second == first {
+		t.Fatal("the cut off leader is still leader")
+	}
+	if term, _, _ := second.rf.GetState(); term <= firstTerm {
+		t.Fatalf("new leader in term %d, not after %d", term, firstTerm)
+	}
+
+	// the old leader learns of the new term once it is back.
+	c.setCut(first.addr, false)
+	waitFor(t, 5*time.Second, "the old leader to follow", func() bool {
+		_, state, leader := first.rf.GetState()
+		return state == Follower && leader == second.addr
+	})
+}
+
+func TestRaftReplicatesInOrder(t *testing.T) {
+	c := makeTestCluster(t, 3, "cluster")
+	leader := c.waitLeader()
+	want := []string{}
+	for i := 0; i < 5; i++ {
+		name := "s" + strconv.Itoa(i)
+		if err := leader.rf.Submit(&Command{Op: OpAddServer, Server_name: name}); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, name)
+	}
+	for _, m := range c.members {
+		m := m
+		waitFor(t, 5*time.Second, "replication to "+m.addr, func() bool {
+			return strings.Join(m.names(), ",") == strings.Join(want, ",")
+		})
+	}
+
+	for _, m := range c.members {
+		if m == leader {
+			continue
+		}
+		var notLeader *NotLeaderError
+		err := m.rf.Submit(&Command{Op: OpAddServer, Server_name: "x"})
+		if !errors.As(err, &notLeader) || notLeader.Leader != leader.addr {
+			t.Fatalf("submit to a follower: %v, want the leader %v", err, leader.addr)
+		}
+	}
+}
+
+func TestRaftCatchesUpAfterPartition(t *testing.T) {
+	c := makeTestCluster(t, 3, "cluster")
+	leader := c.waitLeader()
+	var behind *testMember
+	for _, m := range c.members {
+		if m != leader {
+			behind = m
+			break
+		}
+	}
+	c.setCut(behind.addr, true)
+	for i := 0; i < 3; i++ {
+		if err := leader.rf.Submit(&Command{Op: OpAddServer, Server_name: "s" + strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(behind.names()); n != 0 {
+		t.Fatalf("cut off member applied %d commands", n)
+	}
+	c.setCut(behind.addr, false)
+	waitFor(t, 5*time.Second, "the member to catch up", func() bool {
+		return strings.Join(behind.names(), ",") == "s0,s1,s2"
+	})
+}
+
+func TestRaftSubmitWithoutMajority(t *testing.T) {
+	c := makeTestCluster(t, 3, "cluster")
+	leader := c.waitLeader()
+	for _, m := range c.members {
+		if m != leader {
+			c.setCut(m.addr, true)
+		}
+	}
+	err := leader.rf.Submit(&Command{Op: OpAddServer, Server_name: "s"})
+	if !errors.Is(err, ErrNotCommitted) {
+		t.Fatalf("submit without a majority: %v, want ErrNotCommitted", err)
+	}
+}
+
+func TestRaftRefusesUnsignedRPCs(t *testing.T) {
+	c := makeTestCluster(t, 3, "cluster")
+	m := c.members[0]
+	send := func(key *connect.Key) int {
+		body := []byte(`{"term":1000,"candidate_id":"x"}`)
+		r := httptest.NewRequest(http.MethodPost, "/raft/vote", strings.NewReader(string(body)))
+		key.Sign(r, body)
+		w := httptest.NewRecorder()
+		m.rf.handleVote(w, r)
+		return w.Code
+	}
+	if code := send(nil); code != http.StatusUnauthorized {
+		t.Fatalf("unsigned vote request: %d", code)
+	}
+	if code := send(&connect.Key{Id: "127.0.0.1:1", Secret: "cluster"}); code != http.StatusUnauthorized {
+		t.Fatalf("vote request from a stranger: %d", code)
+	}
+	if code := send(&connect.Key{Id: c.members[1].addr, Secret: "guess"}); code != http.StatusUnauthorized {
+		t.Fatalf("vote request under the wrong key: %d", code)
+	}
+	if code := send(&connect.Key{Id: c.members[1].addr, Secret: "cluster"}); code != http.StatusOK {
+		t.Fatalf("vote request from a member: %d", code)
+	}
+	if term, _, _ := m.rf.GetState(); term != 1000 {
+		t.Fatalf("term %d after a member's vote request for term 1000", term)
+	}
+}
+
+func TestRaftKillTwice(t *testing.T) {
+	c := makeTestCluster(t, 1, "")
+	c.members[0].rf.Kill()
+	// and again when the cluster is cleaned up.
+	if !c.members[0].rf.killed() {
+		t.Fatal("not killed")
+	}
+}
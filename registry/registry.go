@@ -1,51 +1,145 @@
 package registry
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"srpc/common/auth"
+	"srpc/common/logging"
+	"sync"
+	"time"
 )
 
 var rn *Network
 
+var loggerMu sync.Mutex
+var logger logging.Logger
+
+// where the registry logs; nil for logging.Default.
+func SetLogger(l logging.Logger) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	logger = l
+}
+
+func getLogger() logging.Logger {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	return logging.Or(logger)
+}
+
+// log at error level and exit, as log.Fatalf would.
+func fatal(msg string, fields ...logging.Field) {
+	getLogger().Log(logging.Error, msg, fields...)
+	os.Exit(1)
+}
+
 func MakeNetwork() {
+	if err := makeNetwork(DefaultOptions()); err != nil {
+		fatal("registry: make network", logging.Err(err))
+	}
+}
+
+func MakeNetworkFromConfig(fName string) error {
+	config, err := NewConfig(fName, &JSONConfigFormat{})
+	if err != nil {
+		return err
+	}
+	return makeNetwork(config.Options)
+}
+
+func MakeNetworkFromConfigText(text string) error {
+	config, err := NewConfigFromText(text, &JSONConfigFormat{})
+	if err != nil {
+		return err
+	}
+	return makeNetwork(config.Options)
+}
+
+func makeNetwork(options *Options) error {
+	if !validPolicy(options.Conflict_policy) {
+		return fmt.Errorf("registry: unknown conflict policy %q", options.Conflict_policy)
+	}
 	rn = &Network{}
-	rn.reliable = true
-	rn.ends = map[interface{}]*ClientEnd{}
-	rn.enabled = map[interface{}]bool{}
+	rn.options = options
 	rn.servers = map[interface{}]*Server{}
-	rn.connections = map[interface{}](interface{}){}
-	rn.endCh = make(chan reqMsg)
 	rn.done = make(chan struct{})
+	rn.replays = auth.MakeReplayCache()
+	if err := rn.openAudit(); err != nil {
+		return err
+	}
 
-	// single goroutine to handle all ClientEnd.Call()s
-	go func() {
-		for {
-			select {
-			case xreq := <-rn.endCh:
-				atomic.AddInt32(&rn.count, 1)
-				atomic.AddInt64(&rn.bytes, int64(len(xreq.args)))
-				go rn.processReq(xreq)
-			case <-rn.done:
-				return
-			}
+	if len(options.Peers) > 0 {
+		// in a cluster the raft log takes the place of the
+		// write-ahead log.
+		if options.Auth != nil && options.Cluster_key == "" {
+			return errors.New("registry: a cluster that checks signatures needs a cluster key for its members")
+		}
+		rf, err := MakeRaft(options.Me, options.Peers, options.Data_dir, options.Cluster_key,
+			rn.applyCommitted, rn.installSnapshot, rn.takeSnapshot)
+		if err != nil {
+			return err
+		}
+		rn.raft = rf
+		rn.graceDeadline = time.Now().Add(options.Lease_grace)
+	} else if options.Data_dir != "" {
+		ps, err := MakePersister(options.Data_dir)
+		if err != nil {
+			return err
+		}
+		rn.persister = ps
+		if err := rn.restore(); err != nil {
+			ps.Close()
+			return err
 		}
-	}()
+		go rn.snapshotLoop()
+	}
+	go rn.checkTimeout()
+	return nil
 }
 
 func Run() {
 	if rn == nil {
 		MakeNetwork()
 	}
-	http.HandleFunc("/", index)
-	http.ListenAndServe(":8080", nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", rn.guarded(dashboard))
+	mux.HandleFunc("/api/status", rn.guarded(status))
+	mux.HandleFunc("/api/register", rn.counted(register))
+	mux.HandleFunc("/api/unregister", rn.counted(unregister))
+	mux.HandleFunc("/api/heartbeat", rn.counted(heartbeat))
+	mux.HandleFunc("/api/services", rn.counted(rn.guarded(services)))
+	if rn.options.Metrics {
+		mux.HandleFunc("/metrics", rn.guarded(metrics))
+	}
+	if rn.raft != nil {
+		mux.HandleFunc("/raft/vote", rn.raft.handleVote)
+		mux.HandleFunc("/raft/append", rn.raft.handleAppend)
+		mux.HandleFunc("/raft/snapshot", rn.raft.handleSnapshot)
+	}
+	if err := http.ListenAndServe(":"+rn.options.Registry_port, mux); err != nil {
+		fatal("registry: serve", logging.F("port", rn.options.Registry_port), logging.Err(err))
+	}
 }
 
+// stop background work and flush the catalogue to disk.
 func Close() {
-
+	if rn == nil {
+		return
+	}
+	if rn.raft != nil {
+		rn.raft.Kill()
+	}
+	if rn.persister != nil {
+		if err := rn.Sync(); err != nil {
+			getLogger().Log(logging.Error, "registry: snapshot on close", logging.Err(err))
+		}
+		rn.persister.Close()
+	}
+	if rn.auditFile != nil {
+		rn.auditFile.Close()
+	}
+	rn.Cleanup()
 }
 
-
-func index(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintln(w, "Hello World")
-  }
-  
\ No newline at end of file
@@ -0,0 +1,56 @@
+package registry
+
+import (
+	"time"
+)
+
+// a point-in-time view of the registry, served as JSON
+// by the status API and rendered by the dashboard.
+type Status struct {
+	Time       time.Time       `json:"time"`
+	TotalCount int             `json:"total_count"`
+	TotalBytes int64           `json:"total_bytes"`
+	Cluster    *ClusterStatus  `json:"cluster,omitempty"` // nil for a single registry
+	Servers    []*ServerStatus `json:"servers"`
+	Conflicts  []*Conflict     `json:"conflicts"` // recent, oldest first
+}
+
+// this member's view of the registry cluster.
+type ClusterStatus struct {
+	Me     string   `json:"me"`
+	Role   string   `json:"role"`
+	Term   int      `json:"term"`
+	Leader string   `json:"leader"`
+	Peers  []string `json:"peers"`
+}
+
+type ServerStatus struct {
+	Name           string           `json:"server_name"`
+	Ip             string           `json:"server_ip"`
+	Port           string           `json:"server_port"`
+	Enabled        bool             `json:"enabled"`
+	Epoch          int64            `json:"epoch"`
+	LastActiveTime time.Time        `json:"last_active_time"`
+	Count          int              `json:"count"`
+	Services       []*ServiceStatus `json:"services"`
+}
+
+type ServiceStatus struct {
+	Name        string            `json:"service_name"`
+	Method      string            `json:"method_name"`
+	Key         string            `json:"service_key"`
+	Describtion string            `json:"describtion"`
+	Enabled     bool              `json:"enabled"`
+	Version     string            `json:"version"`
+	Weight      int               `json:"weight"`
+	Zone        string            `json:"zone"`
+	Tags        map[string]string `json:"tags"`
+}
+
+// time since the server's last heartbeat, relative to the snapshot.
+func (ss *ServerStatus) HeartbeatAge(now time.Time) time.Duration {
+	if ss.LastActiveTime.IsZero() {
+		return 0
+	}
+	return now.Sub(ss.LastActiveTime).Truncate(time.Millisecond)
+}
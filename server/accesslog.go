@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"srpc/common/logging"
+	"strings"
+	"sync"
+	"time"
+)
+
+// one record per dispatched call, for audit and debugging.
+
+const (
+	// peer endname principal [time] "Service.Method" code request_bytes reply_bytes latency_seconds
+	AccessLogText = "text"
+	// one JSON AccessRecord per line.
+	AccessLogJSON = "json"
+)
+
+// where and how Serve writes the access log.
+type AccessLogConfig struct {
+	Path        string
+	Format      string // AccessLogText or AccessLogJSON
+	Max_bytes   int64  // rotate when the file would grow past this; 0 for never
+	Max_backups int    // rotated files to keep
+}
+
+type AccessRecord struct {
+	Time          time.Time `json:"time"` // when the call came in
+	Peer          string    `json:"peer"`
+	Endname       string    `json:"endname"`
+	Principal     string    `json:"principal"`
+	Service       string    `json:"service"`
+	Method        string    `json:"method"`
+	Code          string    `json:"code"`
+	Request_bytes int       `json:"request_bytes"`
+	Reply_bytes   int       `json:"reply_bytes"`
+	Latency       float64   `json:"latency"` // seconds
+}
+
+type AccessLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+}
+
+func MakeAccessLog(w io.Writer, format string) (*AccessLog, error) {
+	if format == "" {
+		format = AccessLogText
+	}
+	if format != AccessLogText && format != AccessLogJSON {
+		return nil, fmt.Errorf("srpc server: unknown access log format %q", format)
+	}
+	return &AccessLog{w: w, format: format}, nil
+}
+
+// an access log written to a rotating file, as conf says.
+func OpenAccessLog(conf *AccessLogConfig) (*AccessLog, error) {
+	f, err := logging.OpenRotatingFile(conf.Path, conf.Max_bytes, conf.Max_backups)
+	if err != nil {
+		return nil, err
+	}
+	a, err := MakeAccessLog(f, conf.Format)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AccessLog) Write(rec *AccessRecord) error {
+	var line []byte
+	if a.format == AccessLogJSON {
+		b, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		line = append(b, '\n')
+	} else {
+		svcMeth := rec.Method
+		if rec.Service != "" {
+			svcMeth = rec.Service + "." + rec.Method
+		}
+		line = []byte(fmt.Sprintf("%s %s %s [%s] %q %s %d %d %.6f\n",
+			orDash(rec.Peer), orDash(rec.Endname), orDash(rec.Principal), rec.Time.Format("02/Jan/2006:15:04:05.000 -0700"),
+			svcMeth, rec.Code, rec.Request_bytes, rec.Reply_bytes, rec.Latency))
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err := a.w.Write(line)
+	return err
+}
+
+// a field of a text line: "-" for nothing, and no spaces.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.Join(strings.Fields(s), "_")
+}
+
+// close the file under the log, if it has one.
+func (a *AccessLog) Close() error {
+	if c, ok := a.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"srpc/common/protocol"
+	"srpc/common/service"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLogFormats(t *testing.T) {
+	rec := &AccessRecord{
+		Time:          time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC),
+		Peer:          "10.0.0.1:5000",
+		Principal:     "billing team",
+		Service:       "KV",
+		Method:        "Get",
+		Code:          "OK",
+		Request_bytes: 12,
+		Reply_bytes:   34,
+		Latency:       0.0015,
+	}
+	b := &bytes.Buffer{}
+	a, _ := MakeAccessLog(b, "")
+	a.Write(rec)
+	want := `10.0.0.1:5000 - billing_team [01/Mar/2024:12:30:00.000 +0000] "KV.Get" OK 12 34 0.001500` + "\n"
+	if b.String() != want {
+		t.Fatalf("text record %q, want %q", b.String(), want)
+	}
+
+	b.Reset()
+	a, _ = MakeAccessLog(b, AccessLogJSON)
+	a.Write(rec)
+	got := &AccessRecord{}
+	if err := json.Unmarshal(b.Bytes(), got); err != nil {
+		t.Fatal(err)
+	}
+	if *got != *rec {
+		t.Fatalf("JSON record %+v, want %+v", got, rec)
+	}
+
+	if _, err := MakeAccessLog(b, "xml"); err == nil {
+		t.Fatal("unknown format accepted")
+	}
+}
+
+func TestServerWritesAccessLog(t *testing.T) {
+	rs, _ := MakeServer()
+	rs.AddService(service.MakeService(&KV{}))
+	b := &bytes.Buffer{}
+	a, _ := MakeAccessLog(b, AccessLogJSON)
+	rs.SetAccessLog(a)
+	rs.Dispatch(protocol.ReqMsg{SvcMeth: "KV.Get", Endname: "e1", Peer: "10.0.0.1:5000"})
+	rs.Dispatch(protocol.ReqMsg{SvcMeth: "KV.Nope"})
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d records for 2 calls: %q", len(lines), b.String())
+	}
+	recs := []AccessRecord{}
+	for _, line := range lines {
+		rec := AccessRecord{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	if r := recs[0]; r.Service != "KV" || r.Method != "Get" || r.Endname != "e1" || r.Peer != "10.0.0.1:5000" ||
+		r.Code != protocol.OK.String() {
+		t.Fatalf("record of KV.Get: %+v", r)
+	}
+	if r := recs[1]; r.Code == protocol.OK.String() {
+		t.Fatalf("record of an unknown method: %+v", r)
+	}
+}
+
+func TestAccessLogRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	a, err := OpenAccessLog(&AccessLogConfig{Path: path, Max_bytes: 200, Max_backups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, _ := MakeServer()
+	rs.AddService(service.MakeService(&KV{}))
+	rs.SetAccessLog(a)
+	for i := 0; i < 20; i++ {
+		rs.Dispatch(protocol.ReqMsg{SvcMeth: "KV.Get"})
+	}
+	a.Close()
+
+	records := 0
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) > 200 {
+			t.Fatalf("%v grew to %d bytes", filepath.Base(p), len(b))
+		}
+		records += strings.Count(string(b), "\n")
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("kept more backups than asked")
+	}
+	if records == 0 || records >= 20 {
+		t.Fatalf("%d records kept of 20, want the latest few", records)
+	}
+}
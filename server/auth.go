@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"srpc/common/auth"
+	"srpc/common/logging"
+	"srpc/common/protocol"
+)
+
+// who may call what. with neither an authenticator nor a policy
+// anyone may call anything, as before.
+type AuthConfig struct {
+	Tokens      map[string]string // shared token -> principal
+	Hmac_keys   map[string]string // key id -> secret, for signed requests
+	Mtls        bool              // the client certificate's name is a principal
+	Policy      *auth.Policy      // nil to let any authenticated caller call anything
+	Policy_file string            // a policy kept in a file, read again when it changes
+}
+
+// the Authenticator conf describes, or nil if it names none.
+func (conf *AuthConfig) authenticator() auth.Authenticator {
+	chain := auth.Chain{}
+	if conf.Mtls {
+		chain = append(chain, auth.TLSAuth{})
+	}
+	if len(conf.Tokens) > 0 {
+		chain = append(chain, &auth.TokenAuth{Tokens: conf.Tokens})
+	}
+	if len(conf.Hmac_keys) > 0 {
+		keys := map[string][]byte{}
+		for id, secret := range conf.Hmac_keys {
+			keys[id] = []byte(secret)
+		}
+		chain = append(chain, &auth.HMACAuth{Keys: keys})
+	}
+	if len(chain) == 0 {
+		return nil
+	}
+	return chain
+}
+
+// find out who calls the server with a. nil lets anyone call,
+// subject to the policy.
+func (rs *Server) SetAuthenticator(a auth.Authenticator) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.authn = a
+}
+
+// grant methods to principals as p says; p may be an *auth.Policy
+// or an *auth.PolicyFile to follow changes to a file. nil lets
+// every authenticated caller call anything.
+func (rs *Server) SetPolicy(p auth.PolicySource) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.policy = p
+}
+
+// set up authentication and authorization from the config, unless
+// they were set already.
+func (rs *Server) useAuth() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.registry == nil || rs.registry.Auth == nil {
+		return nil
+	}
+	conf := rs.registry.Auth
+	if rs.authn == nil {
+		rs.authn = conf.authenticator()
+	}
+	if rs.policy == nil {
+		if conf.Policy_file != "" {
+			l := logging.Or(rs.logger)
+			pf, err := auth.OpenPolicyFile(conf.Policy_file, func(err error) {
+				l.Log(logging.Error, "srpc server: reload policy, keeping the old one",
+					logging.F("path", conf.Policy_file), logging.Err(err))
+			})
+			if err != nil {
+				return err
+			}
+			rs.policy = pf
+		} else if conf.Policy != nil {
+			rs.policy = conf.Policy
+		}
+	}
+	return nil
+}
+
+// find out who sent req and whether they may call its method,
+// filling in req.Principal.
+func (rs *Server) authorize(req *protocol.ReqMsg) error {
+	rs.mu.Lock()
+	authn, source := rs.authn, rs.policy
+	rs.mu.Unlock()
+	if authn == nil && source == nil {
+		return nil
+	}
+
+	if authn != nil {
+		principal, err := authn.Authenticate(req)
+		if err != nil && !errors.Is(err, auth.ErrNoCredentials) {
+			return err
+		}
+		req.Principal = principal
+	}
+	if source == nil {
+		if req.Principal == "" {
+			return auth.ErrNoCredentials
+		}
+		return nil
+	}
+	if !source.Policy().Allowed(req.Principal, req.SvcMeth) {
+		if req.Principal == "" {
+			return auth.ErrNoCredentials
+		}
+		return protocol.Errorf(protocol.PermissionDenied, "srpc: %v may not call %v", req.Principal, req.SvcMeth)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"srpc/common/auth"
+	"srpc/common/protocol"
+	"srpc/common/service"
+	"testing"
+)
+
+func TestAuthorizeCalls(t *testing.T) {
+	rs, _ := MakeServer()
+	rs.AddService(service.MakeService(&KV{}))
+	rs.SetAuthenticator(&auth.HMACAuth{Keys: map[string][]byte{"billing": []byte("secret")}})
+	rs.SetPolicy(&auth.Policy{Rules: []*auth.Rule{{Principals: []string{"billing"}, Methods: []string{"KV.Get"}}}})
+
+	call := func(c auth.Credentials, svcMeth string) protocol.Code {
+		req := protocol.ReqMsg{SvcMeth: svcMeth, Meta: map[string]string{}}
+		if c != nil {
+			c.Apply(svcMeth, req.Args, req.Meta)
+		}
+		return rs.Dispatch(req).Code
+	}
+	billing := &auth.HMACCredentials{Key_id: "billing", Secret: []byte("secret")}
+	cases := []struct {
+		name    string
+		c       auth.Credentials
+		svcMeth string
+		want    protocol.Code
+	}{
+		{"granted", billing, "KV.Get", protocol.OK},
+		{"not granted", billing, "KV.Put", protocol.PermissionDenied},
+		{"no credentials", nil, "KV.Get", protocol.Unauthenticated},
+		{"wrong secret", &auth.HMACCredentials{Key_id: "billing", Secret: []byte("guess")}, "KV.Get",
+			protocol.Unauthenticated},
+	}
+	for _, c := range cases {
+		if code := call(c.c, c.svcMeth); code != c.want {
+			t.Errorf("%v: %v, want %v", c.name, code, c.want)
+		}
+	}
+}
+
+func TestAuthenticateWithoutPolicy(t *testing.T) {
+	rs, _ := MakeServer()
+	rs.AddService(service.MakeService(&KV{}))
+	rs.SetAuthenticator(&auth.TokenAuth{Tokens: map[string]string{"t0k3n": "alice"}})
+
+	req := protocol.ReqMsg{SvcMeth: "KV.Get", Meta: map[string]string{}}
+	(&auth.TokenCredentials{Token: "t0k3n"}).Apply("KV.Get", nil, req.Meta)
+	if code := rs.Dispatch(req).Code; code != protocol.OK {
+		t.Fatalf("authenticated call: %v", code)
+	}
+	// with no policy any authenticated caller may call, and no one else.
+	if code := rs.Dispatch(protocol.ReqMsg{SvcMeth: "KV.Get"}).Code; code != protocol.Unauthenticated {
+		t.Fatalf("anonymous call: %v", code)
+	}
+}
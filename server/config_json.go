@@ -2,23 +2,136 @@ package server
 
 import (
 	"os"
+	"net"
 	"strings"
+	"time"
 	"encoding/json"
+	"srpc/common/auth"
+	"srpc/common/connect"
+	"srpc/common/tlsconf"
 )
 
 type JSONConfigFormat struct {
-	Configuation_name    string `json:"configuation_name"`
-	Configuration_key    string `json:"configuation_key"`
-	Configuation_version string `json:"configuation_version"`
-	Registry_ip          string `json:"registry_ip"`
-	Registry_port        string `json:"registry_port"`
+	Configuation_name    string                 `json:"configuation_name"`
+	Configuration_key    string                 `json:"configuation_key"`
+	Configuation_version string                 `json:"configuation_version"`
+	Registry_ip          string                 `json:"registry_ip"`
+	Registry_port        string                 `json:"registry_port"`
+	Registry_addrs       []string               `json:"registry_addrs"`
+	Server_name          string                 `json:"server_name"`
+	Server_ip            string                 `json:"server_ip"`
+	Server_port          string                 `json:"server_port"`
+	Services             []*connect.ServiceInfo `json:"services"` // metadata to register services with
+	Metrics_addr         string                 `json:"metrics_addr"`
+	Access_log           *struct {
+		Path        string `json:"path"`
+		Format      string `json:"format"` // "text" or "json"
+		Max_size_mb int    `json:"max_size_mb"`
+		Max_backups int    `json:"max_backups"`
+	} `json:"access_log"`
+	Tls                  *tlsconf.Config `json:"tls"` // cert_file, key_file, ca_file, min_version, client_auth
+	Auth                 *struct {
+		Tokens      map[string]string `json:"tokens"`    // token -> principal
+		Hmac_keys   map[string]string `json:"hmac_keys"` // key id -> secret
+		Mtls        bool              `json:"mtls"`
+		Policy      *auth.Policy      `json:"policy"`
+		Policy_file string            `json:"policy_file"`
+	} `json:"auth"`
+	Limits               *struct {
+		Max_connections   int            `json:"max_connections"`
+		Max_concurrent    int            `json:"max_concurrent"`
+		Max_per_method    map[string]int `json:"max_per_method"` // "Service.Method" or "Service" -> calls at once
+		Rate              float64        `json:"rate"`           // calls per second per client
+		Burst             int            `json:"burst"`
+		Max_request_bytes int            `json:"max_request_bytes"`
+	} `json:"limits"`
+	Shedding             *struct {
+		Target_ms     int `json:"target_ms"`
+		Interval_ms   int `json:"interval_ms"`
+		Max_queue     int `json:"max_queue"`
+		Min_limit     int `json:"min_limit"`
+		Max_limit     int `json:"max_limit"`
+		Initial_limit int `json:"initial_limit"`
+		Max_priority  int            `json:"max_priority"`
+		Priorities    map[string]int `json:"priorities"` // principal -> the most its calls may ask for
+	} `json:"shedding"`
+	Pool                 *struct {
+		Workers  int  `json:"workers"`
+		Queue    int  `json:"queue"`
+		Isolate  bool `json:"isolate"` // a pool per service
+		Services map[string]struct {
+			Workers int `json:"workers"`
+			Queue   int `json:"queue"`
+		} `json:"services"` // service name -> a pool of its own
+	} `json:"pool"`
 }
 
 func (c *JSONConfigFormat) TransferToRegistry() *Registry {
 	r := &Registry {
 		Registry_ip: c.Registry_ip,
 		Registry_port: c.Registry_port,
+		Registry_addrs: c.Registry_addrs,
 		Registry_enabled: true,
+		Server_name: c.Server_name,
+		Server_ip: c.Server_ip,
+		Server_port: c.Server_port,
+		Services: c.Services,
+		Metrics_addr: c.Metrics_addr,
+		Registry_key: c.Configuration_key,
+		Tls: c.Tls,
+	}
+	if c.Auth != nil {
+		r.Auth = &AuthConfig{
+			Tokens:      c.Auth.Tokens,
+			Hmac_keys:   c.Auth.Hmac_keys,
+			Mtls:        c.Auth.Mtls,
+			Policy:      c.Auth.Policy,
+			Policy_file: c.Auth.Policy_file,
+		}
+	}
+	if c.Limits != nil {
+		r.Limits = &Limits{
+			Max_connections:   c.Limits.Max_connections,
+			Max_concurrent:    c.Limits.Max_concurrent,
+			Max_per_method:    c.Limits.Max_per_method,
+			Rate:              c.Limits.Rate,
+			Burst:             c.Limits.Burst,
+			Max_request_bytes: c.Limits.Max_request_bytes,
+		}
+	}
+	if c.Shedding != nil {
+		r.Shedding = &SheddingConfig{
+			Target:        time.Duration(c.Shedding.Target_ms) * time.Millisecond,
+			Interval:      time.Duration(c.Shedding.Interval_ms) * time.Millisecond,
+			Max_queue:     c.Shedding.Max_queue,
+			Min_limit:     c.Shedding.Min_limit,
+			Max_limit:     c.Shedding.Max_limit,
+			Initial_limit: c.Shedding.Initial_limit,
+			Max_priority:  c.Shedding.Max_priority,
+			Priorities:    c.Shedding.Priorities,
+		}
+	}
+	if c.Pool != nil {
+		r.Pool = &PoolConfig{
+			Workers:  c.Pool.Workers,
+			Queue:    c.Pool.Queue,
+			Isolate:  c.Pool.Isolate,
+			Services: map[string]*PoolSize{},
+		}
+		for name, size := range c.Pool.Services {
+			r.Pool.Services[name] = &PoolSize{Workers: size.Workers, Queue: size.Queue}
+		}
+	}
+	if c.Access_log != nil && c.Access_log.Path != "" {
+		r.Access_log = &AccessLogConfig{
+			Path:        c.Access_log.Path,
+			Format:      c.Access_log.Format,
+			Max_bytes:   int64(c.Access_log.Max_size_mb) << 20,
+			Max_backups: c.Access_log.Max_backups,
+		}
+	}
+	if c.Registry_ip != "" {
+		r.Registry_addrs = append([]string{net.JoinHostPort(c.Registry_ip, c.Registry_port)}, r.Registry_addrs...)
 	}
 	return r
 }
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"srpc/common/protocol"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how much a server takes on before it turns work away. a call over
+// a limit fails with protocol.ResourceExhausted; a connection over
+// Max_connections is closed as soon as it is accepted. zero means
+// no limit.
+type Limits struct {
+	Max_connections   int
+	Max_concurrent    int            // calls in the server at once, over all methods
+	Max_per_method    map[string]int // "Service.Method" or "Service" -> calls at once
+	Rate              float64        // calls per second per client, refilling its bucket
+	Burst             int            // calls a client may make at once; 0 for max(1, Rate)
+	Max_request_bytes int
+}
+
+// a client unheard of for this long loses its bucket, which is
+// then full again.
+const idleBucket = 1 * time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// the state behind Limits.
+type limiter struct {
+	mu       sync.Mutex
+	limits   Limits
+	inFlight int
+	methods  map[string]int // calls at once, by Max_per_method key
+	buckets  map[string]*tokenBucket
+	swept    time.Time
+}
+
+func makeLimiter(l *Limits) *limiter {
+	return &limiter{
+		limits:  *l,
+		methods: map[string]int{},
+		buckets: map[string]*tokenBucket{},
+		swept:   time.Now(),
+	}
+}
+
+// the Max_per_method key that bounds svcMeth, if any: the method's
+// own entry wins over its service's.
+func (lm *limiter) methodKey(svcMeth string) (string, int) {
+	if n, ok := lm.limits.Max_per_method[svcMeth]; ok {
+		return svcMeth, n
+	}
+	if dot := strings.LastIndex(svcMeth, "."); dot >= 0 {
+		if n, ok := lm.limits.Max_per_method[svcMeth[:dot]]; ok {
+			return svcMeth[:dot], n
+		}
+	}
+	return "", 0
+}
+
+// admit a call of svcMeth from client, or say which limit it is
+// over. the caller calls release once an admitted call is done.
+func (lm *limiter) acquire(svcMeth string, client string) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if lm.limits.Max_concurrent > 0 && lm.inFlight >= lm.limits.Max_concurrent {
+		return protocol.Errorf(protocol.ResourceExhausted, "srpc: %d calls in flight", lm.inFlight)
+	}
+	key, max := lm.methodKey(svcMeth)
+	if max > 0 && lm.methods[key] >= max {
+		return protocol.Errorf(protocol.ResourceExhausted, "srpc: %d calls of %v in flight", lm.methods[key], key)
+	}
+	if lm.limits.Rate > 0 && !lm.take(client) {
+		return protocol.Errorf(protocol.ResourceExhausted, "srpc: %v is over %v calls per second", client,
+			lm.limits.Rate)
+	}
+	lm.inFlight++
+	if key != "" {
+		lm.methods[key]++
+	}
+	return nil
+}
+
+func (lm *limiter) release(svcMeth string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.inFlight--
+	if key, _ := lm.methodKey(svcMeth); key != "" {
+		lm.methods[key]--
+	}
+}
+
+// take a token from client's bucket. the caller holds lm.mu.
+func (lm *limiter) take(client string) bool {
+	now := time.Now()
+	burst := float64(lm.limits.Burst)
+	if burst <= 0 {
+		burst = lm.limits.Rate
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	if now.Sub(lm.swept) > idleBucket {
+		for c, b := range lm.buckets {
+			if now.Sub(b.last) > idleBucket {
+				delete(lm.buckets, c)
+			}
+		}
+		lm.swept = now
+	}
+	b, ok := lm.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		lm.buckets[client] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * lm.limits.Rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// who a rate limit applies to: the authenticated principal, else
+// the peer's host, else the client end's name.
+func clientOf(req *protocol.ReqMsg) string {
+	if req.Principal != "" {
+		return "principal:" + req.Principal
+	}
+	if req.Peer != "" {
+		if host, _, err := net.SplitHostPort(req.Peer); err == nil {
+			return host
+		}
+		return req.Peer
+	}
+	return fmt.Sprint(req.Endname)
+}
+
+// hold the server to l from now on; nil lifts every limit. calls
+// already admitted are not counted against the new limits.
+func (rs *Server) SetLimits(l *Limits) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if l == nil {
+		rs.limiter = nil
+		return
+	}
+	rs.limiter = makeLimiter(l)
+}
+
+// set up limits from the config, unless they were set already.
+// the caller holds rs.mu.
+func (rs *Server) useLimits() {
+	if rs.limiter == nil && rs.registry != nil && rs.registry.Limits != nil {
+		rs.limiter = makeLimiter(rs.registry.Limits)
+	}
+}
+
+// admit req, waiting for its turn if the server sheds load, or
+// return why not. done is to be called once an admitted call is over.
+// the limiter is asked only once the shedder lets the call through,
+// so that calls waiting in the shedder's queue hold no limiter slot.
+func (rs *Server) admit(req *protocol.ReqMsg) (done func(), err error) {
+	rs.mu.Lock()
+	lm, sh := rs.limiter, rs.shedder
+	rs.mu.Unlock()
+	if lm != nil {
+		if max := lm.limits.Max_request_bytes; max > 0 && len(req.Args) > max {
+			return nil, protocol.Errorf(protocol.ResourceExhausted, "srpc: request of %d bytes, over %d",
+				len(req.Args), max)
+		}
+	}
+	done = func() {}
+	if sh != nil {
+		if err := sh.acquire(sh.priorityOf(req), req.Received); err != nil {
+			return nil, err
+		}
+		done = sh.release
+	}
+	if lm != nil {
+		if err := lm.acquire(req.SvcMeth, clientOf(req)); err != nil {
+			done()
+			return nil, err
+		}
+		unshed := done
+		done = func() {
+			lm.release(req.SvcMeth)
+			unshed()
+		}
+	}
+	return done, nil
+}
+
+// room in a request frame for all but the args: the method, the
+// metadata and gob's own bookkeeping.
+const frameSlack = 64 << 10
+
+// the largest request frame the server reads off a connection, 0 for
+// any. a frame within it may still carry args over Max_request_bytes,
+// which admit then turns away.
+func (rs *Server) frameLimit() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.limiter == nil || rs.limiter.limits.Max_request_bytes <= 0 {
+		return 0
+	}
+	return rs.limiter.limits.Max_request_bytes + frameSlack
+}
+
+// whether another connection may be served. the caller holds rs.mu.
+func (rs *Server) connectionAllowed() bool {
+	return rs.limiter == nil || rs.limiter.limits.Max_connections <= 0 ||
+		len(rs.conns) < rs.limiter.limits.Max_connections
+}
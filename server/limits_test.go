@@ -0,0 +1,99 @@
+package server
+
+import (
+	"srpc/common/protocol"
+	"testing"
+	"time"
+)
+
+func TestLimiterHoldsConcurrency(t *testing.T) {
+	lm := makeLimiter(&Limits{
+		Max_concurrent: 3,
+		Max_per_method: map[string]int{"KV.Put": 1, "KV": 2},
+	})
+	if err := lm.acquire("KV.Put", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := lm.acquire("KV.Put", "c"); protocol.CodeOf(err) != protocol.ResourceExhausted {
+		t.Fatalf("second KV.Put: %v", err)
+	}
+	// KV.Get falls under the service's own bound.
+	for i := 0; i < 2; i++ {
+		if err := lm.acquire("KV.Get", "c"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lm.acquire("Log.Append", "c"); protocol.CodeOf(err) != protocol.ResourceExhausted {
+		t.Fatalf("fourth call in flight: %v", err)
+	}
+	lm.release("KV.Get")
+	if err := lm.acquire("KV.Get", "c"); err != nil {
+		t.Fatalf("after a release: %v", err)
+	}
+	lm.release("KV.Put")
+	if err := lm.acquire("KV.Put", "c"); err != nil {
+		t.Fatalf("after KV.Put's release: %v", err)
+	}
+}
+
+func TestLimiterRatesEachClient(t *testing.T) {
+	lm := makeLimiter(&Limits{Rate: 20, Burst: 2})
+	for i := 0; i < 2; i++ {
+		if err := lm.acquire("KV.Get", "a"); err != nil {
+			t.Fatalf("call %d within the burst: %v", i, err)
+		}
+		lm.release("KV.Get")
+	}
+	if err := lm.acquire("KV.Get", "a"); protocol.CodeOf(err) != protocol.ResourceExhausted {
+		t.Fatalf("call past the burst: %v", err)
+	}
+	// another client has a bucket of its own.
+	if err := lm.acquire("KV.Get", "b"); err != nil {
+		t.Fatal(err)
+	}
+	lm.release("KV.Get")
+	// a token comes back every 50ms.
+	time.Sleep(80 * time.Millisecond)
+	if err := lm.acquire("KV.Get", "a"); err != nil {
+		t.Fatalf("after the bucket refilled: %v", err)
+	}
+}
+
+func TestClientOf(t *testing.T) {
+	cases := []struct {
+		req  *protocol.ReqMsg
+		want string
+	}{
+		{&protocol.ReqMsg{Principal: "alice", Peer: "10.0.0.1:5000"}, "principal:alice"},
+		{&protocol.ReqMsg{Peer: "10.0.0.1:5000"}, "10.0.0.1"},
+		{&protocol.ReqMsg{Endname: "end-1"}, "end-1"},
+	}
+	for _, c := range cases {
+		if got := clientOf(c.req); got != c.want {
+			t.Errorf("clientOf = %q, want %q", got, c.want)
+		}
+	}
+}
+
+func TestAdmitTurnsAwayLargeRequests(t *testing.T) {
+	rs, err := MakeServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.SetLimits(&Limits{Max_request_bytes: 8})
+	if limit := rs.frameLimit(); limit != 8+frameSlack {
+		t.Fatalf("frame limit %d", limit)
+	}
+	if _, err := rs.admit(&protocol.ReqMsg{SvcMeth: "KV.Put", Args: make([]byte, 9)}); protocol.CodeOf(err) != protocol.ResourceExhausted {
+		t.Fatalf("9 bytes: %v", err)
+	}
+	done, err := rs.admit(&protocol.ReqMsg{SvcMeth: "KV.Put", Args: make([]byte, 8)})
+	if err != nil {
+		t.Fatalf("8 bytes: %v", err)
+	}
+	done()
+	rs.SetLimits(nil)
+	if limit := rs.frameLimit(); limit != 0 {
+		t.Fatalf("frame limit %d with no limits", limit)
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"srpc/common/stats"
+)
+
+// serve the server's stats to Prometheus. Serve does so on
+// Metrics_addr by itself; this is for mounting them elsewhere.
+func (rs *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := rs.Stats()
+		w.Header().Set("Content-Type", stats.PromContentType)
+		p := stats.MakePromWriter(w)
+		s.WritePrometheus(p, "srpc_server")
+		p.Header("srpc_server_in_flight", "gauge", "Calls being handled.")
+		p.Sample("srpc_server_in_flight", float64(s.In_flight))
+		p.Header("srpc_server_connections", "gauge", "Open client connections.")
+		p.Sample("srpc_server_connections", float64(s.Connections))
+		if s.Shedding != nil {
+			p.Header("srpc_server_concurrency_limit", "gauge", "Calls dispatched at once, as load shedding finds best.")
+			p.Sample("srpc_server_concurrency_limit", float64(s.Shedding.Limit))
+			p.Header("srpc_server_queued", "gauge", "Calls waiting to be dispatched.")
+			p.Sample("srpc_server_queued", float64(s.Shedding.Queued))
+			p.Header("srpc_server_shed_total", "counter", "Calls shed under overload.")
+			p.Sample("srpc_server_shed_total", float64(s.Shedding.Shed))
+		}
+		if s.Pools != nil {
+			names := poolNames(s.Pools)
+			p.Header("srpc_server_pool_workers", "gauge", "Workers of a dispatch pool.")
+			for _, name := range names {
+				p.Sample("srpc_server_pool_workers", float64(s.Pools[name].Workers), "pool", name)
+			}
+			p.Header("srpc_server_pool_busy", "gauge", "Workers running a call.")
+			for _, name := range names {
+				p.Sample("srpc_server_pool_busy", float64(s.Pools[name].Busy), "pool", name)
+			}
+			p.Header("srpc_server_pool_queued", "gauge", "Calls waiting for a worker.")
+			for _, name := range names {
+				p.Sample("srpc_server_pool_queued", float64(s.Pools[name].Queued), "pool", name)
+			}
+			p.Header("srpc_server_pool_rejected_total", "counter", "Calls that found the queue full.")
+			for _, name := range names {
+				p.Sample("srpc_server_pool_rejected_total", float64(s.Pools[name].Rejected), "pool", name)
+			}
+			p.Header("srpc_server_pool_wait_seconds", "histogram", "Time calls waited for a worker.")
+			for _, name := range names {
+				p.Histogram("srpc_server_pool_wait_seconds", &s.Pools[name].Wait, "pool", name)
+			}
+		}
+	})
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"sort"
+	"srpc/common/protocol"
+	"srpc/common/stats"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dispatch by worker pool. instead of a goroutine per call, calls
+// wait in a bounded queue for one of a fixed number of workers, and
+// a call that finds the queue full fails at once with
+// protocol.ResourceExhausted. a service may have a pool of its own,
+// so that calls to a slow service cannot keep workers from the rest.
+type PoolConfig struct {
+	Workers  int                  // workers of the shared pool; 0 for defaultWorkers
+	Queue    int                  // calls waiting for them at most; 0 for 4 times Workers
+	Isolate  bool                 // give every service a pool of its own, sized as the shared one
+	Services map[string]*PoolSize // service name -> a pool of its own
+}
+
+type PoolSize struct {
+	Workers int
+	Queue   int
+}
+
+const defaultWorkers = 64
+
+// what Stats calls the pool of services without one of their own.
+const SharedPool = "shared"
+
+type PoolStats struct {
+	Workers   int
+	Busy      int // workers running a call
+	Queued    int // calls waiting for a worker
+	Max_queue int
+	Rejected  int64           // calls that found the queue full
+	Wait      stats.Histogram // how long calls waited for a worker
+}
+
+var errPoolFull = protocol.NewError(protocol.ResourceExhausted, "srpc: server busy, call queue full")
+
+type job struct {
+	queued time.Time
+	run    func()
+}
+
+type pool struct {
+	mu       sync.Mutex
+	workers  int
+	queue    chan *job
+	stopped  bool
+	busy     int
+	rejected int64
+	wait     stats.Histogram
+}
+
+func makePool(size PoolSize) *pool {
+	if size.Workers <= 0 {
+		size.Workers = defaultWorkers
+	}
+	if size.Queue <= 0 {
+		size.Queue = 4 * size.Workers
+	}
+	p := &pool{
+		workers: size.Workers,
+		queue:   make(chan *job, size.Queue),
+		wait:    stats.MakeHistogram(),
+	}
+	for i := 0; i < size.Workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *pool) work() {
+	for j := range p.queue {
+		p.mu.Lock()
+		p.busy++
+		p.wait.Add(time.Since(j.queued))
+		p.mu.Unlock()
+		j.run()
+		p.mu.Lock()
+		p.busy--
+		p.mu.Unlock()
+	}
+}
+
+// queue run for a worker, or return errPoolFull if there is no room.
+func (p *pool) submit(run func()) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return errPoolFull
+	}
+	select {
+	case p.queue <- &job{queued: time.Now(), run: run}:
+		return nil
+	default:
+		p.rejected++
+		return errPoolFull
+	}
+}
+
+// let the workers finish what is queued, then exit.
+func (p *pool) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.queue)
+	}
+}
+
+func (p *pool) stats() *PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return &PoolStats{
+		Workers:   p.workers,
+		Busy:      p.busy,
+		Queued:    len(p.queue),
+		Max_queue: cap(p.queue),
+		Rejected:  p.rejected,
+		Wait:      p.wait.Copy(),
+	}
+}
+
+func (p *pool) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rejected = 0
+	p.wait = stats.MakeHistogram()
+}
+
+// the pools of a server, made as PoolConfig says.
+type pools struct {
+	mu       sync.Mutex
+	conf     PoolConfig
+	shared   *pool
+	services map[string]*pool // those of services with their own, made on first use
+}
+
+func makePools(conf *PoolConfig) *pools {
+	ps := &pools{conf: *conf, services: map[string]*pool{}}
+	ps.shared = makePool(PoolSize{Workers: conf.Workers, Queue: conf.Queue})
+	return ps
+}
+
+// the pool for calls of serviceName. only services the server
+// knows get pools of their own, so that calls naming made-up
+// services cannot make the server start workers.
+func (ps *pools) get(serviceName string, known bool) *pool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if p, ok := ps.services[serviceName]; ok {
+		return p
+	}
+	size, own := ps.conf.Services[serviceName]
+	if !known || (!own && !ps.conf.Isolate) {
+		return ps.shared
+	}
+	if !own {
+		size = &PoolSize{Workers: ps.conf.Workers, Queue: ps.conf.Queue}
+	}
+	p := makePool(*size)
+	ps.services[serviceName] = p
+	return p
+}
+
+func (ps *pools) stop() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.shared.stop()
+	for _, p := range ps.services {
+		p.stop()
+	}
+}
+
+func (ps *pools) stats() map[string]*PoolStats {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	st := map[string]*PoolStats{SharedPool: ps.shared.stats()}
+	for name, p := range ps.services {
+		st[name] = p.stats()
+	}
+	return st
+}
+
+func (ps *pools) reset() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.shared.reset()
+	for _, p := range ps.services {
+		p.reset()
+	}
+}
+
+// dispatch calls with worker pools as conf says; nil goes back to
+// a goroutine per call. calls queued in the old pools still run.
+func (rs *Server) SetPool(conf *PoolConfig) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.pools != nil {
+		rs.pools.stop()
+		rs.pools = nil
+	}
+	if conf != nil {
+		rs.pools = makePools(conf)
+	}
+}
+
+// set up pools from the config, unless they were set already.
+// the caller holds rs.mu.
+func (rs *Server) usePool() {
+	if rs.pools == nil && rs.registry != nil && rs.registry.Pool != nil {
+		rs.pools = makePools(rs.registry.Pool)
+	}
+}
+
+// the pool calls of svcMeth run on; nil if the server has none, in
+// which case each call runs on its own goroutine.
+func (rs *Server) pool(svcMeth string) *pool {
+	serviceName := svcMeth
+	if dot := strings.LastIndex(svcMeth, "."); dot >= 0 {
+		serviceName = svcMeth[:dot]
+	}
+	rs.mu.Lock()
+	ps := rs.pools
+	_, known := rs.services[serviceName]
+	rs.mu.Unlock()
+	if ps == nil {
+		return nil
+	}
+	return ps.get(serviceName, known)
+}
+
+// the names of pools in st, shared first, for writing them in order.
+func poolNames(st map[string]*PoolStats) []string {
+	names := []string{}
+	for name := range st {
+		if name != SharedPool {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append([]string{SharedPool}, names...)
+}
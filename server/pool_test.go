@@ -0,0 +1,166 @@
+package server
+
+import (
+	"srpc/common/protocol"
+	"srpc/common/service"
+	"testing"
+	"time"
+)
+
+// a service whose calls wait until release is closed.
+type Slow struct {
+	release chan struct{}
+}
+
+func (s *Slow) Wait(n int, reply *int) {
+	<-s.release
+	*reply = n
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("never %v", what)
+}
+
+func TestPoolQueuesAndRejects(t *testing.T) {
+	p := makePool(PoolSize{Workers: 1, Queue: 1})
+	defer p.stop()
+	release := make(chan struct{})
+	ran := make(chan int, 3)
+	job := func(i int) func() {
+		return func() {
+			<-release
+			ran <- i
+		}
+	}
+
+	if err := p.submit(job(1)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "busy", func() bool { return p.stats().Busy == 1 })
+	if err := p.submit(job(2)); err != nil {
+		t.Fatalf("queue with room: %v", err)
+	}
+	if err := p.submit(job(3)); protocol.CodeOf(err) != protocol.ResourceExhausted {
+		t.Fatalf("full queue: %v", err)
+	}
+	st := p.stats()
+	if st.Workers != 1 || st.Busy != 1 || st.Queued != 1 || st.Max_queue != 1 || st.Rejected != 1 {
+		t.Fatalf("stats %+v", st)
+	}
+
+	close(release)
+	if a, b := <-ran, <-ran; a != 1 || b != 2 {
+		t.Fatalf("ran %d then %d", a, b)
+	}
+	waitFor(t, "idle", func() bool { return p.stats().Busy == 0 })
+	if st := p.stats(); st.Wait.Count != 2 {
+		t.Fatalf("waits counted %d, want 2", st.Wait.Count)
+	}
+	p.reset()
+	if st := p.stats(); st.Rejected != 0 || st.Wait.Count != 0 {
+		t.Fatalf("stats after reset %+v", st)
+	}
+}
+
+func TestPoolDefaults(t *testing.T) {
+	p := makePool(PoolSize{})
+	defer p.stop()
+	if st := p.stats(); st.Workers != defaultWorkers || st.Max_queue != 4*defaultWorkers {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func TestStoppedPoolRunsQueuedCalls(t *testing.T) {
+	p := makePool(PoolSize{Workers: 1, Queue: 2})
+	release := make(chan struct{})
+	ran := make(chan bool, 2)
+	p.submit(func() { <-release; ran <- true })
+	p.submit(func() { ran <- true })
+	p.stop()
+	p.stop()
+	if err := p.submit(func() {}); err == nil {
+		t.Fatal("stopped pool took a call")
+	}
+	close(release)
+	<-ran
+	<-ran
+}
+
+// a server with KV and Slow, Slow on a pool of one worker and one
+// queued call.
+func makePoolServer(t *testing.T) (*Server, *Slow) {
+	rs, _ := MakeServer()
+	slow := &Slow{release: make(chan struct{})}
+	rs.AddService(service.MakeService(&KV{}))
+	rs.AddService(service.MakeService(slow))
+	rs.SetPool(&PoolConfig{Workers: 2, Services: map[string]*PoolSize{"Slow": {Workers: 1, Queue: 1}}})
+	t.Cleanup(func() { rs.SetPool(nil) })
+	return rs, slow
+}
+
+func TestServiceWithOwnPool(t *testing.T) {
+	rs, slow := makePoolServer(t)
+	replies := make(chan protocol.ReplyMsg, 2)
+	// one call for the worker, then one for the queue.
+	for i := 1; i <= 2; i++ {
+		go func() { replies <- rs.Dispatch(protocol.ReqMsg{SvcMeth: "Slow.Wait"}) }()
+		waitFor(t, "Slow busy and queued", func() bool {
+			st := rs.Stats().Pools["Slow"]
+			return st != nil && st.Busy+st.Queued == i && st.Busy == 1
+		})
+	}
+
+	// Slow's pool is full, while other services are served.
+	if rep := rs.Dispatch(protocol.ReqMsg{SvcMeth: "Slow.Wait"}); rep.Code != protocol.ResourceExhausted {
+		t.Fatalf("call to a full pool: %v", rep.Code)
+	}
+	if rep := rs.Dispatch(protocol.ReqMsg{SvcMeth: "KV.Get"}); rep.Code != protocol.OK {
+		t.Fatalf("KV.Get while Slow is full: %v", rep.Code)
+	}
+
+	close(slow.release)
+	for i := 0; i < 2; i++ {
+		if rep := <-replies; rep.Code != protocol.OK {
+			t.Fatalf("queued call: %v", rep.Code)
+		}
+	}
+	pools := rs.Stats().Pools
+	if pools["Slow"].Rejected != 1 || pools[SharedPool].Rejected != 0 || pools[SharedPool].Workers != 2 {
+		t.Fatalf("pool stats %+v, %+v", pools["Slow"], pools[SharedPool])
+	}
+	if m := rs.Stats().Methods["Slow.Wait"]; m == nil || m.Errors[protocol.ResourceExhausted.String()] != 1 {
+		t.Fatalf("Slow.Wait stats %+v", m)
+	}
+}
+
+func TestUnknownServicesShareThePool(t *testing.T) {
+	rs, _ := MakeServer()
+	rs.AddService(service.MakeService(&KV{}))
+	rs.SetPool(&PoolConfig{Workers: 1, Isolate: true})
+	defer rs.SetPool(nil)
+	rs.Dispatch(protocol.ReqMsg{SvcMeth: "KV.Get"})
+	for i := 0; i < 3; i++ {
+		rs.Dispatch(protocol.ReqMsg{SvcMeth: "Made" + string(rune('A'+i)) + ".Up"})
+	}
+	pools := rs.Stats().Pools
+	if len(pools) != 2 || pools["KV"] == nil || pools[SharedPool] == nil {
+		t.Fatalf("pools %v, want KV's and the shared one", poolNames(pools))
+	}
+}
+
+func TestNoPoolByDefault(t *testing.T) {
+	rs, _ := MakeServer()
+	rs.AddService(service.MakeService(&KV{}))
+	if rep := rs.Dispatch(protocol.ReqMsg{SvcMeth: "KV.Get"}); rep.Code != protocol.OK {
+		t.Fatal(rep.Code)
+	}
+	if rs.Stats().Pools != nil {
+		t.Fatal("pool stats without pools")
+	}
+}
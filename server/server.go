@@ -2,31 +2,94 @@ package server
 
 import (
 	"bufio"
-	"log"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
 	"srpc/common/protocol"
 	"srpc/common/service"
+	"srpc/common/auth"
+	"srpc/common/logging"
+	"srpc/common/stats"
+	"srpc/common/tlsconf"
+	"srpc/common/trace"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"srpc/common/connect"
 )
 
 type Registry struct {
 	Registry_ip      string
 	Registry_port    string
+	Registry_addrs   []string // every registry in the cluster, as host:port
 	Registry_enabled bool
+	Server_name      string                 // what this server registers as
+	Server_ip        string
+	Server_port      string
+	Services         []*connect.ServiceInfo // service key and metadata, per service or per method
+	Registry_key     string                 // the secret the registry knows this server by; "" to sign nothing
+	Metrics_addr     string                 // where to serve Prometheus metrics, "" for nowhere
+	Access_log       *AccessLogConfig       // nil for no access log
+	Tls              *tlsconf.Config        // nil for plain TCP
+	Auth             *AuthConfig            // nil for no authentication
+	Limits           *Limits                // nil for no limits
+	Shedding         *SheddingConfig        // nil for no load shedding
+	Pool             *PoolConfig            // nil for a goroutine per call
 }
 
+const heartbeatInterval = 1 * time.Second
+
 type Server struct {
 	mu       sync.Mutex
 	services map[string]*service.Service
 	count    int // incoming RPCs
 	registry *Registry
 	config   *Config
+	done     chan struct{}
+	closing  sync.Once
+	epoch    int64        // registration epoch, picked on every start
+	listener net.Listener // set by Serve
+	conns    map[net.Conn]bool
+	stats    *stats.Recorder
+	inFlight int64        // calls being dispatched
+	metrics  *http.Server // set by Serve when Metrics_addr is
+	tracer   *trace.Tracer
+	logger   logging.Logger // nil for logging.Default
+	access   *AccessLog
+	ownLog   bool               // access was opened by Serve, which closes it
+	authn    auth.Authenticator // nil for anyone
+	policy   auth.PolicySource  // nil for any method
+	limiter  *limiter           // nil for no limits
+	shedder  *shedder           // nil for no load shedding
+	pools    *pools             // nil for a goroutine per call
+}
+
+// what a server has served, per method.
+type Stats struct {
+	stats.Snapshot
+	In_flight   int64
+	Connections int
+	Shedding    *ShedStats            // nil unless the server sheds load
+	Pools       map[string]*PoolStats // pool name, SharedPool or a service's -> its stats; nil without pools
+}
+
+type ShedStats struct {
+	Limit  int   // calls dispatched at once, as the server now finds best
+	Queued int   // calls waiting their turn
+	Shed   int64 // calls turned away since the server started
 }
 
 func MakeServer() (*Server, error){
 	rs := &Server{}
 	rs.services = map[string]*service.Service{}
+	rs.stats = stats.MakeRecorder()
 	return rs, nil
 }
 
@@ -34,6 +97,7 @@ func MakeServerFromConfig(fName string) (*Server, error) {
 	var err error
 	rs := &Server{}
 	rs.services = map[string]*service.Service{}
+	rs.stats = stats.MakeRecorder()
 	rs.config, err = NewConfig(fName, &JSONConfigFormat{})
 	if err != nil {
 		return nil, err
@@ -46,6 +110,7 @@ func MakeServerFromConfigText(text string) (*Server, error) {
 	var err error
 	rs := &Server{}
 	rs.services = map[string]*service.Service{}
+	rs.stats = stats.MakeRecorder()
 	rs.config, err = NewConfigFromText(text, &JSONConfigFormat{})
 	if err != nil {
 		return nil, err
@@ -77,28 +142,137 @@ func (rs *Server) RefreshConfigFromText(text string) error {
 func (rs *Server) AddService(svc *service.Service) {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
+	if rs.logger != nil {
+		svc.SetLogger(rs.logger)
+	}
 	rs.services[svc.Name] = svc
 }
 
+// where the server and its services log; nil for logging.Default.
+func (rs *Server) SetLogger(l logging.Logger) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.logger = l
+	for _, svc := range rs.services {
+		svc.SetLogger(l)
+	}
+}
+
+func (rs *Server) log() logging.Logger {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return logging.Or(rs.logger)
+}
+
 func (rs *Server) Serve() {
 	rs.InitWithConfigFile()
-	rs.processReq([]byte{})
 
-	listen, err := net.Listen("tcp", "127.0.0.1:20000")
+	listen, err := net.Listen("tcp", rs.address())
 	if err != nil {
-		log.Fatalf("listen failed, err: %v", err)
-		return
+		rs.log().Log(logging.Error, "srpc server: listen", logging.F("addr", rs.address()), logging.Err(err))
+		os.Exit(1)
+	}
+	if err := rs.useAuth(); err != nil {
+		rs.log().Log(logging.Error, "srpc server: auth", logging.Err(err))
+		os.Exit(1)
+	}
+	if rs.registry != nil && rs.registry.Tls != nil {
+		loader, err := tlsconf.MakeLoader(rs.registry.Tls)
+		if err != nil {
+			rs.log().Log(logging.Error, "srpc server: tls", logging.Err(err))
+			os.Exit(1)
+		}
+		listen = tls.NewListener(listen, loader.ServerConfig())
 	}
+	rs.mu.Lock()
+	rs.listener = listen
+	rs.useLimits()
+	rs.useShedding()
+	rs.usePool()
+	if rs.registry != nil && rs.registry.Access_log != nil && rs.access == nil {
+		if a, err := OpenAccessLog(rs.registry.Access_log); err != nil {
+			logging.Or(rs.logger).Log(logging.Error, "srpc server: open access log",
+				logging.F("path", rs.registry.Access_log.Path), logging.Err(err))
+		} else {
+			rs.access, rs.ownLog = a, true
+		}
+	}
+	if rs.registry != nil && rs.registry.Metrics_addr != "" {
+		rs.metrics = &http.Server{Addr: rs.registry.Metrics_addr, Handler: rs.MetricsHandler()}
+		go func(m *http.Server, l logging.Logger) {
+			if err := m.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				l.Log(logging.Error, "srpc server: serve metrics", logging.F("addr", m.Addr), logging.Err(err))
+			}
+		}(rs.metrics, logging.Or(rs.logger))
+	}
+	rs.mu.Unlock()
 
 	for {
 		conn, err := listen.Accept()
 		if err != nil {
-			log.Fatalf("accept failed, err: %v", err)
-			continue
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			// closed by Close.
+			return
 		}
 		go rs.process(conn)
 	}
+}
 
+// stop serving, stop heartbeating and withdraw from the registry.
+func (rs *Server) Close() {
+	rs.mu.Lock()
+	if rs.listener != nil {
+		rs.listener.Close()
+		rs.listener = nil
+	}
+	for conn := range rs.conns {
+		conn.Close()
+	}
+	if rs.metrics != nil {
+		rs.metrics.Close()
+		rs.metrics = nil
+	}
+	if rs.ownLog {
+		rs.access.Close()
+		rs.access, rs.ownLog = nil, false
+	}
+	if rs.pools != nil {
+		rs.pools.stop()
+		rs.pools = nil
+	}
+	done := rs.done
+	rs.mu.Unlock()
+	if done == nil {
+		return
+	}
+	rs.closing.Do(func() {
+		close(done)
+		name := rs.registry.Server_name
+		if name == "" {
+			name = rs.address()
+		}
+		if err := connect.Unregister(rs.registry.Registry_addrs, name, rs.registryKey(name)); err != nil {
+			rs.log().Log(logging.Warn, "srpc server: unregister",
+				logging.F("registry", rs.registry.Registry_addrs), logging.Err(err))
+		}
+	})
+}
+
+// every "Service.Method" the server offers.
+func (rs *Server) Methods() []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	methods := []string{}
+	for _, svc := range rs.services {
+		for mname := range svc.Methods {
+			methods = append(methods, svc.Name+"."+mname)
+		}
+	}
+	sort.Strings(methods)
+	return methods
 }
 
 func (rs *Server) GetCount() int {
@@ -107,26 +281,284 @@ func (rs *Server) GetCount() int {
 	return rs.count
 }
 
+// serve the calls coming in on one connection, each in its own
+// goroutine, until the client hangs up.
 func (rs *Server) process(conn net.Conn) {
-	defer conn.Close()
+	rs.mu.Lock()
+	if rs.conns == nil {
+		rs.conns = map[net.Conn]bool{}
+	}
+	if !rs.connectionAllowed() {
+		n := len(rs.conns)
+		rs.mu.Unlock()
+		conn.Close()
+		rs.log().Log(logging.Warn, "srpc server: too many connections, closing a new one",
+			logging.Peer(conn.RemoteAddr().String()), logging.F("connections", n))
+		return
+	}
+	rs.conns[conn] = true
+	rs.mu.Unlock()
+	defer func() {
+		conn.Close()
+		rs.mu.Lock()
+		delete(rs.conns, conn)
+		rs.mu.Unlock()
+	}()
+	var state *tls.ConnectionState
+	if tc, ok := conn.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			rs.log().Log(logging.Warn, "srpc server: tls handshake",
+				logging.Peer(conn.RemoteAddr().String()), logging.Err(err))
+			return
+		}
+		cs := tc.ConnectionState()
+		state = &cs
+	}
+	var wmu sync.Mutex
+	w := bufio.NewWriter(conn)
+	r := bufio.NewReader(conn)
+	write := func(seq uint64, rep protocol.ReplyMsg) {
+		wmu.Lock()
+		defer wmu.Unlock()
+		err := protocol.WriteFrame(w, &protocol.Frame{Seq: seq, Ok: rep.Ok, Code: rep.Code, Body: rep.Reply})
+		if err == nil {
+			w.Flush()
+		}
+	}
 	for {
-		reader := bufio.NewReader(conn)
-		var buf [128]byte
-		n, err := reader.Read(buf[:])
+		f, err := protocol.ReadFrameLimit(r, rs.frameLimit())
+		received := time.Now()
+		var tooLarge *protocol.FrameTooLargeError
+		if errors.As(err, &tooLarge) {
+			// the body was skipped unread; fail just this call.
+			rs.log().Log(logging.Warn, "srpc server: request too large",
+				logging.Peer(conn.RemoteAddr().String()), logging.Seq(tooLarge.Seq), logging.Err(err))
+			write(tooLarge.Seq, protocol.ReplyMsg{Ok: false, Code: protocol.ResourceExhausted})
+			continue
+		}
 		if err != nil {
-			log.Fatalf("read from client failed, err: %v", err)
-			break
+			if err != io.EOF {
+				rs.log().Log(logging.Warn, "srpc server: read frame",
+					logging.Peer(conn.RemoteAddr().String()), logging.Err(err))
+			}
+			return
+		}
+		req := requestOf(f, conn.RemoteAddr().String(), state, received)
+		seq := f.Seq
+		run := func() {
+			write(seq, rs.processReq(req, seq))
+		}
+		if p := rs.pool(req.SvcMeth); p == nil {
+			go run()
+		} else if err := p.submit(run); err != nil {
+			write(seq, rs.refuse(req, err))
+		}
+	}
+}
+
+// the call a request frame from peer stands for, over TLS if state
+// is not nil.
+func requestOf(f *protocol.Frame, peer string, state *tls.ConnectionState, received time.Time) protocol.ReqMsg {
+	req := protocol.ReqMsg{
+		SvcMeth:  f.SvcMeth,
+		Args:     f.Body,
+		Meta:     f.Meta,
+		Peer:     peer,
+		TLS:      state,
+		Received: received,
+	}
+	if f.Endname != "" {
+		req.Endname = f.Endname
+	}
+	return req
+}
+
+// serve a call that came in on the server's own transport.
+func (rs *Server) processReq(req protocol.ReqMsg, seq uint64) protocol.ReplyMsg {
+	start := time.Now()
+	rep := rs.serve(req)
+	if l := rs.log(); l.Enabled(logging.Debug) {
+		l.Log(logging.Debug, "srpc server: call", append(logging.SvcMeth(req.SvcMeth),
+			logging.Peer(req.Peer), logging.Seq(seq), logging.Code(rep.Code), logging.Latency(time.Since(start)))...)
+	}
+	return rep
+}
+
+// run one call. transports other than the server's own, such as
+// a test network, hand requests straight to Dispatch.
+func (rs *Server) Dispatch(req protocol.ReqMsg) protocol.ReplyMsg {
+	p := rs.pool(req.SvcMeth)
+	if p == nil {
+		return rs.serve(req)
+	}
+	if req.Received.IsZero() {
+		req.Received = time.Now()
+	}
+	ch := make(chan protocol.ReplyMsg, 1)
+	if err := p.submit(func() { ch <- rs.serve(req) }); err != nil {
+		return rs.refuse(req, err)
+	}
+	return <-ch
+}
+
+// fail a call that never got as far as serve, counting it as
+// served calls are counted.
+func (rs *Server) refuse(req protocol.ReqMsg, err error) protocol.ReplyMsg {
+	code := protocol.CodeOf(err)
+	rs.log().Log(logging.Debug, "srpc server: call turned away", append(logging.SvcMeth(req.SvcMeth),
+		logging.Peer(req.Peer), logging.Err(err))...)
+	rs.stats.Record(rs.statsName(req.SvcMeth), code, len(req.Args), 0, 0)
+	rs.logAccess(req, code, 0, time.Now(), 0)
+	return protocol.ReplyMsg{Ok: false, Code: code}
+}
+
+// what Stats counts calls of methods the server does not have
+// under, so that callers cannot make up names without end.
+const unknownMethod = "unknown"
+
+// the name calls of svcMeth are counted under in Stats.
+func (rs *Server) statsName(svcMeth string) string {
+	dot := strings.LastIndex(svcMeth, ".")
+	if dot < 0 {
+		return unknownMethod
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if svc, ok := rs.services[svcMeth[:dot]]; ok {
+		if _, ok := svc.Methods[svcMeth[dot+1:]]; ok {
+			return svcMeth
 		}
-		recvStr := string(buf[:n])
-		log.Fatalf("收到client端发来的数据：%v", recvStr)
-		conn.Write(rs.processReq(buf[:n]))
 	}
+	return unknownMethod
+}
+
+// authorize, admit and dispatch a call, and account for it.
+func (rs *Server) serve(req protocol.ReqMsg) protocol.ReplyMsg {
+	atomic.AddInt64(&rs.inFlight, 1)
+	defer atomic.AddInt64(&rs.inFlight, -1)
+	start := time.Now()
+	if req.Received.IsZero() {
+		req.Received = start
+	}
+	span := rs.startSpan(req)
+	var rep protocol.ReplyMsg
+	if err := rs.authorize(&req); err != nil {
+		rs.log().Log(logging.Info, "srpc server: call refused", append(logging.SvcMeth(req.SvcMeth),
+			logging.Peer(req.Peer), logging.F("principal", req.Principal), logging.Err(err))...)
+		rep = protocol.ReplyMsg{Ok: false, Code: protocol.CodeOf(err)}
+	} else if done, err := rs.admit(&req); err != nil {
+		rs.log().Log(logging.Debug, "srpc server: call turned away", append(logging.SvcMeth(req.SvcMeth),
+			logging.Peer(req.Peer), logging.Err(err))...)
+		rep = protocol.ReplyMsg{Ok: false, Code: protocol.CodeOf(err)}
+	} else {
+		rep = rs.dispatch(req)
+		done()
+	}
+	code := rep.Code
+	if !rep.Ok && code == protocol.OK {
+		code = protocol.Unknown
+	}
+	latency := time.Since(start)
+	rs.stats.Record(rs.statsName(req.SvcMeth), code, len(req.Args), len(rep.Reply), latency)
+	rs.logAccess(req, code, len(rep.Reply), start, latency)
+	span.AddEvent("reply", "bytes", strconv.Itoa(len(rep.Reply)))
+	span.End(code.String())
+	return rep
 }
 
-func (rs *Server) processReq(data []byte) []byte {
-	// 将字节流转换为请求
-	rs.dispatch(protocol.ReqMsg{})
-	return []byte{}
+// write a record of every dispatched call to a; nil, the default,
+// writes none. the caller keeps ownership of a.
+func (rs *Server) SetAccessLog(a *AccessLog) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.ownLog {
+		rs.access.Close()
+	}
+	rs.access, rs.ownLog = a, false
+}
+
+func (rs *Server) logAccess(req protocol.ReqMsg, code protocol.Code, replyBytes int, start time.Time,
+	latency time.Duration) {
+	rs.mu.Lock()
+	a := rs.access
+	rs.mu.Unlock()
+	if a == nil {
+		return
+	}
+	rec := &AccessRecord{
+		Time:          start,
+		Peer:          req.Peer,
+		Principal:     req.Principal,
+		Method:        req.SvcMeth,
+		Code:          code.String(),
+		Request_bytes: len(req.Args),
+		Reply_bytes:   replyBytes,
+		Latency:       latency.Seconds(),
+	}
+	if req.Endname != nil {
+		rec.Endname = fmt.Sprint(req.Endname)
+	}
+	if dot := strings.LastIndex(req.SvcMeth, "."); dot >= 0 {
+		rec.Service, rec.Method = req.SvcMeth[:dot], req.SvcMeth[dot+1:]
+	}
+	if err := a.Write(rec); err != nil {
+		rs.log().Log(logging.Warn, "srpc server: write access log", logging.Err(err))
+	}
+}
+
+// make spans of served calls with t; nil, the default, makes none.
+func (rs *Server) SetTracer(t *trace.Tracer) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.tracer = t
+}
+
+// start the span of req, continuing the caller's trace if it sent one.
+func (rs *Server) startSpan(req protocol.ReqMsg) *trace.Span {
+	rs.mu.Lock()
+	tracer := rs.tracer
+	rs.mu.Unlock()
+	if tracer == nil {
+		return nil
+	}
+	parent := trace.SpanContext{}
+	if tp, ok := req.Meta[trace.Header]; ok {
+		var err error
+		if parent, err = trace.ParseTraceparent(tp); err != nil {
+			rs.log().Log(logging.Warn, "srpc server: bad trace context",
+				append(logging.SvcMeth(req.SvcMeth), logging.Err(err))...)
+		}
+	}
+	span := tracer.Start(req.SvcMeth, trace.KindServer, parent)
+	span.AddEvent("receive", "bytes", strconv.Itoa(len(req.Args)))
+	return span
+}
+
+func (rs *Server) Stats() *Stats {
+	rs.mu.Lock()
+	conns := len(rs.conns)
+	sh, ps := rs.shedder, rs.pools
+	rs.mu.Unlock()
+	st := &Stats{rs.stats.Snapshot(), atomic.LoadInt64(&rs.inFlight), conns, nil, nil}
+	if sh != nil {
+		st.Shedding = &ShedStats{}
+		st.Shedding.Limit, st.Shedding.Queued, st.Shedding.Shed = sh.snapshot()
+	}
+	if ps != nil {
+		st.Pools = ps.stats()
+	}
+	return st
+}
+
+// start the counters of Stats from zero.
+func (rs *Server) ResetStats() {
+	rs.stats.Reset()
+	rs.mu.Lock()
+	ps := rs.pools
+	rs.mu.Unlock()
+	if ps != nil {
+		ps.reset()
+	}
 }
 
 func (rs *Server) dispatch(req protocol.ReqMsg) protocol.ReplyMsg {
@@ -136,37 +568,132 @@ func (rs *Server) dispatch(req protocol.ReqMsg) protocol.ReplyMsg {
 
 	// split Raft.AppendEntries into service and method
 	dot := strings.LastIndex(req.SvcMeth, ".")
+	if dot < 0 {
+		rs.mu.Unlock()
+		rs.log().Log(logging.Warn, "srpc server: malformed method", logging.Method(req.SvcMeth))
+		return protocol.ReplyMsg{Ok: false, Code: protocol.NotFound}
+	}
 	serviceName := req.SvcMeth[:dot]
 	methodName := req.SvcMeth[dot+1:]
 
 	service, ok := rs.services[serviceName]
 
+	choices := []string{}
+	for k := range rs.services {
+		choices = append(choices, k)
+	}
+
 	rs.mu.Unlock()
 
 	if ok {
 		return service.Dispatch(methodName, req)
 	} else {
-		choices := []string{}
-		for k := range rs.services {
-			choices = append(choices, k)
-		}
-		log.Fatalf("labrpc.Server.dispatch(): unknown service %v in %v.%v; expecting one of %v\n",
-			serviceName, serviceName, methodName, choices)
-		return protocol.ReplyMsg{Ok: false, Reply: nil}
+		rs.log().Log(logging.Warn, "srpc server: unknown service",
+			logging.Service(serviceName), logging.Method(methodName), logging.F("choices", choices))
+		return protocol.ReplyMsg{Ok: false, Reply: nil, Code: protocol.NotFound}
 	}
 }
 
 func (rs *Server) InitWithConfigFile() {
-	rs.register()
+	if rs.registry == nil || !rs.registry.Registry_enabled || len(rs.registry.Registry_addrs) == 0 {
+		return
+	}
+	rs.done = make(chan struct{})
+	rs.epoch = time.Now().UnixNano()
+	if err := rs.register(); err != nil {
+		rs.log().Log(logging.Warn, "srpc server: register",
+			logging.F("registry", rs.registry.Registry_addrs), logging.Err(err))
+	}
 	go rs.heartbeat()
 }
 
-// 
+func (rs *Server) address() string {
+	ip, port := "127.0.0.1", "20000"
+	if rs.registry != nil && rs.registry.Server_ip != "" {
+		ip = rs.registry.Server_ip
+	}
+	if rs.registry != nil && rs.registry.Server_port != "" {
+		port = rs.registry.Server_port
+	}
+	return net.JoinHostPort(ip, port)
+}
+
+// announce every method of every service to the registry.
+func (rs *Server) register() error {
+	host, port, _ := net.SplitHostPort(rs.address())
+	reg := &connect.Registration{
+		Server_name: rs.registry.Server_name,
+		Server_ip:   host,
+		Server_port: port,
+		Epoch:       rs.epoch,
+	}
+	if reg.Server_name == "" {
+		reg.Server_name = rs.address()
+	}
+	rs.mu.Lock()
+	for _, svc := range rs.services {
+		for mname := range svc.Methods {
+			info := &connect.ServiceInfo{
+				Service_name: svc.Name,
+				Method_name:  mname,
+			}
+			if conf := rs.serviceInfo(svc.Name, mname); conf != nil {
+				info.Service_key = conf.Service_key
+				info.Metadata = conf.Metadata
+			}
+			reg.Services = append(reg.Services, info)
+		}
+	}
+	rs.mu.Unlock()
+	return connect.Register(rs.registry.Registry_addrs, reg, rs.registryKey(reg.Server_name))
+}
+
+// what the server signs requests to the registry with, as name.
+func (rs *Server) registryKey(name string) *connect.Key {
+	if rs.registry.Registry_key == "" {
+		return nil
+	}
+	return &connect.Key{Id: name, Secret: rs.registry.Registry_key}
+}
 
-func (rs *Server) register() {
-	
+// the configured key and metadata of a method: an entry for the
+// method itself wins over one for its whole service.
+func (rs *Server) serviceInfo(serviceName, methodName string) *connect.ServiceInfo {
+	var found *connect.ServiceInfo
+	for _, info := range rs.registry.Services {
+		if info.Service_name != serviceName {
+			continue
+		}
+		if info.Method_name == methodName {
+			return info
+		}
+		if info.Method_name == "" {
+			found = info
+		}
+	}
+	return found
 }
 
+// keep the lease at the registry alive, registering again
+// if the registry has forgotten about us.
 func (rs *Server) heartbeat() {
-
+	name := rs.registry.Server_name
+	if name == "" {
+		name = rs.address()
+	}
+	for {
+		select {
+		case <-rs.done:
+			return
+		case <-time.After(heartbeatInterval):
+		}
+		err := connect.Heartbeat(rs.registry.Registry_addrs, name, rs.registryKey(name))
+		if err == connect.ErrUnknownServer {
+			err = rs.register()
+		}
+		if err != nil {
+			rs.log().Log(logging.Warn, "srpc server: heartbeat",
+				logging.F("registry", rs.registry.Registry_addrs), logging.Err(err))
+		}
+	}
 }
\ No newline at end of file
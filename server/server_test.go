@@ -0,0 +1,98 @@
+package server
+
+import (
+	"srpc/common/protocol"
+	"srpc/common/service"
+	"srpc/common/trace"
+	"strconv"
+	"testing"
+)
+
+type KV struct{}
+
+func (kv *KV) Get(key string, value *string) {
+	*value = "v"
+}
+
+func TestStatsCountUnknownMethodsTogether(t *testing.T) {
+	rs, _ := MakeServer()
+	rs.AddService(service.MakeService(&KV{}))
+	for i := 0; i < 5; i++ {
+		rs.Dispatch(protocol.ReqMsg{SvcMeth: "Made.Up" + strconv.Itoa(i)})
+		rs.Dispatch(protocol.ReqMsg{SvcMeth: "KV.Up" + strconv.Itoa(i)})
+	}
+	rs.Dispatch(protocol.ReqMsg{SvcMeth: "nodot"})
+	rs.Dispatch(protocol.ReqMsg{SvcMeth: "KV.Get"})
+
+	methods := rs.Stats().Methods
+	if len(methods) != 2 {
+		t.Fatalf("stats kept for %d methods, want KV.Get and %v", len(methods), unknownMethod)
+	}
+	if m := methods[unknownMethod]; m == nil || m.Requests != 11 {
+		t.Fatalf("unknown methods counted as %+v, want 11 calls", m)
+	}
+	if m := methods["KV.Get"]; m == nil || m.Requests != 1 {
+		t.Fatalf("KV.Get counted as %+v", m)
+	}
+}
+
+func TestStatsCountRefusedCallsOfUnknownMethods(t *testing.T) {
+	rs, _ := MakeServer()
+	rs.AddService(service.MakeService(&KV{}))
+	rs.SetAuthenticator(refuseAll{})
+	for i := 0; i < 5; i++ {
+		if rep := rs.Dispatch(protocol.ReqMsg{SvcMeth: "Made.Up" + strconv.Itoa(i)}); rep.Ok {
+			t.Fatal("unauthenticated call served")
+		}
+	}
+	if methods := rs.Stats().Methods; len(methods) != 1 || methods[unknownMethod].Requests != 5 {
+		t.Fatalf("refused calls counted as %v", methods)
+	}
+}
+
+// an authenticator that turns every call away.
+type refuseAll struct{}
+
+func (refuseAll) Authenticate(req *protocol.ReqMsg) (string, error) {
+	return "", protocol.NewError(protocol.Unauthenticated, "test: no token")
+}
+
+func TestCloseTwice(t *testing.T) {
+	rs, _ := MakeServer()
+	// as after InitWithConfigFile, with no registry to be found.
+	rs.registry = &Registry{Server_name: "s1", Registry_addrs: []string{"127.0.0.1:1"}}
+	rs.done = make(chan struct{})
+	rs.Close()
+	rs.Close()
+	select {
+	case <-rs.done:
+	default:
+		t.Fatal("heartbeats not stopped")
+	}
+}
+
+// keeps the spans exported to it.
+type spans []*trace.SpanData
+
+func (s *spans) Export(span *trace.SpanData) { *s = append(*s, span) }
+
+func TestServedCallsContinueTheCallersTrace(t *testing.T) {
+	rs, _ := MakeServer()
+	rs.AddService(service.MakeService(&KV{}))
+	exported := &spans{}
+	rs.SetTracer(trace.MakeTracer(exported))
+	tp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	rs.Dispatch(protocol.ReqMsg{SvcMeth: "KV.Get", Meta: map[string]string{trace.Header: tp}})
+	rs.Dispatch(protocol.ReqMsg{SvcMeth: "KV.Get"})
+
+	if len(*exported) != 2 {
+		t.Fatalf("%d spans exported, want 2", len(*exported))
+	}
+	s := (*exported)[0]
+	if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || s.ParentID != "00f067aa0ba902b7" || s.Kind != trace.KindServer {
+		t.Fatalf("span %+v not in the caller's trace", s)
+	}
+	if s := (*exported)[1]; s.ParentID != "" || s.TraceID == "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("untraced call joined a trace: %+v", s)
+	}
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"srpc/common/protocol"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// adaptive load shedding. rather than hold to a fixed limit, the
+// server finds how many calls it can run at once from how long calls
+// wait before they are dispatched, as CoDel does: while the shortest
+// wait in an Interval stays under Target the limit may grow; once it
+// does not the server is overloaded, the limit shrinks, and waiting
+// calls are turned away. waiting calls go in order of priority
+// (protocol.PriorityKey), and those shed are the least important.
+// a client cannot ask for more than Max_priority, unless it is an
+// authenticated principal with a ceiling of its own in Priorities.
+type SheddingConfig struct {
+	Target        time.Duration  // the queueing delay to stay under; 0 for 5ms
+	Interval      time.Duration  // how long delay must stay over Target to count as overload; 0 for 100ms
+	Max_queue     int            // calls waiting at once; 0 for 1000
+	Min_limit     int            // calls dispatched at once, at least; 0 for 1
+	Max_limit     int            // and at most; 0 for 1000
+	Initial_limit int            // 0 for 20
+	Max_priority  int            // calls ask for priorities from -Max_priority to Max_priority; 0 for 10
+	Priorities    map[string]int // principal -> the most its calls may ask for, in place of Max_priority
+}
+
+func (conf SheddingConfig) withDefaults() SheddingConfig {
+	if conf.Target <= 0 {
+		conf.Target = 5 * time.Millisecond
+	}
+	if conf.Interval <= 0 {
+		conf.Interval = 100 * time.Millisecond
+	}
+	if conf.Max_queue <= 0 {
+		conf.Max_queue = 1000
+	}
+	if conf.Min_limit <= 0 {
+		conf.Min_limit = 1
+	}
+	if conf.Max_limit <= 0 {
+		conf.Max_limit = 1000
+	}
+	if conf.Max_limit < conf.Min_limit {
+		conf.Max_limit = conf.Min_limit
+	}
+	if conf.Initial_limit <= 0 {
+		conf.Initial_limit = 20
+	}
+	if conf.Initial_limit < conf.Min_limit {
+		conf.Initial_limit = conf.Min_limit
+	}
+	if conf.Initial_limit > conf.Max_limit {
+		conf.Initial_limit = conf.Max_limit
+	}
+	if conf.Max_priority <= 0 {
+		conf.Max_priority = 10
+	}
+	return conf
+}
+
+// a call waiting for its turn.
+type waiter struct {
+	priority int
+	arrived  time.Time
+	ch       chan bool // true when dispatched, false when shed
+}
+
+type shedder struct {
+	mu         sync.Mutex
+	conf       SheddingConfig
+	limit      float64
+	inFlight   int
+	queue      []*waiter // highest priority first, then oldest first
+	overloaded bool
+	window     time.Time     // when the current interval began
+	minDelay   time.Duration // shortest delay seen in the current interval
+	limited    bool          // some call had to wait in the current interval
+	shed       int64
+}
+
+func makeShedder(conf *SheddingConfig) *shedder {
+	sh := &shedder{conf: conf.withDefaults()}
+	sh.limit = float64(sh.conf.Initial_limit)
+	sh.window = time.Now()
+	sh.minDelay = -1
+	return sh
+}
+
+var errShed = protocol.NewError(protocol.ResourceExhausted, "srpc: server overloaded, call shed")
+
+// wait until a call that arrived when it did, with priority, may be
+// dispatched, or return errShed if it is shed instead. the caller
+// calls release once a dispatched call is done.
+func (sh *shedder) acquire(priority int, arrived time.Time) error {
+	sh.mu.Lock()
+	if len(sh.queue) == 0 && sh.inFlight < int(sh.limit) {
+		sh.inFlight++
+		sh.observe(time.Since(arrived))
+		sh.mu.Unlock()
+		return nil
+	}
+	sh.limited = true
+	if len(sh.queue) >= sh.conf.Max_queue {
+		last := sh.queue[len(sh.queue)-1]
+		if last.priority >= priority {
+			sh.shed++
+			sh.mu.Unlock()
+			return errShed
+		}
+		sh.drop(len(sh.queue) - 1)
+	}
+	w := &waiter{priority: priority, arrived: arrived, ch: make(chan bool, 1)}
+	i := len(sh.queue)
+	for i > 0 && sh.queue[i-1].priority < priority {
+		i--
+	}
+	sh.queue = append(sh.queue, nil)
+	copy(sh.queue[i+1:], sh.queue[i:])
+	sh.queue[i] = w
+	sh.next()
+	sh.mu.Unlock()
+
+	if !<-w.ch {
+		return errShed
+	}
+	return nil
+}
+
+func (sh *shedder) release() {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.inFlight--
+	sh.next()
+}
+
+// dispatch waiting calls while the limit allows. while overloaded,
+// each that waited past Target costs the call at the back of the
+// queue, of the lowest priority, its place. the caller holds sh.mu.
+func (sh *shedder) next() {
+	for len(sh.queue) > 0 && sh.inFlight < int(sh.limit) {
+		w := sh.queue[0]
+		sh.queue = sh.queue[1:]
+		delay := time.Since(w.arrived)
+		sh.observe(delay)
+		if sh.overloaded && delay > sh.conf.Target && len(sh.queue) > 0 {
+			sh.drop(len(sh.queue) - 1)
+		}
+		sh.inFlight++
+		w.ch <- true
+	}
+	// while overloaded nobody at the back of the queue, where the
+	// lowest priorities are, gets to wait past Target.
+	for sh.overloaded && len(sh.queue) > 0 &&
+		time.Since(sh.queue[len(sh.queue)-1].arrived) > sh.conf.Target {
+		sh.drop(len(sh.queue) - 1)
+	}
+}
+
+// shed the i'th waiting call. the caller holds sh.mu.
+func (sh *shedder) drop(i int) {
+	w := sh.queue[i]
+	sh.queue = append(sh.queue[:i], sh.queue[i+1:]...)
+	sh.shed++
+	w.ch <- false
+}
+
+// note a call's delay before dispatch, and at the end of each
+// interval decide whether the server is overloaded and move the
+// limit. the caller holds sh.mu.
+func (sh *shedder) observe(delay time.Duration) {
+	if sh.minDelay < 0 || delay < sh.minDelay {
+		sh.minDelay = delay
+	}
+	if time.Since(sh.window) < sh.conf.Interval {
+		return
+	}
+	sh.overloaded = sh.minDelay > sh.conf.Target
+	if sh.overloaded {
+		sh.limit *= 0.9
+		if sh.limit < float64(sh.conf.Min_limit) {
+			sh.limit = float64(sh.conf.Min_limit)
+		}
+	} else if sh.limited {
+		sh.limit++
+		if sh.limit > float64(sh.conf.Max_limit) {
+			sh.limit = float64(sh.conf.Max_limit)
+		}
+	}
+	sh.window = time.Now()
+	sh.minDelay = -1
+	sh.limited = false
+}
+
+// what Stats reports of the shedder.
+func (sh *shedder) snapshot() (limit int, queued int, shed int64) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return int(sh.limit), len(sh.queue), sh.shed
+}
+
+// the priority req asked for, 0 if none, held to what its
+// principal may ask for.
+func (sh *shedder) priorityOf(req *protocol.ReqMsg) int {
+	p, err := strconv.Atoi(req.Meta[protocol.PriorityKey])
+	if err != nil {
+		return 0
+	}
+	max := sh.conf.Max_priority
+	if ceiling, ok := sh.conf.Priorities[req.Principal]; ok && req.Principal != "" {
+		max = ceiling
+	}
+	if p > max {
+		p = max
+	}
+	if p < -sh.conf.Max_priority {
+		p = -sh.conf.Max_priority
+	}
+	return p
+}
+
+// shed load adaptively as conf says; nil stops shedding. calls
+// already waiting are not affected.
+func (rs *Server) SetShedding(conf *SheddingConfig) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if conf == nil {
+		rs.shedder = nil
+		return
+	}
+	rs.shedder = makeShedder(conf)
+}
+
+// set up shedding from the config, unless it was set already.
+// the caller holds rs.mu.
+func (rs *Server) useShedding() {
+	if rs.shedder == nil && rs.registry != nil && rs.registry.Shedding != nil {
+		rs.shedder = makeShedder(rs.registry.Shedding)
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"srpc/common/protocol"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// a shedder that lets one call through at a time and holds to it.
+func makeTestShedder(maxQueue int) *shedder {
+	return makeShedder(&SheddingConfig{
+		Interval:      time.Hour,
+		Initial_limit: 1,
+		Max_limit:     1,
+		Max_queue:     maxQueue,
+	})
+}
+
+// acquire in the background, returning once the call waits in the queue.
+func queueCall(t *testing.T, sh *shedder, priority int) chan error {
+	_, queued, _ := sh.snapshot()
+	ch := make(chan error, 1)
+	go func() { ch <- sh.acquire(priority, time.Now()) }()
+	waitQueued(t, sh, queued+1)
+	return ch
+}
+
+func waitQueued(t *testing.T, sh *shedder, n int) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if _, queued, _ := sh.snapshot(); queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("queue never reached %d", n)
+}
+
+func TestShedderQueuesPastLimit(t *testing.T) {
+	sh := makeTestShedder(10)
+	if err := sh.acquire(0, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	ch := queueCall(t, sh, 0)
+	select {
+	case err := <-ch:
+		t.Fatalf("dispatched past the limit: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	sh.release()
+	if err := <-ch; err != nil {
+		t.Fatal(err)
+	}
+	sh.release()
+}
+
+func TestShedderDispatchesByPriority(t *testing.T) {
+	sh := makeTestShedder(10)
+	sh.acquire(0, time.Now())
+	low := queueCall(t, sh, -1)
+	mid := queueCall(t, sh, 0)
+	high := queueCall(t, sh, 5)
+	for _, ch := range []chan error{high, mid, low} {
+		sh.release()
+		if err := <-ch; err != nil {
+			t.Fatal(err)
+		}
+	}
+	sh.release()
+}
+
+func TestShedderShedsWhenQueueIsFull(t *testing.T) {
+	sh := makeTestShedder(1)
+	sh.acquire(0, time.Now())
+	low := queueCall(t, sh, 0)
+	// no more important than the call already waiting.
+	if err := sh.acquire(0, time.Now()); err != errShed {
+		t.Fatalf("call onto a full queue: %v", err)
+	}
+	// more important: the waiting call makes room for it.
+	ch := make(chan error, 1)
+	go func() { ch <- sh.acquire(1, time.Now()) }()
+	if err := <-low; err != errShed {
+		t.Fatalf("call pushed out of the queue: %v", err)
+	}
+	sh.release()
+	if err := <-ch; err != nil {
+		t.Fatal(err)
+	}
+	sh.release()
+	if _, _, shed := sh.snapshot(); shed != 2 {
+		t.Fatalf("%d calls shed, want 2", shed)
+	}
+}
+
+func TestShedderHoldsPriorityInRange(t *testing.T) {
+	sh := makeShedder(&SheddingConfig{Max_priority: 5, Priorities: map[string]int{"ops": 20}})
+	req := func(principal string, priority int) *protocol.ReqMsg {
+		return &protocol.ReqMsg{
+			Principal: principal,
+			Meta:      map[string]string{protocol.PriorityKey: strconv.Itoa(priority)},
+		}
+	}
+	cases := []struct {
+		req  *protocol.ReqMsg
+		want int
+	}{
+		{req("", 3), 3},
+		{req("", 100), 5},
+		{req("", -100), -5},
+		{req("ops", 100), 20},
+		{req("ops", -100), -5},
+		{req("someone", 100), 5},
+		{&protocol.ReqMsg{}, 0},
+		{&protocol.ReqMsg{Meta: map[string]string{protocol.PriorityKey: "urgent"}}, 0},
+	}
+	for _, c := range cases {
+		if got := sh.priorityOf(c.req); got != c.want {
+			t.Errorf("priority %v of %q: %d, want %d", c.req.Meta[protocol.PriorityKey], c.req.Principal,
+				got, c.want)
+		}
+	}
+}
+
+func TestAdmitQueuesWithoutLimiterSlot(t *testing.T) {
+	rs, _ := MakeServer()
+	rs.SetLimits(&Limits{Max_concurrent: 1})
+	rs.SetShedding(&SheddingConfig{Interval: time.Hour, Initial_limit: 1, Max_limit: 1})
+	first, err := rs.admit(&protocol.ReqMsg{SvcMeth: "KV.Get", Received: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan error, 1)
+	go func() {
+		done, err := rs.admit(&protocol.ReqMsg{SvcMeth: "KV.Get", Received: time.Now()})
+		if err == nil {
+			done()
+		}
+		ch <- err
+	}()
+	waitQueued(t, rs.shedder, 1)
+	// the waiting call was not turned away by the limiter, and once
+	// the first is done it takes the slot.
+	first()
+	if err := <-ch; err != nil {
+		t.Fatal(err)
+	}
+	if lm := rs.limiter; lm.inFlight != 0 {
+		t.Fatalf("%d limiter slots held after both calls", lm.inFlight)
+	}
+}
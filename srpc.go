@@ -38,7 +38,7 @@ func (s *Server) Serve() {
 }
 
 func (s *Server) Close() {
-
+	s.svr.Close()
 }
 
 func MakeServer() (*Server, error) {
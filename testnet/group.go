@@ -0,0 +1,254 @@
+package testnet
+
+import (
+	"fmt"
+	"sort"
+	"srpc/common/logging"
+	"strings"
+	"time"
+)
+
+// name a set of ends and servers, e.g. every end and the server of
+// one replica, so that they can be cut off and healed together.
+// defining a group again replaces its members.
+func (rn *Network) DefineGroup(name string, members ...interface{}) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.groups[name] = append([]interface{}{}, members...)
+}
+
+// the ends and servers of a group. the caller holds rn.mu.
+func (rn *Network) members(group string) (ends []interface{}, servers []interface{}, err error) {
+	members, ok := rn.groups[group]
+	if !ok {
+		return nil, nil, fmt.Errorf("testnet: unknown group %q", group)
+	}
+	for _, m := range members {
+		if _, ok := rn.ends[m]; ok {
+			ends = append(ends, m)
+		}
+		if _, ok := rn.servers[m]; ok {
+			servers = append(servers, m)
+		}
+	}
+	return ends, servers, nil
+}
+
+// set how the links between two groups carry messages: from a to b
+// if forward, from b to a if backward. a message from a to b is a
+// request of an end in a to a server in b, or a reply of a server
+// in a to an end in b. the caller holds rn.mu.
+func (rn *Network) connectGroups(a, b string, forward, backward bool) error {
+	aEnds, aServers, err := rn.members(a)
+	if err != nil {
+		return err
+	}
+	bEnds, bServers, err := rn.members(b)
+	if err != nil {
+		return err
+	}
+	for _, e := range aEnds {
+		for _, s := range bServers {
+			rn.block(link{e, s}, !forward, !backward)
+		}
+	}
+	for _, e := range bEnds {
+		for _, s := range aServers {
+			rn.block(link{e, s}, !backward, !forward)
+		}
+	}
+	return nil
+}
+
+// cut every link between two groups, both ways.
+func (rn *Network) PartitionGroups(a, b string) error {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.connectGroups(a, b, false, false)
+}
+
+// mend every link between two groups.
+func (rn *Network) HealGroups(a, b string) error {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.connectGroups(a, b, true, true)
+}
+
+// let messages from one group reach another, but none come back.
+func (rn *Network) OneWay(from, to string) error {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.connectGroups(from, to, true, false)
+}
+
+// cut a group off from every end and server outside it.
+func (rn *Network) Isolate(group string) error {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	ends, servers, err := rn.members(group)
+	if err != nil {
+		return err
+	}
+	in := map[interface{}]bool{}
+	for _, m := range rn.groups[group] {
+		in[m] = true
+	}
+	for _, e := range ends {
+		for s := range rn.servers {
+			if !in[s] {
+				rn.block(link{e, s}, true, true)
+			}
+		}
+	}
+	for e := range rn.ends {
+		if in[e] {
+			continue
+		}
+		for _, s := range servers {
+			rn.block(link{e, s}, true, true)
+		}
+	}
+	return nil
+}
+
+// a scenario to play on a Network: steps, each at a time from the
+// start. a script can be written out in Go,
+//
+//	s := &Script{}
+//	s.At(0, "partition", "leader", "rest").At(3*time.Second, "heal", "leader", "rest")
+//
+// or parsed from text, one step per line, blank lines and lines
+// starting with # ignored:
+//
+//	0s  partition leader rest
+//	3s  heal leader rest
+//
+// the steps are:
+//
+//	partition A B   cut groups A and B apart
+//	heal A B        mend the links between A and B
+//	oneway A B      A reaches B, B does not reach A
+//	isolate A       cut A off from everything else
+//	heal_all        mend every link
+//	enable END      enable a ClientEnd
+//	disable END     disable a ClientEnd
+//	profile NAME    apply a fault profile to all links
+type Script struct {
+	Steps []*Step
+}
+
+type Step struct {
+	At   time.Duration
+	Op   string
+	Args []string
+	Fn   func() // run instead of Op, for steps only Go can express
+}
+
+var stepArgs = map[string]int{
+	"partition": 2,
+	"heal":      2,
+	"oneway":    2,
+	"isolate":   1,
+	"heal_all":  0,
+	"enable":    1,
+	"disable":   1,
+	"profile":   1,
+}
+
+func (s *Script) At(at time.Duration, op string, args ...string) *Script {
+	s.Steps = append(s.Steps, &Step{At: at, Op: op, Args: args})
+	return s
+}
+
+// run fn at a point in the script, e.g. to define a group as
+// whoever is leader by then.
+func (s *Script) Do(at time.Duration, fn func()) *Script {
+	s.Steps = append(s.Steps, &Step{At: at, Fn: fn})
+	return s
+}
+
+func ParseScript(text string) (*Script, error) {
+	s := &Script{}
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("testnet: script line %d: want a time and a step", i+1)
+		}
+		at, err := time.ParseDuration(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("testnet: script line %d: %v", i+1, err)
+		}
+		n, ok := stepArgs[fields[1]]
+		if !ok {
+			return nil, fmt.Errorf("testnet: script line %d: unknown step %q", i+1, fields[1])
+		}
+		if len(fields)-2 != n {
+			return nil, fmt.Errorf("testnet: script line %d: %v takes %d arguments", i+1, fields[1], n)
+		}
+		s.At(at, fields[1], fields[2:]...)
+	}
+	return s, nil
+}
+
+// carry out one step now.
+func (rn *Network) Apply(step *Step) error {
+	if step.Fn != nil {
+		step.Fn()
+		return nil
+	}
+	if n, ok := stepArgs[step.Op]; !ok || len(step.Args) != n {
+		return fmt.Errorf("testnet: bad step %v %v", step.Op, step.Args)
+	}
+	switch step.Op {
+	case "partition":
+		return rn.PartitionGroups(step.Args[0], step.Args[1])
+	case "heal":
+		return rn.HealGroups(step.Args[0], step.Args[1])
+	case "oneway":
+		return rn.OneWay(step.Args[0], step.Args[1])
+	case "isolate":
+		return rn.Isolate(step.Args[0])
+	case "heal_all":
+		rn.HealAll()
+	case "enable":
+		rn.Enable(step.Args[0], true)
+	case "disable":
+		rn.Enable(step.Args[0], false)
+	case "profile":
+		return rn.UseProfile(step.Args[0])
+	}
+	return nil
+}
+
+// play a script in the background. the returned channel is closed
+// once the last step has run, or the network is cleaned up.
+func (rn *Network) Play(s *Script) <-chan struct{} {
+	steps := append([]*Step{}, s.Steps...)
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].At < steps[j].At })
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		start := time.Now()
+		for _, step := range steps {
+			select {
+			case <-time.After(time.Until(start.Add(step.At))):
+			case <-rn.done:
+				return
+			}
+			if err := rn.Apply(step); err != nil {
+				rn.log().Log(logging.Warn, "testnet: script step failed", logging.F("at", step.At.String()),
+					logging.Err(err))
+			}
+		}
+	}()
+	return done
+}
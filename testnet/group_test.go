@@ -0,0 +1,125 @@
+package testnet
+
+import (
+	"srpc/common/service"
+	"srpc/server"
+	"testing"
+	"time"
+)
+
+// two sides, a (e1, s1) and b (e2, s2), each end connected to the
+// server on the other side.
+func makeTwoSides(t *testing.T) *Network {
+	rn := MakeNetwork()
+	t.Cleanup(rn.Cleanup)
+	for _, side := range [][2]string{{"e1", "s2"}, {"e2", "s1"}} {
+		rs, _ := server.MakeServer()
+		rs.AddService(service.MakeService(&KV{}))
+		rn.MakeEnd(side[0])
+		rn.AddServer(side[1], rs)
+		rn.Connect(side[0], side[1])
+		rn.Enable(side[0], true)
+	}
+	rn.DefineGroup("a", "e1", "s1")
+	rn.DefineGroup("b", "e2", "s2")
+	return rn
+}
+
+// whether e1 reaches b and e2 reaches a.
+func reach(rn *Network) (ab bool, ba bool) {
+	_, ab = get(rn, "e1")
+	_, ba = get(rn, "e2")
+	return ab, ba
+}
+
+func TestGroupPartitions(t *testing.T) {
+	rn := makeTwoSides(t)
+	cases := []struct {
+		name   string
+		do     func() error
+		ab, ba bool
+	}{
+		{"partition", func() error { return rn.PartitionGroups("a", "b") }, false, false},
+		{"heal", func() error { return rn.HealGroups("a", "b") }, true, true},
+		{"isolate", func() error { return rn.Isolate("b") }, false, false},
+		{"heal all", func() error { rn.HealAll(); return nil }, true, true},
+		{"one way", func() error { return rn.OneWay("a", "b") }, false, false},
+	}
+	for _, c := range cases {
+		if err := c.do(); err != nil {
+			t.Fatalf("%v: %v", c.name, err)
+		}
+		if ab, ba := reach(rn); ab != c.ab || ba != c.ba {
+			t.Fatalf("%v: a->b %v, b->a %v, want %v, %v", c.name, ab, ba, c.ab, c.ba)
+		}
+	}
+	if err := rn.PartitionGroups("a", "nonesuch"); err == nil {
+		t.Fatal("partitioned an unknown group")
+	}
+}
+
+func TestOneWayLetsRequestsThrough(t *testing.T) {
+	rn := makeTwoSides(t)
+	rn.OneWay("a", "b")
+	// e1's request reaches s2, but its reply is lost; e2's request
+	// never reaches s1.
+	reach(rn)
+	if n := rn.GetCount("s2"); n != 1 {
+		t.Fatalf("s2 saw %d requests from a, want 1", n)
+	}
+	if n := rn.GetCount("s1"); n != 0 {
+		t.Fatalf("s1 saw %d requests from b, want 0", n)
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	s, err := ParseScript(`
+		# split, then mend
+		0s    partition a b
+		150ms heal_all
+		1s    profile reliable
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Steps) != 3 || s.Steps[0].Op != "partition" || s.Steps[1].At != 150*time.Millisecond ||
+		len(s.Steps[0].Args) != 2 {
+		t.Fatalf("parsed %+v", s.Steps)
+	}
+	for _, bad := range []string{"0s", "soon heal_all", "0s explode a", "0s partition a", "0s heal_all x"} {
+		if _, err := ParseScript(bad); err == nil {
+			t.Errorf("parsed %q", bad)
+		}
+	}
+}
+
+func TestPlayScript(t *testing.T) {
+	rn := makeTwoSides(t)
+	ran := false
+	s := &Script{}
+	s.At(500*time.Millisecond, "heal_all").At(0, "partition", "a", "b").Do(510*time.Millisecond, func() { ran = true })
+	done := rn.Play(s)
+
+	time.Sleep(20 * time.Millisecond)
+	if ab, ba := reach(rn); ab || ba {
+		t.Fatal("calls crossed the partition of the first step")
+	}
+	<-done
+	if ab, ba := reach(rn); !ab || !ba {
+		t.Fatal("calls failed after the script healed the network")
+	}
+	if !ran {
+		t.Fatal("Do step did not run")
+	}
+}
+
+func TestPlayStopsOnCleanup(t *testing.T) {
+	rn := MakeNetwork()
+	done := rn.Play((&Script{}).At(time.Hour, "heal_all"))
+	rn.Cleanup()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("script kept playing after Cleanup")
+	}
+}
@@ -0,0 +1,163 @@
+package testnet
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// what a link does to the calls that cross it. a link runs from a
+// ClientEnd to a server; requests go one way and replies the other,
+// so blocking only one direction makes an asymmetric partition.
+type FaultProfile struct {
+	Request_loss    float64 // chance a request is lost
+	Reply_loss      float64 // chance a reply is lost
+	Latency         Latency // added to every request and every reply
+	Duplication     float64 // chance a request is delivered twice
+	Reorder         float64 // chance a reply is held back, overtaking none of the others
+	Reorder_latency Latency // how long a held back reply waits
+	Bandwidth       int64   // bytes per second in each direction, 0 for no limit
+	Block_requests  bool    // no request gets through
+	Block_replies   bool    // requests get through, their replies do not
+}
+
+// a distribution of delays: "constant" (Mean), "uniform" (Min to Max),
+// "normal" (Mean, Stddev) or "exponential" (Min plus an exponential
+// with mean Mean). samples are clipped to [Min, Max], Max 0 meaning
+// no upper bound.
+type Latency struct {
+	Distribution string
+	Min          time.Duration
+	Max          time.Duration
+	Mean         time.Duration
+	Stddev       time.Duration
+}
+
+const (
+	ProfileReliable       = "reliable"
+	ProfileUnreliable     = "unreliable"      // the labrpc unreliable network
+	ProfileLongReordering = "long_reordering" // the labrpc long reordering
+)
+
+func builtinProfiles() map[string]*FaultProfile {
+	return map[string]*FaultProfile{
+		ProfileReliable: {},
+		ProfileUnreliable: {
+			Request_loss: 0.1,
+			Reply_loss:   0.1,
+			Latency:      Latency{Distribution: "uniform", Max: 27 * time.Millisecond},
+		},
+		ProfileLongReordering: {
+			Reorder: 2.0 / 3,
+			Reorder_latency: Latency{
+				Distribution: "exponential",
+				Min:          200 * time.Millisecond,
+				Mean:         500 * time.Millisecond,
+				Max:          2200 * time.Millisecond,
+			},
+		},
+	}
+}
+
+func (l Latency) sample(rng *rand.Rand) time.Duration {
+	var d float64
+	switch l.Distribution {
+	case "", "constant":
+		d = float64(l.Mean)
+	case "uniform":
+		d = float64(l.Min) + rng.Float64()*float64(l.Max-l.Min)
+	case "normal":
+		d = float64(l.Mean) + rng.NormFloat64()*float64(l.Stddev)
+	case "exponential":
+		d = float64(l.Min) + rng.ExpFloat64()*float64(l.Mean)
+	}
+	d = math.Max(d, float64(l.Min))
+	if l.Max > 0 {
+		d = math.Min(d, float64(l.Max))
+	}
+	return time.Duration(d)
+}
+
+// the time n bytes take at bandwidth bytes per second.
+func transmitTime(n int, bandwidth int64) time.Duration {
+	if bandwidth <= 0 {
+		return 0
+	}
+	return time.Duration(float64(n) / float64(bandwidth) * float64(time.Second))
+}
+
+// a random source for the n-th call over a link. the draws for a
+// call depend only on the seed, the link and n, not on how calls on
+// different links interleave, so a run can be replayed exactly.
+func linkRand(seed int64, l link, n int64) *rand.Rand {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%v\x00%v\x00%d", l.endname, l.servername, n)
+	return rand.New(rand.NewSource(seed ^ int64(h.Sum64())))
+}
+
+type jsonLatency struct {
+	Distribution string  `json:"distribution"`
+	Min_ms       float64 `json:"min_ms"`
+	Max_ms       float64 `json:"max_ms"`
+	Mean_ms      float64 `json:"mean_ms"`
+	Stddev_ms    float64 `json:"stddev_ms"`
+}
+
+type jsonProfile struct {
+	Request_loss    float64     `json:"request_loss"`
+	Reply_loss      float64     `json:"reply_loss"`
+	Latency         jsonLatency `json:"latency"`
+	Duplication     float64     `json:"duplication"`
+	Reorder         float64     `json:"reorder"`
+	Reorder_latency jsonLatency `json:"reorder_latency"`
+	Bandwidth       int64       `json:"bandwidth"`
+	Block_requests  bool        `json:"block_requests"`
+	Block_replies   bool        `json:"block_replies"`
+}
+
+func (l jsonLatency) transfer() Latency {
+	ms := func(v float64) time.Duration { return time.Duration(v * float64(time.Millisecond)) }
+	return Latency{
+		Distribution: l.Distribution,
+		Min:          ms(l.Min_ms),
+		Max:          ms(l.Max_ms),
+		Mean:         ms(l.Mean_ms),
+		Stddev:       ms(l.Stddev_ms),
+	}
+}
+
+// parse profiles written as a JSON object of name -> profile, e.g.
+//
+//	{"lossy": {"request_loss": 0.2, "latency": {"distribution": "normal", "mean_ms": 20, "stddev_ms": 5}}}
+func ParseProfiles(text string) (map[string]*FaultProfile, error) {
+	conf := map[string]jsonProfile{}
+	if err := json.NewDecoder(strings.NewReader(text)).Decode(&conf); err != nil {
+		return nil, err
+	}
+	profiles := map[string]*FaultProfile{}
+	for name, p := range conf {
+		for _, d := range []string{p.Latency.Distribution, p.Reorder_latency.Distribution} {
+			switch d {
+			case "", "constant", "uniform", "normal", "exponential":
+			default:
+				return nil, fmt.Errorf("testnet: profile %v: unknown distribution %q", name, d)
+			}
+		}
+		profiles[name] = &FaultProfile{
+			Request_loss:    p.Request_loss,
+			Reply_loss:      p.Reply_loss,
+			Latency:         p.Latency.transfer(),
+			Duplication:     p.Duplication,
+			Reorder:         p.Reorder,
+			Reorder_latency: p.Reorder_latency.transfer(),
+			Bandwidth:       p.Bandwidth,
+			Block_requests:  p.Block_requests,
+			Block_replies:   p.Block_replies,
+		}
+	}
+	return profiles, nil
+}
@@ -0,0 +1,153 @@
+package testnet
+
+import (
+	"srpc/common/protocol"
+	"srpc/server"
+	"testing"
+	"time"
+)
+
+func TestLatencyStaysInBounds(t *testing.T) {
+	rng := linkRand(1, link{"e", "s"}, 1)
+	cases := []Latency{
+		{Distribution: "uniform", Min: 5 * time.Millisecond, Max: 10 * time.Millisecond},
+		{Distribution: "normal", Mean: 20 * time.Millisecond, Stddev: 50 * time.Millisecond, Max: 40 * time.Millisecond},
+		{Distribution: "exponential", Min: time.Millisecond, Mean: 10 * time.Millisecond, Max: 30 * time.Millisecond},
+	}
+	for _, l := range cases {
+		for i := 0; i < 1000; i++ {
+			if d := l.sample(rng); d < l.Min || d > l.Max {
+				t.Fatalf("%v latency sampled %v, outside [%v, %v]", l.Distribution, d, l.Min, l.Max)
+			}
+		}
+	}
+	if d := (Latency{Mean: 7 * time.Millisecond}).sample(rng); d != 7*time.Millisecond {
+		t.Fatalf("constant latency sampled %v", d)
+	}
+}
+
+func TestLinkRandReplays(t *testing.T) {
+	l := link{"e", "s"}
+	a, b := linkRand(42, l, 3), linkRand(42, l, 3)
+	for i := 0; i < 10; i++ {
+		if a.Int63() != b.Int63() {
+			t.Fatal("same seed, link and call drew differently")
+		}
+	}
+	if linkRand(42, l, 3).Int63() == linkRand(42, l, 4).Int63() {
+		t.Fatal("calls on a link drew alike")
+	}
+	if linkRand(42, l, 3).Int63() == linkRand(42, link{"e", "t"}, 3).Int63() {
+		t.Fatal("links drew alike")
+	}
+}
+
+func TestParseProfiles(t *testing.T) {
+	profiles, err := ParseProfiles(`{"lossy": {"request_loss": 0.2, "bandwidth": 1000,
+		"latency": {"distribution": "normal", "mean_ms": 20, "stddev_ms": 2.5}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := profiles["lossy"]
+	if p == nil || p.Request_loss != 0.2 || p.Bandwidth != 1000 || p.Latency.Distribution != "normal" ||
+		p.Latency.Mean != 20*time.Millisecond || p.Latency.Stddev != 2500*time.Microsecond {
+		t.Fatalf("parsed %+v", p)
+	}
+	if _, err := ParseProfiles(`{"bad": {"latency": {"distribution": "pareto"}}}`); err == nil {
+		t.Fatal("unknown distribution accepted")
+	}
+}
+
+func TestLinkProfileChoice(t *testing.T) {
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+	l, other := link{"e1", "s1"}, link{"e2", "s1"}
+	profile := func(l link) *FaultProfile {
+		rn.mu.Lock()
+		defer rn.mu.Unlock()
+		return rn.linkProfile(l)
+	}
+
+	if p := profile(l); p.Request_loss != 0 || p.Reorder != 0 {
+		t.Fatalf("reliable network: %+v", p)
+	}
+	rn.Reliable(false)
+	rn.LongReordering(true)
+	if p := profile(l); p.Request_loss != 0.1 || p.Reorder == 0 {
+		t.Fatalf("unreliable, long reordering network: %+v", p)
+	}
+
+	rn.DefineProfile("cut", &FaultProfile{Block_requests: true})
+	if err := rn.UseProfile("nonesuch"); err == nil {
+		t.Fatal("used an unknown profile")
+	}
+	if err := rn.UseProfile(ProfileReliable); err != nil {
+		t.Fatal(err)
+	}
+	if err := rn.UseLinkProfile("e1", "s1", "cut"); err != nil {
+		t.Fatal(err)
+	}
+	if !profile(l).Block_requests || profile(other).Block_requests || profile(other).Request_loss != 0 {
+		t.Fatal("link profile not applied to its link alone")
+	}
+	rn.UseLinkProfile("e1", "s1", "")
+	if profile(l).Block_requests {
+		t.Fatal("link profile kept after it was lifted")
+	}
+}
+
+func TestTransmitQueuesOnLane(t *testing.T) {
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+	ln := lane{link{"e", "s"}, false}
+	if d := transmitTime(500, 1000); d != 500*time.Millisecond {
+		t.Fatalf("500 bytes at 1000/s took %v", d)
+	}
+	first := rn.transmit(ln, 100, 1000)
+	second := rn.transmit(ln, 100, 1000)
+	if second-first < 90*time.Millisecond {
+		t.Fatalf("second send waited %v, the first %v", second, first)
+	}
+	if d := rn.transmit(lane{ln.link, true}, 100, 1000); d > 100*time.Millisecond {
+		t.Fatalf("reply lane waited on requests: %v", d)
+	}
+}
+
+// send one call from e1 to s1 and return the code of its reply.
+func sendCall(rn *Network) protocol.Code {
+	req := protocol.ReqMsg{Endname: "e1", SvcMeth: "KV.Get", ReplyCh: make(chan protocol.ReplyMsg, 1)}
+	rn.Send("s1", req)
+	return (<-req.ReplyCh).Code
+}
+
+func TestProfilesDropCalls(t *testing.T) {
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+	rs, _ := server.MakeServer()
+	rn.MakeEnd("e1")
+	rn.Enable("e1", true)
+	rn.AddServer("s1", rs)
+	rn.DefineProfile("lose_requests", &FaultProfile{Request_loss: 1})
+	rn.DefineProfile("lose_replies", &FaultProfile{Reply_loss: 1})
+
+	rn.UseProfile("lose_requests")
+	if code := sendCall(rn); code != protocol.Unavailable {
+		t.Fatalf("lost request: %v", code)
+	}
+	if n := rn.GetCount("s1"); n != 0 {
+		t.Fatalf("server saw %d lost requests", n)
+	}
+
+	rn.UseProfile("lose_replies")
+	if code := sendCall(rn); code != protocol.DeadlineExceeded {
+		t.Fatalf("lost reply: %v", code)
+	}
+	if n := rn.GetCount("s1"); n != 1 {
+		t.Fatalf("server saw %d requests whose replies were lost, want 1", n)
+	}
+
+	rn.UseProfile(ProfileReliable)
+	if code := sendCall(rn); code == protocol.Unavailable || code == protocol.DeadlineExceeded {
+		t.Fatalf("reliable call: %v", code)
+	}
+}
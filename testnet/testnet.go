@@ -0,0 +1,531 @@
+package testnet
+
+//
+// a simulated network for testing distributed code built on srpc,
+// after the labrpc network of MIT 6.824. it is a Transport: real
+// ClientEnds send their calls through it, and it hands them to real
+// Servers, losing, delaying and reordering them as told.
+//
+// net := MakeNetwork() -- holds ClientEnds, servers, links.
+// end := net.MakeEnd(endname) -- a ClientEnd whose calls go through net.
+// net.AddServer(servername, server) -- servername is the "host:port"
+//   that ClientEnds have the server at.
+// net.DeleteServer(servername) -- calls to it fail from now on.
+// net.Connect(endname, servername) -- send every call of the end to
+//   one server, whatever endpoint it was meant for.
+// net.Enable(endname, enabled) -- enable/disable a ClientEnd.
+// net.Partition(endname, servername) -- cut one link; Heal mends it.
+// net.DefineGroup(name, members...) -- name ends and servers together,
+//   to partition, isolate or heal as a whole, now or by a Script.
+// net.Reliable(bool) -- false means drop/delay messages.
+// net.Seed(seed) -- make every random choice replayable.
+// net.DefineProfile(name, profile), net.UseProfile(name),
+// net.UseLinkProfile(endname, servername, name) -- fault profiles
+//   for all links, or for one.
+//
+// an end is disabled until Enable is called, as in labrpc.
+//
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"srpc/client"
+	"srpc/common/logging"
+	"srpc/common/protocol"
+	"srpc/server"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type link struct {
+	endname    interface{}
+	servername interface{}
+}
+
+// one direction of a link, for bandwidth accounting.
+type lane struct {
+	link
+	reply bool
+}
+
+type Network struct {
+	mu             sync.Mutex
+	reliable       bool
+	longDelays     bool                              // pause a long time on send on disabled connection
+	longReordering bool                              // sometimes delay replies a long time
+	ends           map[interface{}]*client.ClientEnd // ends, by name
+	enabled        map[interface{}]bool              // by end name
+	servers        map[interface{}]*server.Server    // servers, by name
+	connections    map[interface{}]interface{}       // endname -> servername
+	blocked        map[lane]bool                     // lanes partitioned away
+	groups         map[string][]interface{}          // group name -> end and server names
+	counts         map[interface{}]int               // servername -> calls delivered
+	profiles       map[string]*FaultProfile          // by name
+	profile        string                            // for links without one; "" follows Reliable and LongReordering
+	linkProfiles   map[link]string                   // link -> profile name
+	seed           int64
+	calls          map[link]int64     // link -> calls sent over it
+	busy           map[lane]time.Time // lane -> when it has sent what it was given
+	done           chan struct{}      // closed when Network is cleaned up
+	closing        sync.Once
+	count          int32              // total RPC count, for statistics
+	bytes          int64              // total bytes send, for statistics
+	logger         logging.Logger     // nil for logging.Default
+}
+
+func MakeNetwork() *Network {
+	rn := &Network{}
+	rn.reliable = true
+	rn.ends = map[interface{}]*client.ClientEnd{}
+	rn.enabled = map[interface{}]bool{}
+	rn.servers = map[interface{}]*server.Server{}
+	rn.connections = map[interface{}](interface{}){}
+	rn.blocked = map[lane]bool{}
+	rn.groups = map[string][]interface{}{}
+	rn.counts = map[interface{}]int{}
+	rn.profiles = builtinProfiles()
+	rn.linkProfiles = map[link]string{}
+	rn.seed = time.Now().UnixNano()
+	rn.calls = map[link]int64{}
+	rn.busy = map[lane]time.Time{}
+	rn.done = make(chan struct{})
+	return rn
+}
+
+// where the network logs, e.g. steps of a script that failed; nil
+// for logging.Default.
+func (rn *Network) SetLogger(l logging.Logger) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	rn.logger = l
+}
+
+func (rn *Network) log() logging.Logger {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	return logging.Or(rn.logger)
+}
+
+func (rn *Network) Cleanup() {
+	rn.closing.Do(func() { close(rn.done) })
+}
+
+func (rn *Network) Reliable(yes bool) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.reliable = yes
+}
+
+func (rn *Network) LongReordering(yes bool) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.longReordering = yes
+}
+
+func (rn *Network) LongDelays(yes bool) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.longDelays = yes
+}
+
+// the Transport side: ClientEnds made by MakeEnd call this.
+func (rn *Network) Send(addr string, req protocol.ReqMsg) {
+	atomic.AddInt32(&rn.count, 1)
+	atomic.AddInt64(&rn.bytes, int64(len(req.Args)))
+	select {
+	case <-rn.done:
+		req.ReplyCh <- protocol.ReplyMsg{Ok: false, Code: protocol.Unavailable}
+	default:
+		go rn.processReq(addr, req)
+	}
+}
+
+func (rn *Network) readEndnameInfo(endname interface{}, addr string) (enabled bool,
+	servername interface{}, server *server.Server, profile *FaultProfile, rng *rand.Rand,
+) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	enabled = rn.enabled[endname]
+	servername = rn.connections[endname]
+	if servername == nil {
+		servername = addr
+	}
+	l := link{endname, servername}
+	if rn.blocked[lane{l, false}] {
+		enabled = false
+	}
+	server = rn.servers[servername]
+	profile = rn.linkProfile(l)
+	rn.calls[l] += 1
+	rng = linkRand(rn.seed, l, rn.calls[l])
+	return
+}
+
+// the profile in force on a link. the caller holds rn.mu.
+func (rn *Network) linkProfile(l link) *FaultProfile {
+	if name, ok := rn.linkProfiles[l]; ok {
+		return rn.profiles[name]
+	}
+	if rn.profile != "" {
+		return rn.profiles[rn.profile]
+	}
+	p := *rn.profiles[ProfileReliable]
+	if !rn.reliable {
+		p = *rn.profiles[ProfileUnreliable]
+	}
+	if rn.longReordering {
+		lr := rn.profiles[ProfileLongReordering]
+		p.Reorder = lr.Reorder
+		p.Reorder_latency = lr.Reorder_latency
+	}
+	return &p
+}
+
+// how long n bytes on a lane take to arrive at bandwidth, waiting
+// for what is already on its way. the lane counts as busy until then.
+func (rn *Network) transmit(ln lane, n int, bandwidth int64) time.Duration {
+	if bandwidth <= 0 {
+		return 0
+	}
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	now := time.Now()
+	start := rn.busy[ln]
+	if start.Before(now) {
+		start = now
+	}
+	rn.busy[ln] = start.Add(transmitTime(n, bandwidth))
+	return rn.busy[ln].Sub(now)
+}
+
+func (rn *Network) isServerDead(endname interface{}, servername interface{}, server *server.Server) bool {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if rn.enabled[endname] == false || rn.servers[servername] != server || rn.blocked[lane{link{endname, servername}, false}] {
+		return true
+	}
+	return false
+}
+
+func (rn *Network) processReq(addr string, req protocol.ReqMsg) {
+	enabled, servername, server, p, rng := rn.readEndnameInfo(req.Endname, addr)
+	l := link{req.Endname, servername}
+
+	if enabled && servername != nil && server != nil && !p.Block_requests {
+		// the request's trip to the server.
+		delay := p.Latency.sample(rng) + rn.transmit(lane{l, false}, len(req.Args), p.Bandwidth)
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+
+		if rng.Float64() < p.Request_loss {
+			// drop the request, return as if timeout
+			req.ReplyCh <- protocol.ReplyMsg{Ok: false, Code: protocol.Unavailable}
+			return
+		}
+
+		rn.mu.Lock()
+		rn.counts[servername] += 1
+		if rng.Float64() < p.Duplication {
+			// the server sees the request twice; only one
+			// reply can make it back.
+			rn.counts[servername] += 1
+			go server.Dispatch(req)
+		}
+		rn.mu.Unlock()
+
+		// execute the request (call the RPC handler).
+		// in a separate thread so that we can periodically check
+		// if the server has been killed and the RPC should get a
+		// failure reply.
+		ech := make(chan protocol.ReplyMsg)
+		go func() {
+			r := server.Dispatch(req)
+			ech <- r
+		}()
+
+		// wait for handler to return,
+		// but stop waiting if DeleteServer() has been called,
+		// and return an error.
+		var reply protocol.ReplyMsg
+		replyOK := false
+		serverDead := false
+		for replyOK == false && serverDead == false {
+			select {
+			case reply = <-ech:
+				replyOK = true
+			case <-time.After(100 * time.Millisecond):
+				serverDead = rn.isServerDead(req.Endname, servername, server)
+				if serverDead {
+					go func() {
+						<-ech // drain channel to let the goroutine created earlier terminate
+					}()
+				}
+			}
+		}
+
+		// do not reply if DeleteServer() has been called, i.e.
+		// the server has been killed. this is needed to avoid
+		// situation in which a client gets a positive reply
+		// to an Append, but the server persisted the update
+		// into the old Persister.
+		serverDead = rn.isServerDead(req.Endname, servername, server)
+
+		if replyOK == false || serverDead == true {
+			// server was killed while we were waiting; return error.
+			req.ReplyCh <- protocol.ReplyMsg{Ok: false, Code: protocol.DeadlineExceeded}
+		} else if p.Block_replies || rn.isBlocked(lane{l, true}) || rng.Float64() < p.Reply_loss {
+			// drop the reply, return as if timeout
+			req.ReplyCh <- protocol.ReplyMsg{Ok: false, Code: protocol.DeadlineExceeded}
+		} else {
+			// the reply's trip back, perhaps held back long
+			// enough for later replies to overtake it.
+			delay := p.Latency.sample(rng) + rn.transmit(lane{l, true}, len(reply.Reply), p.Bandwidth)
+			if rng.Float64() < p.Reorder {
+				delay += p.Reorder_latency.sample(rng)
+			}
+			// Russ points out that this timer arrangement will decrease
+			// the number of goroutines, so that the race
+			// detector is less likely to get upset.
+			time.AfterFunc(delay, func() {
+				atomic.AddInt64(&rn.bytes, int64(len(reply.Reply)))
+				req.ReplyCh <- reply
+			})
+		}
+	} else {
+		// simulate no reply and eventual timeout.
+		ms := 0
+		if rn.longDelays {
+			// let Raft tests check that leader doesn't send
+			// RPCs synchronously.
+			ms = (rng.Int() % 7000)
+		} else {
+			// many kv tests require the client to try each
+			// server in fairly rapid succession.
+			ms = (rng.Int() % 100)
+		}
+		time.AfterFunc(time.Duration(ms)*time.Millisecond, func() {
+			req.ReplyCh <- protocol.ReplyMsg{Ok: false, Code: protocol.Unavailable}
+		})
+	}
+
+}
+
+// make every random choice of the network, from now on, follow
+// seed, so that a failing run can be replayed with the same seed.
+func (rn *Network) Seed(seed int64) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.seed = seed
+	rn.calls = map[link]int64{}
+}
+
+// make a profile available by name; an existing one is replaced.
+func (rn *Network) DefineProfile(name string, p *FaultProfile) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.profiles[name] = p
+}
+
+// define the profiles in a JSON object of name -> profile,
+// as read by ParseProfiles.
+func (rn *Network) LoadProfiles(text string) error {
+	profiles, err := ParseProfiles(text)
+	if err != nil {
+		return err
+	}
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	for name, p := range profiles {
+		rn.profiles[name] = p
+	}
+	return nil
+}
+
+// apply a profile to every link that has none of its own.
+// "" goes back to following Reliable and LongReordering.
+func (rn *Network) UseProfile(name string) error {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if _, ok := rn.profiles[name]; !ok && name != "" {
+		return fmt.Errorf("testnet: unknown profile %q", name)
+	}
+	rn.profile = name
+	return nil
+}
+
+// apply a profile to the link from a ClientEnd to a server.
+// "" makes the link use the network's profile again.
+func (rn *Network) UseLinkProfile(endname interface{}, servername interface{}, name string) error {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if name == "" {
+		delete(rn.linkProfiles, link{endname, servername})
+		return nil
+	}
+	if _, ok := rn.profiles[name]; !ok {
+		return fmt.Errorf("testnet: unknown profile %q", name)
+	}
+	rn.linkProfiles[link{endname, servername}] = name
+	return nil
+}
+
+// create a client end whose calls go through the network.
+// start the end off as disabled.
+func (rn *Network) MakeEnd(endname interface{}) *client.ClientEnd {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if _, ok := rn.ends[endname]; ok {
+		panic("MakeEnd: duplicate name")
+	}
+
+	e, _ := client.MakeClientEnd()
+	e.SetEndname(endname)
+	e.SetTransport(rn)
+	rn.ends[endname] = e
+	rn.enabled[endname] = false
+	rn.connections[endname] = nil
+
+	return e
+}
+
+// servername is the "host:port" ClientEnds know the server by.
+func (rn *Network) AddServer(servername interface{}, rs *server.Server) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.servers[servername] = rs
+	for endname, sn := range rn.connections {
+		if sn == servername {
+			rn.announce(endname, servername)
+		}
+	}
+}
+
+func (rn *Network) DeleteServer(servername interface{}) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.servers[servername] = nil
+}
+
+// connect a ClientEnd to a server: every call the end makes goes
+// to that server. the end learns the server's methods, so that it
+// can call them without any other configuration.
+func (rn *Network) Connect(endname interface{}, servername interface{}) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.connections[endname] = servername
+	rn.announce(endname, servername)
+}
+
+// tell a connected end about the methods of its server.
+// the caller holds rn.mu.
+func (rn *Network) announce(endname interface{}, servername interface{}) {
+	e := rn.ends[endname]
+	rs := rn.servers[servername]
+	if e == nil || rs == nil {
+		return
+	}
+	name, _ := servername.(string)
+	host, port, err := net.SplitHostPort(name)
+	if err != nil {
+		host, port = name, ""
+	}
+	for _, svcMeth := range rs.Methods() {
+		dot := strings.LastIndex(svcMeth, ".")
+		e.AddService(&client.Service{
+			Service_name:    svcMeth[:dot],
+			Method_name:     svcMeth[dot+1:],
+			Server_ip:       host,
+			Server_port:     port,
+			Service_enabled: true,
+		})
+	}
+}
+
+// enable/disable a ClientEnd.
+func (rn *Network) Enable(endname interface{}, enabled bool) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.enabled[endname] = enabled
+}
+
+// cut the link from a ClientEnd to a server: its calls to that
+// server are lost, while its calls to other servers go through.
+func (rn *Network) Partition(endname interface{}, servername interface{}) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.block(link{endname, servername}, true, true)
+}
+
+// mend a link cut by Partition.
+func (rn *Network) Heal(endname interface{}, servername interface{}) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.block(link{endname, servername}, false, false)
+}
+
+// mend every link cut by Partition.
+func (rn *Network) HealAll() {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.blocked = map[lane]bool{}
+}
+
+// block the requests and/or the replies crossing a link.
+// the caller holds rn.mu.
+func (rn *Network) block(l link, requests bool, replies bool) {
+	for _, ln := range []lane{{l, false}, {l, true}} {
+		if (ln.reply && replies) || (!ln.reply && requests) {
+			rn.blocked[ln] = true
+		} else {
+			delete(rn.blocked, ln)
+		}
+	}
+}
+
+func (rn *Network) isBlocked(ln lane) bool {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.blocked[ln]
+}
+
+// get a count of RPCs delivered to a server.
+func (rn *Network) GetCount(servername interface{}) int {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	return rn.counts[servername]
+}
+
+func (rn *Network) GetTotalCount() int {
+	x := atomic.LoadInt32(&rn.count)
+	return int(x)
+}
+
+func (rn *Network) GetTotalBytes() int64 {
+	x := atomic.LoadInt64(&rn.bytes)
+	return x
+}
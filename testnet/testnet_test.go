@@ -0,0 +1,121 @@
+package testnet
+
+import (
+	"srpc/common/service"
+	"srpc/server"
+	"testing"
+)
+
+type KV struct{}
+
+func (kv *KV) Get(key string, value *string) {
+	*value = "v:" + key
+}
+
+// a network with end e1 connected to a KV server s1.
+func makeKVNetwork(t *testing.T) *Network {
+	rn := MakeNetwork()
+	t.Cleanup(rn.Cleanup)
+	rs, _ := server.MakeServer()
+	rs.AddService(service.MakeService(&KV{}))
+	rn.MakeEnd("e1")
+	rn.AddServer("s1", rs)
+	rn.Connect("e1", "s1")
+	rn.Enable("e1", true)
+	return rn
+}
+
+func get(rn *Network, endname string) (string, bool) {
+	rn.mu.Lock()
+	e := rn.ends[endname]
+	rn.mu.Unlock()
+	var value string
+	ok := e.Call("KV.Get", "k", &value)
+	return value, ok
+}
+
+func TestCallThroughNetwork(t *testing.T) {
+	rn := makeKVNetwork(t)
+	if v, ok := get(rn, "e1"); !ok || v != "v:k" {
+		t.Fatalf("call returned %q, %v", v, ok)
+	}
+	if n := rn.GetCount("s1"); n != 1 {
+		t.Fatalf("server count %d, want 1", n)
+	}
+	if n := rn.GetTotalCount(); n != 1 {
+		t.Fatalf("total count %d, want 1", n)
+	}
+	if rn.GetTotalBytes() == 0 {
+		t.Fatal("no bytes counted")
+	}
+}
+
+func TestDisabledAndPartitionedEndsFail(t *testing.T) {
+	rn := makeKVNetwork(t)
+
+	rn.Enable("e1", false)
+	if _, ok := get(rn, "e1"); ok {
+		t.Fatal("disabled end got a reply")
+	}
+	rn.Enable("e1", true)
+
+	rn.Partition("e1", "s1")
+	if _, ok := get(rn, "e1"); ok {
+		t.Fatal("partitioned end got a reply")
+	}
+	if n := rn.GetCount("s1"); n != 0 {
+		t.Fatalf("server saw %d calls across a partition", n)
+	}
+	rn.Heal("e1", "s1")
+	if _, ok := get(rn, "e1"); !ok {
+		t.Fatal("healed link failed")
+	}
+
+	rn.Partition("e1", "s1")
+	rn.HealAll()
+	if _, ok := get(rn, "e1"); !ok {
+		t.Fatal("link failed after HealAll")
+	}
+}
+
+func TestDeletedServerFails(t *testing.T) {
+	rn := makeKVNetwork(t)
+	rn.DeleteServer("s1")
+	if _, ok := get(rn, "e1"); ok {
+		t.Fatal("deleted server replied")
+	}
+
+	// a server added back under its name serves again.
+	rs, _ := server.MakeServer()
+	rs.AddService(service.MakeService(&KV{}))
+	rn.AddServer("s1", rs)
+	if _, ok := get(rn, "e1"); !ok {
+		t.Fatal("replacement server failed")
+	}
+}
+
+func TestMakeEndTwicePanics(t *testing.T) {
+	rn := MakeNetwork()
+	defer rn.Cleanup()
+	rn.MakeEnd("e1")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate end made")
+		}
+	}()
+	rn.MakeEnd("e1")
+}
+
+func TestCleanupTwice(t *testing.T) {
+	rn := MakeNetwork()
+	rn.Cleanup()
+	rn.Cleanup()
+}
+
+func TestCallsFailAfterCleanup(t *testing.T) {
+	rn := makeKVNetwork(t)
+	rn.Cleanup()
+	if _, ok := get(rn, "e1"); ok {
+		t.Fatal("call succeeded after Cleanup")
+	}
+}